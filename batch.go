@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Limits for ask_batch
+const (
+	defaultBatchConcurrency = 4
+	maxBatchConcurrency     = 16
+	maxBatchItems           = 500
+)
+
+type AskBatchArguments struct {
+	Provider    string              `json:"provider" jsonschema:"required,description=Provider to ask: claude\\, openai\\, gemini\\, mistral or huggingface"`
+	Model       string              `json:"model" jsonschema:"description=Model to use (default: the provider's default model)"`
+	Prompts     []string            `json:"prompts" jsonschema:"description=Prompts to run; use either prompts or template with rows"`
+	Template    string              `json:"template" jsonschema:"description=Go text/template rendered once per row\\, e.g. 'Translate {{.word}} to {{.lang}}'"`
+	Rows        []map[string]string `json:"rows" jsonschema:"description=Variables for each run of template"`
+	Concurrency int                 `json:"concurrency" jsonschema:"description=How many prompts run at once (default: 4\\, max: 16)"`
+	MaxTokens   int                 `json:"max_tokens" jsonschema:"description=Maximum tokens per answer (default: the provider's default)"`
+	OutputFile  string              `json:"output_file" jsonschema:"description=Also write one JSON result per line to this file as each prompt finishes"`
+	SessionID   string              `json:"session_id" jsonschema:"description=History session to record the exchanges under (default: this server run's session)"`
+}
+
+// BatchItemResult is the outcome of one prompt in a batch
+type BatchItemResult struct {
+	Index        int    `json:"index"`
+	Prompt       string `json:"prompt"`
+	Provider     string `json:"provider"`
+	Model        string `json:"model,omitempty"`
+	Answer       string `json:"answer,omitempty"`
+	FinishReason string `json:"finish_reason,omitempty"`
+	Truncated    bool   `json:"truncated,omitempty"`
+	InputTokens  int    `json:"input_tokens,omitempty"`
+	OutputTokens int    `json:"output_tokens,omitempty"`
+	LatencyMS    int64  `json:"latency_ms"`
+	Error        string `json:"error,omitempty"`
+}
+
+// BatchResult is the ask_batch answer: every item in input order plus totals
+type BatchResult struct {
+	Provider     string            `json:"provider"`
+	Total        int               `json:"total"`
+	Succeeded    int               `json:"succeeded"`
+	Failed       int               `json:"failed"`
+	InputTokens  int               `json:"input_tokens"`
+	OutputTokens int               `json:"output_tokens"`
+	DurationMS   int64             `json:"duration_ms"`
+	OutputFile   string            `json:"output_file,omitempty"`
+	OutputError  string            `json:"output_error,omitempty"`
+	Results      []BatchItemResult `json:"results"`
+}
+
+// batchPrompts returns the prompts to run, either given directly or rendered
+// from the template once per row
+func batchPrompts(args AskBatchArguments) ([]string, error) {
+	if len(args.Prompts) > 0 && args.Template != "" {
+		return nil, fmt.Errorf("use either prompts or template with rows, not both")
+	}
+
+	prompts := args.Prompts
+	if args.Template != "" {
+		if len(args.Rows) == 0 {
+			return nil, fmt.Errorf("template needs at least one row of variables")
+		}
+		tmpl, err := template.New("batch").Option("missingkey=error").Parse(args.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %v", err)
+		}
+		for i, row := range args.Rows {
+			var out bytes.Buffer
+			if err := tmpl.Execute(&out, row); err != nil {
+				return nil, fmt.Errorf("row %d: %v", i, err)
+			}
+			prompts = append(prompts, out.String())
+		}
+	}
+
+	if len(prompts) == 0 {
+		return nil, fmt.Errorf("no prompts given: pass prompts or template with rows")
+	}
+	if len(prompts) > maxBatchItems {
+		return nil, fmt.Errorf("too many prompts: %d (max %d)", len(prompts), maxBatchItems)
+	}
+	return prompts, nil
+}
+
+// askBatch runs every prompt against one provider with bounded concurrency. A
+// failing item only fails that item; its error is reported in its result.
+func askBatch(ctx context.Context, args AskBatchArguments) (*BatchResult, error) {
+	name := strings.ToLower(strings.TrimSpace(args.Provider))
+	if _, ok := providers[name]; !ok {
+		return nil, fmt.Errorf("unknown provider %q", args.Provider)
+	}
+	if err := validateSessionID(args.SessionID); err != nil {
+		return nil, err
+	}
+	prompts, err := batchPrompts(args)
+	if err != nil {
+		return nil, err
+	}
+
+	concurrency := defaultInt(args.Concurrency, defaultBatchConcurrency)
+	if concurrency > maxBatchConcurrency {
+		concurrency = maxBatchConcurrency
+	}
+
+	// The file is written as items finish, so a crash or a failed write keeps the
+	// answers already paid for
+	var output *batchOutput
+	if args.OutputFile != "" {
+		output = openBatchOutput(args.OutputFile)
+	}
+
+	start := time.Now()
+	results := make([]BatchItemResult, len(prompts))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, prompt := range prompts {
+		wg.Add(1)
+		go func(i int, prompt string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			results[i] = askBatchItem(ctx, args, name, i, prompt)
+			if output != nil {
+				output.write(results[i])
+			}
+		}(i, prompt)
+	}
+	wg.Wait()
+
+	batch := &BatchResult{Provider: name, Total: len(results), Results: results, DurationMS: time.Since(start).Milliseconds()}
+	for _, r := range results {
+		if r.Error != "" {
+			batch.Failed++
+			continue
+		}
+		batch.Succeeded++
+		batch.InputTokens += r.InputTokens
+		batch.OutputTokens += r.OutputTokens
+	}
+
+	if output != nil {
+		if err := output.close(); err != nil {
+			batch.OutputError = redactSecrets(fmt.Sprintf("writing %s: %v", args.OutputFile, err))
+		} else {
+			batch.OutputFile = args.OutputFile
+		}
+	}
+	return batch, nil
+}
+
+// askBatchItem runs one prompt, turning errors and panics into the item's error
+func askBatchItem(ctx context.Context, args AskBatchArguments, name string, index int, prompt string) (result BatchItemResult) {
+	result = BatchItemResult{Index: index, Prompt: prompt, Provider: name, Model: args.Model}
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("ask_batch item %d panicked: %v\n%s", index, r, debug.Stack())
+			result.Error = "internal error"
+		}
+		result.LatencyMS = time.Since(start).Milliseconds()
+	}()
+
+	if err := ctx.Err(); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	req := completionRequest{Model: args.Model, Messages: userMessage(prompt), MaxTokens: args.MaxTokens}
+	answer, err := askAndRecord(ctx, args.SessionID, name, req, continueOptions{})
+	if err != nil {
+		result.Error = redactError(err).Error()
+		return result
+	}
+
+	result.Model = defaultString(answer.Model, args.Model)
+	result.Answer = answer.Text
+	result.FinishReason = answer.FinishReason
+	result.Truncated = answer.Truncated
+	result.InputTokens = answer.InputTokens
+	result.OutputTokens = answer.OutputTokens
+	return result
+}
+
+// batchOutput appends one JSON line per finished item to the output file. Lines
+// come in completion order; each carries its index. Answers can echo the
+// prompts, so the file is only readable by the server user.
+type batchOutput struct {
+	mu  sync.Mutex
+	f   *os.File
+	err error // the first failure; later writes are skipped
+}
+
+func openBatchOutput(path string) *batchOutput {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	return &batchOutput{f: f, err: err}
+}
+
+func (o *batchOutput) write(r BatchItemResult) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.err != nil {
+		return
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		o.err = err
+		return
+	}
+	_, o.err = o.f.Write(append([]byte(redactSecrets(string(line))), '\n'))
+}
+
+// close closes the file and reports the first error met while writing it
+func (o *batchOutput) close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.f != nil {
+		if err := o.f.Close(); o.err == nil {
+			o.err = err
+		}
+	}
+	return o.err
+}
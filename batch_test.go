@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test a template batch runs every row, isolates failures and writes one JSONL line per item
+func TestAskBatchTemplate(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "test-openai-key")
+	useHistory(t)
+
+	useProviderURL(t, &openAIChatURL, func(w http.ResponseWriter, r *http.Request) {
+		var req OpenAIRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		prompt := req.Messages[0].Content
+		if strings.Contains(prompt, "fail") {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error":{"message":"boom"}}`))
+			return
+		}
+		w.Write([]byte(`{"choices":[{"message":{"content":"echo: ` + prompt + `"},"finish_reason":"stop"}],"usage":{"prompt_tokens":2,"completion_tokens":3}}`))
+	})
+
+	output := filepath.Join(t.TempDir(), "results.jsonl")
+	result, err := askBatch(context.Background(), AskBatchArguments{
+		Provider:    "openai",
+		Template:    "Say {{.word}}",
+		Rows:        []map[string]string{{"word": "one"}, {"word": "fail"}, {"word": "three"}},
+		Concurrency: 2,
+		OutputFile:  output,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if result.Total != 3 || result.Succeeded != 2 || result.Failed != 1 || result.OutputTokens != 6 {
+		t.Errorf("Unexpected totals %+v", result)
+	}
+	if result.Results[0].Answer != "echo: Say one" || result.Results[2].Answer != "echo: Say three" {
+		t.Errorf("Expected results in input order, got %+v", result.Results)
+	}
+	if !strings.Contains(result.Results[1].Error, "boom") {
+		t.Errorf("Expected the failing item to carry its error, got %+v", result.Results[1])
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatalf("Expected the JSONL file to be written: %v", err)
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the JSONL file to be private (0600), got %v", info.Mode().Perm())
+	}
+	seen := map[int]bool{}
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		var item BatchItemResult
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil || seen[item.Index] {
+			t.Errorf("Unexpected line: %s", scanner.Text())
+		}
+		seen[item.Index] = true
+	}
+	if len(seen) != 3 || !seen[0] || !seen[1] || !seen[2] {
+		t.Errorf("Expected one JSONL line for each of indices 0-2, got %v", seen)
+	}
+}
+
+// Test a failing output file is reported without losing the finished results
+func TestAskBatchOutputError(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "test-openai-key")
+	useHistory(t)
+
+	useProviderURL(t, &openAIChatURL, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[{"message":{"content":"ok"},"finish_reason":"stop"}],"usage":{"prompt_tokens":1,"completion_tokens":1}}`))
+	})
+
+	output := filepath.Join(t.TempDir(), "missing", "results.jsonl")
+	result, err := askBatch(context.Background(), AskBatchArguments{
+		Provider:   "openai",
+		Prompts:    []string{"a", "b"},
+		OutputFile: output,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Succeeded != 2 || len(result.Results) != 2 {
+		t.Errorf("Expected both results to be kept, got %+v", result)
+	}
+	if result.OutputFile != "" || !strings.Contains(result.OutputError, output) {
+		t.Errorf("Expected the write failure in output_error, got %+v", result)
+	}
+}
+
+// Test invalid batch arguments are rejected before anything is sent
+func TestAskBatchValidation(t *testing.T) {
+	cases := map[string]AskBatchArguments{
+		"unknown provider": {Provider: "nope", Prompts: []string{"hi"}},
+		"no prompts":       {Provider: "openai"},
+		"both modes":       {Provider: "openai", Prompts: []string{"hi"}, Template: "{{.x}}", Rows: []map[string]string{{"x": "1"}}},
+		"missing variable": {Provider: "openai", Template: "{{.x}}", Rows: []map[string]string{{"y": "1"}}},
+	}
+	for name, args := range cases {
+		if _, err := askBatch(context.Background(), args); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
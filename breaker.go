@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// errUpstreamUnavailable is the code of the error returned while a breaker is open
+const errUpstreamUnavailable = "upstream_unavailable"
+
+// Breaker defaults; BREAKER_FAILURES, BREAKER_ERROR_RATE, BREAKER_WINDOW and
+// BREAKER_COOLDOWN override them
+const (
+	defaultBreakerFailures  = 5
+	defaultBreakerErrorRate = 0.5
+	defaultBreakerWindow    = 20
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// upstreamUnavailableError is returned without calling the provider while its breaker is open
+type upstreamUnavailableError struct {
+	Provider string
+	Reason   string
+	RetryIn  time.Duration
+}
+
+func (e *upstreamUnavailableError) Error() string {
+	return fmt.Sprintf("%s: %s is failing (%s); the circuit breaker is open, retry in %s",
+		errUpstreamUnavailable, e.Provider, e.Reason, e.RetryIn.Round(time.Second))
+}
+
+// BreakerState is a snapshot of one provider's breaker
+type BreakerState struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	ErrorRate           float64    `json:"error_rate"`
+	WindowCalls         int        `json:"window_calls"`
+	Reason              string     `json:"reason,omitempty"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"`
+}
+
+// breaker tracks one provider's recent outcomes. It opens after too many
+// consecutive failures or too high an error rate over the last calls, fails calls
+// fast while open, and after the cooldown lets a single probe through
+// (half-open): success closes it, failure opens it again.
+type breaker struct {
+	state       string
+	consecutive int
+	outcomes    []bool // recent calls, true for failures
+	openedAt    time.Time
+	reason      string
+	probing     bool
+}
+
+// breakerSet holds every provider's breaker
+type breakerSet struct {
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+var providerBreakers = &breakerSet{breakers: map[string]*breaker{}}
+
+func (s *breakerSet) get(name string) *breaker {
+	b := s.breakers[name]
+	if b == nil {
+		b = &breaker{state: breakerClosed}
+		s.breakers[name] = b
+	}
+	return b
+}
+
+// allow reports whether a call to the provider may go ahead. After the cooldown
+// an open breaker turns half-open and admits one probe at a time.
+func (s *breakerSet) allow(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.get(name)
+	cooldown := durationFromEnv("BREAKER_COOLDOWN", defaultBreakerCooldown)
+	if b.state == breakerOpen {
+		if wait := time.Until(b.openedAt.Add(cooldown)); wait > 0 {
+			return &upstreamUnavailableError{Provider: providers[name].Name, Reason: b.reason, RetryIn: wait}
+		}
+		b.state = breakerHalfOpen
+	}
+	if b.state == breakerHalfOpen {
+		if b.probing {
+			return &upstreamUnavailableError{Provider: providers[name].Name, Reason: b.reason + "; a probe call is in flight", RetryIn: time.Second}
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// record feeds a call's outcome to the provider's breaker. Only errors that say
+// the provider itself is in trouble count as failures; bad requests, rejected or
+// rate-limited keys and calls cancelled by the client don't.
+func (s *breakerSet) record(name string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.get(name)
+	failed := breakerFailure(err)
+	if err != nil && !failed {
+		b.probing = false
+		return
+	}
+
+	window := intFromEnv("BREAKER_WINDOW", defaultBreakerWindow)
+	b.outcomes = append(b.outcomes, failed)
+	if len(b.outcomes) > window {
+		b.outcomes = b.outcomes[len(b.outcomes)-window:]
+	}
+
+	if !failed {
+		b.consecutive = 0
+		if b.state == breakerHalfOpen {
+			b.state, b.outcomes, b.reason = breakerClosed, nil, ""
+		}
+		b.probing = false
+		return
+	}
+
+	b.consecutive++
+	switch {
+	case b.state == breakerHalfOpen:
+		b.open("the probe call failed: " + redactError(err).Error())
+	case b.consecutive >= intFromEnv("BREAKER_FAILURES", defaultBreakerFailures):
+		b.open(fmt.Sprintf("%d consecutive failures", b.consecutive))
+	case len(b.outcomes) >= window/2 && b.errorRate() >= floatFromEnv("BREAKER_ERROR_RATE", defaultBreakerErrorRate):
+		b.open(fmt.Sprintf("%.0f%% of the last %d calls failed", b.errorRate()*100, len(b.outcomes)))
+	}
+}
+
+func (b *breaker) open(reason string) {
+	b.state, b.openedAt, b.reason, b.probing = breakerOpen, time.Now(), reason, false
+}
+
+func (b *breaker) errorRate() float64 {
+	if len(b.outcomes) == 0 {
+		return 0
+	}
+	failures := 0
+	for _, failed := range b.outcomes {
+		if failed {
+			failures++
+		}
+	}
+	return float64(failures) / float64(len(b.outcomes))
+}
+
+// state returns a snapshot of the provider's breaker
+func (s *breakerSet) state(name string) BreakerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.get(name)
+	state := BreakerState{State: b.state, ConsecutiveFailures: b.consecutive, ErrorRate: b.errorRate(), WindowCalls: len(b.outcomes), Reason: b.reason}
+	if b.state == breakerOpen {
+		retryAt := b.openedAt.Add(durationFromEnv("BREAKER_COOLDOWN", defaultBreakerCooldown)).UTC()
+		state.RetryAt = &retryAt
+	}
+	return state
+}
+
+// breakerFailure reports whether err means the provider is unhealthy: network
+// errors, timeouts and 5xx answers
+func breakerFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var windowErr *contextWindowError
+	if errors.As(err, &windowErr) {
+		return false
+	}
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500
+	}
+	return true
+}
+
+// intFromEnv reads a positive integer setting, falling back when unset or invalid
+func intFromEnv(name string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil && n > 0 {
+		return n
+	}
+	return fallback
+}
+
+// floatFromEnv reads a positive number setting, falling back when unset or invalid
+func floatFromEnv(name string, fallback float64) float64 {
+	if f, err := strconv.ParseFloat(os.Getenv(name), 64); err == nil && f > 0 {
+		return f
+	}
+	return fallback
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// useBreakers gives the test closed circuit breakers
+func useBreakers(t *testing.T) {
+	original := providerBreakers
+	providerBreakers = &breakerSet{breakers: map[string]*breaker{}}
+	t.Cleanup(func() { providerBreakers = original })
+}
+
+// Test consecutive failures open the breaker, which fails fast and closes after a good probe
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	useBreakers(t)
+	t.Setenv("OPENAI_API_KEY", "test-openai-key")
+	t.Setenv("BREAKER_FAILURES", "3")
+	t.Setenv("BREAKER_COOLDOWN", "50ms")
+
+	calls, down := 0, true
+	useProviderURL(t, &openAIChatURL, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":{"message":"unavailable"}}`))
+			return
+		}
+		w.Write([]byte(`{"choices":[{"message":{"content":"back"},"finish_reason":"stop"}]}`))
+	})
+	askOnce := func() error {
+		_, err := ask(context.Background(), "openai", completionRequest{Messages: userMessage("hi")}, continueOptions{})
+		return err
+	}
+
+	for i := 0; i < 3; i++ {
+		askOnce()
+	}
+	var unavailable *upstreamUnavailableError
+	if err := askOnce(); !errors.As(err, &unavailable) || calls != 3 {
+		t.Fatalf("Expected a fast upstream_unavailable error after 3 failures, got %v with %d calls", err, calls)
+	}
+	if state := providerBreakers.state("openai"); state.State != breakerOpen || state.RetryAt == nil {
+		t.Errorf("Expected an open breaker with a retry time, got %+v", state)
+	}
+	if providerAvailable("openai") {
+		t.Errorf("Expected an open breaker to make the provider unavailable")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	down = false
+	if err := askOnce(); err != nil {
+		t.Fatalf("Expected the half-open probe to go through, got %v", err)
+	}
+	if state := providerBreakers.state("openai"); state.State != breakerClosed || state.ConsecutiveFailures != 0 {
+		t.Errorf("Expected the breaker to close after a good probe, got %+v", state)
+	}
+}
+
+// Test a failed probe reopens the breaker and client errors never trip it
+func TestBreakerProbeAndClientErrors(t *testing.T) {
+	useBreakers(t)
+	t.Setenv("BREAKER_FAILURES", "2")
+	t.Setenv("BREAKER_COOLDOWN", "10ms")
+
+	serverErr := &apiError{Provider: "Mistral", StatusCode: 502, Message: "bad gateway"}
+	badRequest := &apiError{Provider: "Mistral", StatusCode: 400, Message: "bad request"}
+
+	for i := 0; i < 10; i++ {
+		providerBreakers.record("mistral", badRequest)
+	}
+	if state := providerBreakers.state("mistral"); state.State != breakerClosed || state.WindowCalls != 0 {
+		t.Errorf("Expected client errors to be ignored, got %+v", state)
+	}
+
+	providerBreakers.record("mistral", serverErr)
+	providerBreakers.record("mistral", serverErr)
+	time.Sleep(15 * time.Millisecond)
+
+	if err := providerBreakers.allow("mistral"); err != nil {
+		t.Fatalf("Expected one probe after the cooldown, got %v", err)
+	}
+	if err := providerBreakers.allow("mistral"); err == nil {
+		t.Errorf("Expected a second call to wait for the probe")
+	}
+	providerBreakers.record("mistral", serverErr)
+	if state := providerBreakers.state("mistral"); state.State != breakerOpen {
+		t.Errorf("Expected a failed probe to reopen the breaker, got %+v", state)
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxContinuations = 3
+	maxContinuationsLimit   = 10
+)
+
+// continuePrompt is sent after a truncated answer to providers that can't
+// continue a trailing assistant message on their own
+const continuePrompt = "Continue exactly where your previous answer stopped. Do not repeat anything you already wrote and do not add any preamble."
+
+// completionRequest is a provider-neutral chat request
+type completionRequest struct {
+	Model     string
+	Messages  []Message
+	MaxTokens int
+
+	// Gemini only
+	SafetySettings []GeminiSafetySetting
+
+	// Hugging Face only
+	Task         string
+	WaitForModel bool
+}
+
+// completion is a provider answer normalised across APIs
+type completion struct {
+	Provider     string
+	Model        string
+	Text         string
+	FinishReason string // the provider's own value, e.g. max_tokens, length, MAX_TOKENS
+	Truncated    bool   // the answer hit the output token limit
+	InputTokens  int
+	OutputTokens int
+
+	// Continuations is how many continuation turns were stitched onto the answer
+	Continuations int
+
+	// PIIRedacted is how many distinct values were masked in the prompt
+	PIIRedacted int
+
+	// SafetyRatings is only filled in by Gemini
+	SafetyRatings []GeminiSafetyRating
+}
+
+// String renders the answer followed by how it ended
+func (c *completion) String() string {
+	var b strings.Builder
+	b.WriteString(c.Text)
+	b.WriteString("\n\nfinish_reason: " + c.FinishReason)
+	fmt.Fprintf(&b, "\ntruncated: %t", c.Truncated)
+	if c.Continuations > 0 {
+		fmt.Fprintf(&b, "\ncontinuations: %d", c.Continuations)
+	}
+	if c.PIIRedacted > 0 {
+		fmt.Fprintf(&b, "\npii_redacted: %d", c.PIIRedacted)
+	}
+	if len(c.SafetyRatings) > 0 {
+		b.WriteString("\nsafety_ratings: " + formatSafetyRatings(c.SafetyRatings))
+	}
+	return b.String()
+}
+
+// provider describes one AI backend
+type provider struct {
+	Name     string // display name used in tool output
+	KeyEnv   string // environment variable holding the API key
+	Complete func(ctx context.Context, req completionRequest) (*completion, error)
+
+	// ListModels queries the provider's model-listing endpoint; nil when it has none
+	ListModels func() ([]ModelInfo, error)
+
+	// Ping is the cheap authenticated call provider_status uses to validate the key
+	Ping func(ctx context.Context) error
+
+	// Prefill reports whether the provider continues a trailing assistant
+	// message by itself, so a continuation needs no extra user turn
+	Prefill func(req completionRequest) bool
+
+	// Model returns the model a request will use once defaults are applied
+	Model func(req completionRequest) string
+
+	// DefaultMaxTokens is the answer budget when a request doesn't set one;
+	// 0 leaves it to the model
+	DefaultMaxTokens int
+
+	// CountTokens asks the provider to count a prompt; nil when it has no
+	// counting endpoint and the local estimate is used
+	CountTokens func(ctx context.Context, model string, messages []Message) (int, error)
+}
+
+// defaultModel returns a Model func that falls back to model
+func defaultModel(model string) func(req completionRequest) string {
+	return func(req completionRequest) string { return defaultString(req.Model, model) }
+}
+
+// providers is keyed by the short names used in tool and client arguments
+var providers = map[string]*provider{
+	"claude": {
+		Name:             "Claude",
+		KeyEnv:           "CLAUDE_API_KEY",
+		Complete:         askClaude,
+		ListModels:       listClaudeModels,
+		Ping:             pingClaude,
+		Prefill:          func(completionRequest) bool { return true },
+		Model:            defaultModel(defaultClaudeModel),
+		DefaultMaxTokens: defaultMaxTokens,
+		CountTokens:      countClaudeTokens,
+	},
+	"openai": {
+		Name:             "OpenAI",
+		KeyEnv:           "OPENAI_API_KEY",
+		Complete:         askOpenAI,
+		ListModels:       listOpenAIModels,
+		Ping:             pingOpenAI,
+		Model:            defaultModel(defaultOpenAIModel),
+		DefaultMaxTokens: defaultMaxTokens,
+	},
+	"gemini": {
+		Name:        "Gemini",
+		KeyEnv:      "GEMINI_API_KEY",
+		Complete:    askGemini,
+		ListModels:  listGeminiModels,
+		Ping:        pingGemini,
+		Model:       func(req completionRequest) string { return resolveGeminiModel(req.Model) },
+		CountTokens: countGeminiTokens,
+	},
+	"mistral": {
+		Name:             "Mistral",
+		KeyEnv:           "MISTRAL_API_KEY",
+		Complete:         askMistral,
+		ListModels:       listMistralModels,
+		Ping:             pingMistral,
+		Model:            defaultModel(defaultMistralModel),
+		DefaultMaxTokens: defaultMaxTokens,
+	},
+	"huggingface": {
+		Name:     "Hugging Face",
+		KeyEnv:   "HUGGINGFACEHUB_API_TOKEN",
+		Complete: askHuggingFace,
+		Ping:     pingHuggingFace,
+		Prefill:  func(req completionRequest) bool { return huggingFaceTask(req.Task) == hfTaskTextGeneration },
+		Model:    huggingFaceModel,
+	},
+}
+
+// continueOptions controls automatic continuation of truncated answers
+type continueOptions struct {
+	Auto             bool
+	MaxContinuations int
+}
+
+// userMessage is the single-turn conversation the ask_* tools send
+func userMessage(question string) []Message {
+	return []Message{{Role: "user", Content: question}}
+}
+
+// ask sends req to the named provider. With PII_REDACTION on, personal data in
+// the messages is replaced with placeholders before anything leaves the server
+// and restored in the answer.
+func ask(ctx context.Context, name string, req completionRequest, opts continueOptions) (*completion, error) {
+	if !piiRedactionEnabled() {
+		return askProvider(ctx, name, req, opts)
+	}
+
+	vault, err := newPIIVault()
+	if err != nil {
+		return nil, err
+	}
+	result, err := askProvider(ctx, name, vault.maskRequest(req), opts)
+	if err != nil {
+		return nil, err
+	}
+	result.Text = vault.restore(result.Text)
+	result.PIIRedacted = len(vault.originals)
+	return result, nil
+}
+
+// askProvider sends req to the named provider. With opts.Auto set, truncated
+// answers are continued up to opts.MaxContinuations times and stitched together;
+// the result reports whether the final text is still truncated. Every call is
+// counted in the usage totals.
+func askProvider(ctx context.Context, name string, req completionRequest, opts continueOptions) (*completion, error) {
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q", name)
+	}
+
+	result, err := complete(ctx, name, p, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if !opts.Auto {
+		return result, nil
+	}
+
+	limit := opts.MaxContinuations
+	if limit <= 0 {
+		limit = defaultMaxContinuations
+	}
+	if limit > maxContinuationsLimit {
+		limit = maxContinuationsLimit
+	}
+
+	for result.Truncated && result.Continuations < limit {
+		next := req
+		next.Messages = continuationMessages(req.Messages, result.Text, p.Prefill != nil && p.Prefill(req))
+
+		more, err := complete(ctx, name, p, next)
+		if err != nil {
+			return nil, fmt.Errorf("continuation %d of %s answer failed: %w", result.Continuations+1, p.Name, err)
+		}
+
+		result.Text = stitchContinuation(result.Text, more.Text)
+		result.FinishReason = more.FinishReason
+		result.Truncated = more.Truncated
+		result.InputTokens += more.InputTokens
+		result.OutputTokens += more.OutputTokens
+		result.SafetyRatings = more.SafetyRatings
+		result.Continuations++
+
+		if more.Text == "" {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// complete makes a provider call and records its usage and latency. Requests
+// that can't fit in the model's context window fail before anything is sent, and
+// so do calls to a provider whose circuit breaker is open.
+func complete(ctx context.Context, name string, p *provider, req completionRequest) (*completion, error) {
+	if err := preflight(name, p, req); err != nil {
+		return nil, err
+	}
+	if !hasAPIKey(p.KeyEnv) {
+		// Let the provider report the missing key; it says nothing about its health
+		return completeWithKeys(ctx, name, p, req)
+	}
+
+	if err := providerBreakers.allow(name); err != nil {
+		return nil, err
+	}
+	result, err := completeWithKeys(ctx, name, p, req)
+	providerBreakers.record(name, err)
+	return result, err
+}
+
+// completeWithKeys calls the provider with a key from its pool, retrying with
+// the next key when the provider rejects or rate limits the first. Each key is
+// tried at most once per call, so a short KEY_BENCH_DURATION can't loop forever.
+func completeWithKeys(ctx context.Context, name string, p *provider, req completionRequest) (*completion, error) {
+	attempts := len(configuredKeys(p.KeyEnv))
+	for attempt := 1; ; attempt++ {
+		key := keyPools.acquire(p.KeyEnv)
+		callCtx := ctx
+		if key != nil {
+			callCtx = withAPIKey(ctx, p.KeyEnv, key.value)
+		}
+
+		start := time.Now()
+		result, err := p.Complete(callCtx, req)
+		usageStats.record(name, result, err)
+		if err == nil {
+			if key != nil {
+				keyPools.release(p.KeyEnv, key, result, nil)
+			}
+			providerLatency.observe(name, time.Since(start))
+			result.Provider = name
+			return result, nil
+		}
+
+		// A rejected or rate-limited key is benched; fail over to the next one
+		if key == nil || !keyPools.release(p.KeyEnv, key, nil, err) || !keyPools.spare(p.KeyEnv) || attempt >= attempts || ctx.Err() != nil {
+			return nil, err
+		}
+	}
+}
+
+// continuationMessages extends the conversation with the partial answer. Providers
+// that prefill pick up from the trailing assistant message directly; the others are
+// asked to continue in a new user turn.
+func continuationMessages(messages []Message, soFar string, prefill bool) []Message {
+	out := append([]Message(nil), messages...)
+	if prefill {
+		// Anthropic rejects a final assistant turn that ends in whitespace
+		return append(out, Message{Role: "assistant", Content: strings.TrimRight(soFar, " \t\r\n")})
+	}
+	return append(out,
+		Message{Role: "assistant", Content: soFar},
+		Message{Role: "user", Content: continuePrompt},
+	)
+}
+
+// stitchContinuation appends next to soFar, dropping any text the model repeated
+// from the end of the previous part
+func stitchContinuation(soFar, next string) string {
+	if next == "" {
+		return soFar
+	}
+
+	const maxOverlap, minOverlap = 200, 12
+	trimmed := strings.TrimRight(soFar, " \t\r\n")
+	candidate := strings.TrimLeft(next, " \t\r\n")
+	for n := min(maxOverlap, len(trimmed), len(candidate)); n >= minOverlap; n-- {
+		if strings.HasSuffix(trimmed, candidate[:n]) {
+			return trimmed + candidate[n:]
+		}
+	}
+
+	// A prefilled answer had its trailing whitespace trimmed; the continuation
+	// usually starts with it, so only keep one side
+	if strings.TrimRight(soFar, " \t\r\n") != soFar && strings.TrimLeft(next, " \t\r\n") != next {
+		return trimmed + next
+	}
+	return soFar + next
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useProviderURL points one provider endpoint variable at a mock server for one test
+func useProviderURL(t *testing.T, url *string, handler http.HandlerFunc) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	original := *url
+	*url = server.URL
+	t.Cleanup(func() { *url = original })
+}
+
+// Test truncated Claude answers are continued by prefilling the partial answer
+func TestAskAutoContinueClaude(t *testing.T) {
+	t.Setenv("CLAUDE_API_KEY", "test-claude-key")
+
+	var requests []ClaudeRequest
+	useProviderURL(t, &claudeMessagesURL, func(w http.ResponseWriter, r *http.Request) {
+		var req ClaudeRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+
+		text, stop := "The quick brown fox ", "max_tokens"
+		if len(requests) == 2 {
+			text, stop = " jumps over the lazy dog.", "end_turn"
+		}
+		w.Write([]byte(`{"content":[{"type":"text","text":"` + text + `"}],"stop_reason":"` + stop + `","usage":{"input_tokens":5,"output_tokens":4}}`))
+	})
+
+	result, err := ask(context.Background(), "claude", completionRequest{Messages: userMessage("fox?")}, continueOptions{Auto: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if result.Text != "The quick brown fox jumps over the lazy dog." {
+		t.Errorf("Unexpected stitched text '%s'", result.Text)
+	}
+	if result.Truncated || result.Continuations != 1 || result.OutputTokens != 8 {
+		t.Errorf("Expected one continuation and a complete answer, got %+v", result)
+	}
+
+	last := requests[1].Messages[len(requests[1].Messages)-1]
+	if last.Role != "assistant" || last.Content != "The quick brown fox" {
+		t.Errorf("Expected a trimmed assistant prefill, got %+v", last)
+	}
+}
+
+// Test OpenAI-style providers get a continuation turn and the limit is respected
+func TestAskAutoContinueOpenAILimit(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "test-openai-key")
+
+	calls := 0
+	useProviderURL(t, &openAIChatURL, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		var req OpenAIRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if calls > 1 && req.Messages[len(req.Messages)-1].Content != continuePrompt {
+			t.Errorf("Expected a continuation prompt, got %+v", req.Messages)
+		}
+		w.Write([]byte(`{"choices":[{"message":{"content":"part "},"finish_reason":"length"}]}`))
+	})
+
+	result, err := ask(context.Background(), "openai", completionRequest{Messages: userMessage("go on")}, continueOptions{Auto: true, MaxContinuations: 2})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if calls != 3 || result.Continuations != 2 {
+		t.Errorf("Expected 3 calls and 2 continuations, got %d and %d", calls, result.Continuations)
+	}
+	if !result.Truncated || !strings.Contains(result.String(), "truncated: true") {
+		t.Errorf("Expected the answer to still be reported as truncated, got %+v", result)
+	}
+}
+
+// Test truncation is reported without auto_continue
+func TestAskReportsTruncation(t *testing.T) {
+	t.Setenv("MISTRAL_API_KEY", "test-mistral-key")
+
+	useProviderURL(t, &mistralChatURL, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[{"message":{"content":"cut"},"finish_reason":"length"}]}`))
+	})
+
+	result, err := ask(context.Background(), "mistral", completionRequest{Messages: userMessage("q")}, continueOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.Truncated || result.Continuations != 0 {
+		t.Errorf("Expected a truncated answer without continuations, got %+v", result)
+	}
+}
+
+// Test repeated text at the seam is dropped
+func TestStitchContinuation(t *testing.T) {
+	soFar := "Go is a statically typed, compiled language designed at Google"
+	next := "designed at Google by Robert Griesemer."
+
+	got := stitchContinuation(soFar, next)
+	want := "Go is a statically typed, compiled language designed at Google by Robert Griesemer."
+	if got != want {
+		t.Errorf("Expected '%s', got '%s'", want, got)
+	}
+
+	if got := stitchContinuation("Hello ", "world"); got != "Hello world" {
+		t.Errorf("Expected plain concatenation, got '%s'", got)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Dataset formats
+const (
+	datasetOpenAI    = "openai"
+	datasetAnthropic = "anthropic"
+	datasetGemini    = "gemini"
+	datasetGeneric   = "generic"
+)
+
+// ExportDatasetArguments are the export_dataset tool arguments
+type ExportDatasetArguments struct {
+	Format     string `json:"format" jsonschema:"required,description=openai (chat fine-tuning)\\, anthropic (messages)\\, gemini (contents) or generic (prompt/completion)"`
+	OutputPath string `json:"output_path" jsonschema:"description=File to write the JSONL to; when empty the JSONL is returned"`
+	Provider   string `json:"provider" jsonschema:"description=Only exchanges with this provider"`
+	SessionID  string `json:"session_id" jsonschema:"description=Only exchanges recorded under this session"`
+	Since      string `json:"since" jsonschema:"description=Only exchanges on or after this date (YYYY-MM-DD or RFC 3339)"`
+	Until      string `json:"until" jsonschema:"description=Only exchanges on or before this date (YYYY-MM-DD or RFC 3339)"`
+	MinRating  int    `json:"min_rating" jsonschema:"description=Only exchanges rated at least this (1-5) with rate_answer"`
+	ScrubPII   bool   `json:"scrub_pii" jsonschema:"description=Mask emails\\, phone numbers\\, CPF\\, CNPJ\\, CEP and card numbers in prompts and answers"`
+}
+
+// datasetExport is the result of an export
+type datasetExport struct {
+	Format   string `json:"format"`
+	Examples int    `json:"examples"`
+	Skipped  int    `json:"skipped"` // failed or empty exchanges
+	Path     string `json:"path,omitempty"`
+	data     string
+}
+
+func (e *datasetExport) String() string {
+	if e.Path == "" && e.Examples == 0 {
+		return fmt.Sprintf("no exchanges to export (%d failed or empty exchanges skipped)", e.Skipped)
+	}
+	if e.Path == "" {
+		return e.data
+	}
+	return fmt.Sprintf("exported %d %s examples to %s (%d failed or empty exchanges skipped)", e.Examples, e.Format, e.Path, e.Skipped)
+}
+
+type chatTurn struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type geminiTurn struct {
+	Role  string       `json:"role"`
+	Parts []GeminiPart `json:"parts"`
+}
+
+// datasetLine renders one exchange in the given format
+func datasetLine(format string, entry HistoryEntry) (interface{}, error) {
+	switch format {
+	case datasetOpenAI, datasetAnthropic:
+		return map[string]interface{}{"messages": []chatTurn{
+			{Role: "user", Content: entry.Question},
+			{Role: "assistant", Content: entry.Answer},
+		}}, nil
+	case datasetGemini:
+		return map[string]interface{}{"contents": []geminiTurn{
+			{Role: "user", Parts: []GeminiPart{{Text: entry.Question}}},
+			{Role: "model", Parts: []GeminiPart{{Text: entry.Answer}}},
+		}}, nil
+	case datasetGeneric:
+		line := map[string]interface{}{
+			"prompt":     entry.Question,
+			"completion": entry.Answer,
+			"provider":   entry.Provider,
+			"model":      entry.Model,
+			"timestamp":  entry.Timestamp,
+		}
+		if entry.Rating > 0 {
+			line["rating"] = entry.Rating
+		}
+		return line, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q: use openai, anthropic, gemini or generic", format)
+	}
+}
+
+// exportDataset writes the selected exchanges of a history store as JSONL,
+// oldest first. Failed and empty exchanges are left out.
+func exportDataset(history *historyStore, args ExportDatasetArguments) (*datasetExport, error) {
+	format := strings.ToLower(strings.TrimSpace(args.Format))
+	if _, err := datasetLine(format, HistoryEntry{}); err != nil {
+		return nil, err
+	}
+	if args.MinRating < 0 || args.MinRating > 5 {
+		return nil, fmt.Errorf("min_rating must be between 1 and 5, got %d", args.MinRating)
+	}
+	filter, err := newHistoryFilter(HistorySearchArguments{Provider: args.Provider, SessionID: args.SessionID, Since: args.Since, Until: args.Until})
+	if err != nil {
+		return nil, err
+	}
+	filter.MinRating = args.MinRating
+
+	found := history.search(filter)
+	result := &datasetExport{Format: format, Path: args.OutputPath}
+	var b strings.Builder
+	for i := len(found) - 1; i >= 0; i-- {
+		entry := found[i]
+		if entry.Error != "" || strings.TrimSpace(entry.Question) == "" || strings.TrimSpace(entry.Answer) == "" {
+			result.Skipped++
+			continue
+		}
+		if args.ScrubPII {
+			entry.Question, entry.Answer = scrubPII(entry.Question), scrubPII(entry.Answer)
+		}
+		line, err := datasetLine(format, entry)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(line)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(data)
+		b.WriteByte('\n')
+		result.Examples++
+	}
+	result.data = b.String()
+
+	if args.OutputPath != "" {
+		if dir := filepath.Dir(args.OutputPath); dir != "." {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return nil, err
+			}
+		}
+		if err := os.WriteFile(args.OutputPath, []byte(result.data), 0600); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// runExport is the export command: it reads the history file and writes a
+// dataset to -o or stdout, e.g.
+//
+//	mcp-server-test export -format openai -min-rating 4 -scrub-pii -o evals.jsonl
+//
+// A running server keeps the history database locked, so the command only works
+// with the server stopped; while it runs, use the export_dataset tool instead.
+func runExport(argv []string, stdout, stderr io.Writer) int {
+	var args ExportDatasetArguments
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: mcp-server-test export [flags]")
+		fmt.Fprintln(stderr, "Writes the recorded history as a fine-tuning or eval dataset. Stop the server")
+		fmt.Fprintln(stderr, "first, since it keeps the history locked; while it runs, use the export_dataset tool.")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&args.Format, "format", datasetOpenAI, "openai, anthropic, gemini or generic")
+	flags.StringVar(&args.OutputPath, "o", "", "output file (default: stdout)")
+	flags.StringVar(&args.Provider, "provider", "", "only exchanges with this provider")
+	flags.StringVar(&args.SessionID, "session", "", "only exchanges recorded under this session")
+	flags.StringVar(&args.Since, "since", "", "only exchanges on or after this date (YYYY-MM-DD or RFC 3339)")
+	flags.StringVar(&args.Until, "until", "", "only exchanges on or before this date (YYYY-MM-DD or RFC 3339)")
+	flags.IntVar(&args.MinRating, "min-rating", 0, "only exchanges rated at least this (1-5)")
+	flags.BoolVar(&args.ScrubPII, "scrub-pii", false, "mask emails, phone numbers, CPF, CNPJ, CEP and card numbers")
+	if err := flags.Parse(argv); err != nil {
+		return exitCommandFailure
+	}
+
+	path := historyPath()
+	if path == "" {
+		fmt.Fprintln(stderr, "export: HISTORY_PATH is off, there is no history to export")
+		return exitCommandFailure
+	}
+	history := newHistoryStore("")
+	if err := history.openReadOnly(path); err != nil {
+		fmt.Fprintf(stderr, "export: reading %s: %v\n", path, err)
+		return exitCommandFailure
+	}
+	defer history.close()
+
+	result, err := exportDataset(history, args)
+	if err != nil {
+		fmt.Fprintf(stderr, "export: %v\n", err)
+		return exitCommandFailure
+	}
+	if args.OutputPath == "" {
+		io.WriteString(stdout, result.data)
+	}
+	fmt.Fprintf(stderr, "exported %d %s examples (%d failed or empty exchanges skipped)\n", result.Examples, result.Format, result.Skipped)
+	return exitOK
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useDatasetHistory records a few rated, unrated and failed exchanges
+func useDatasetHistory(t *testing.T) *historyStore {
+	history := useHistory(t)
+	day := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+	history.record(HistoryEntry{ID: "a", Provider: "claude", Question: "Email ana@example.com about 2+2", Answer: "4", Timestamp: day})
+	history.record(HistoryEntry{ID: "b", Provider: "openai", Question: "Capital of Peru?", Answer: "Lima", Timestamp: day.Add(time.Hour)})
+	history.record(HistoryEntry{ID: "c", Provider: "claude", Question: "hi", Error: "claude: 500", Timestamp: day.Add(2 * time.Hour)})
+	if _, err := history.rate("a", 5); err != nil {
+		t.Fatalf("Unexpected error rating: %v", err)
+	}
+	return history
+}
+
+// Test each format renders one line per good exchange, oldest first
+func TestExportDatasetFormats(t *testing.T) {
+	history := useDatasetHistory(t)
+
+	want := map[string]string{
+		"openai":    `{"messages":[{"role":"user","content":"Capital of Peru?"},{"role":"assistant","content":"Lima"}]}`,
+		"anthropic": `{"messages":[{"role":"user","content":"Capital of Peru?"},{"role":"assistant","content":"Lima"}]}`,
+		"gemini":    `{"contents":[{"role":"user","parts":[{"text":"Capital of Peru?"}]},{"role":"model","parts":[{"text":"Lima"}]}]}`,
+		"generic":   `{"completion":"Lima","model":"","prompt":"Capital of Peru?","provider":"openai","timestamp":"2026-05-01T10:00:00Z"}`,
+	}
+	for format, line := range want {
+		result, err := exportDataset(history, ExportDatasetArguments{Format: format})
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", format, err)
+		}
+		lines := strings.Split(strings.TrimSpace(result.String()), "\n")
+		if result.Examples != 2 || result.Skipped != 1 || len(lines) != 2 {
+			t.Fatalf("Expected 2 examples and 1 skipped for %s, got %+v", format, result)
+		}
+		if lines[1] != line {
+			t.Errorf("Expected %s line %s, got %s", format, line, lines[1])
+		}
+	}
+
+	if _, err := exportDataset(history, ExportDatasetArguments{Format: "csv"}); err == nil {
+		t.Errorf("Expected an unknown format to be rejected")
+	}
+}
+
+// Test filters, PII scrubbing and writing to a file
+func TestExportDatasetFilters(t *testing.T) {
+	history := useDatasetHistory(t)
+	path := filepath.Join(t.TempDir(), "out", "evals.jsonl")
+
+	result, err := exportDataset(history, ExportDatasetArguments{Format: "generic", MinRating: 4, ScrubPII: true, OutputPath: path})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if result.Examples != 1 || !strings.Contains(string(data), `"prompt":"Email [EMAIL] about 2+2"`) || !strings.Contains(string(data), `"rating":5`) {
+		t.Errorf("Expected only the rated exchange, scrubbed, got %s", data)
+	}
+	if !strings.Contains(result.String(), "exported 1 generic examples to "+path) {
+		t.Errorf("Expected a summary of the export, got '%s'", result)
+	}
+
+	result, _ = exportDataset(history, ExportDatasetArguments{Format: "openai", Provider: "openai", Since: "2026-05-02"})
+	if result.Examples != 0 || !strings.HasPrefix(result.String(), "no exchanges to export") {
+		t.Errorf("Expected nothing after the date filter, got '%s'", result)
+	}
+}
+
+// Test the export command reads the history database and writes JSONL to stdout
+func TestRunExport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	t.Setenv("HISTORY_PATH", path)
+	history := newHistoryStore("run")
+	if err := history.open(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	history.record(HistoryEntry{Provider: "mistral", Question: "Olá, meu CPF é 529.982.247-25", Answer: "Olá!", Timestamp: time.Now().UTC()})
+
+	var stdout, stderr bytes.Buffer
+	if code := runExport(nil, &stdout, &stderr); code != exitCommandFailure || !strings.Contains(stderr.String(), "in use") {
+		t.Errorf("Expected the export to refuse a database a server holds, got %d: %s", code, stderr.String())
+	}
+	history.close()
+	stdout.Reset()
+	stderr.Reset()
+
+	if code := runExport([]string{"-h"}, &stdout, &stderr); code != exitCommandFailure || !strings.Contains(stderr.String(), "export_dataset") {
+		t.Errorf("Expected the usage to point at export_dataset while the server runs, got %d: %s", code, stderr.String())
+	}
+	stderr.Reset()
+	stderr.Reset()
+	if code := runExport([]string{"-format", "openai", "-scrub-pii"}, &stdout, &stderr); code != exitOK {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "meu CPF é [CPF]") || !strings.Contains(stderr.String(), "exported 1 openai examples") {
+		t.Errorf("Unexpected output %s / %s", stdout.String(), stderr.String())
+	}
+
+	if code := runExport([]string{"-min-rating", "9"}, &stdout, &stderr); code != exitCommandFailure {
+		t.Errorf("Expected a bad rating to fail, got exit code %d", code)
+	}
+}
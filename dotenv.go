@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// dotenvEntry is one KEY=value assignment parsed from a .env file
+type dotenvEntry struct {
+	Key   string
+	Value string
+	Line  int
+}
+
+// loadEnv loads .env from the working directory. A missing file is normal (in
+// Docker the variables come from docker-compose), so it is silently ignored.
+func loadEnv() {
+	if err := loadEnvFile(".env"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("could not load .env: %v", err)
+	}
+	for _, warning := range loadSecretFiles() {
+		log.Print(warning)
+	}
+}
+
+// loadEnvFile parses a dotenv file and sets every variable that isn't already
+// in the process environment: a real environment variable always beats the file.
+// Malformed lines are skipped with a line-numbered warning; values are never
+// logged.
+func loadEnvFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	entries, warnings := parseDotenv(string(data), os.LookupEnv)
+	for _, warning := range warnings {
+		log.Printf("%s:%s", path, warning)
+	}
+
+	for _, entry := range entries {
+		if _, set := os.LookupEnv(entry.Key); set {
+			continue
+		}
+		os.Setenv(entry.Key, entry.Value)
+	}
+	return nil
+}
+
+// parseDotenv parses dotenv syntax:
+//
+//	KEY=value            # unquoted, trailing comments and ';' dropped
+//	export KEY=value     # shell-style export prefix
+//	KEY='literal $value' # single quotes: no escapes, no expansion
+//	KEY="line\nbreak"    # double quotes: \n \r \t \" \\ \$ escapes and expansion
+//	KEY="spans
+//	several lines"
+//
+// ${VAR}, ${VAR:-default} and $VAR are expanded in unquoted and double-quoted
+// values, looking first at lookup (the process environment, which wins) and then
+// at keys defined earlier in the file. Warnings are returned as "line: message".
+func parseDotenv(data string, lookup func(string) (string, bool)) ([]dotenvEntry, []string) {
+	var entries []dotenvEntry
+	var warnings []string
+	defined := map[string]string{}
+
+	resolve := func(name string) (string, bool) {
+		if value, ok := lookup(name); ok {
+			return value, true
+		}
+		value, ok := defined[name]
+		return value, ok
+	}
+
+	lines := strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			warnings = append(warnings, fmt.Sprintf("%d: expected KEY=value, skipping line", lineNo))
+			continue
+		}
+		key := strings.TrimSpace(line[:eq])
+		if !validEnvKey(key) {
+			warnings = append(warnings, fmt.Sprintf("%d: invalid variable name %q, skipping line", lineNo, key))
+			continue
+		}
+		raw := strings.TrimLeft(line[eq+1:], " \t")
+
+		var value string
+		switch {
+		case strings.HasPrefix(raw, "'") || strings.HasPrefix(raw, `"`):
+			quote := raw[0]
+			body, rest, consumed, ok := readQuoted(raw[1:], quote, lines[i+1:])
+			if !ok {
+				warnings = append(warnings, fmt.Sprintf("%d: unterminated %c quote for %s, skipping", lineNo, quote, key))
+				i += consumed
+				continue
+			}
+			i += consumed
+			if rest = strings.TrimSpace(rest); rest != "" && rest != ";" && !strings.HasPrefix(rest, "#") {
+				warnings = append(warnings, fmt.Sprintf("%d: unexpected text after closing quote for %s", lineNo, key))
+			}
+			if quote == '"' {
+				value = expandEnv(body, resolve, true)
+			} else {
+				value = body
+			}
+		default:
+			value = raw
+			if idx := strings.Index(value, " #"); idx >= 0 {
+				value = value[:idx]
+			}
+			value = strings.TrimSpace(value)
+			// Older .env files here ended lines with ';'
+			value = strings.TrimSuffix(value, ";")
+			value = expandEnv(value, resolve, false)
+		}
+
+		defined[key] = value
+		entries = append(entries, dotenvEntry{Key: key, Value: value, Line: lineNo})
+	}
+
+	return entries, warnings
+}
+
+// readQuoted reads up to the closing quote, continuing onto the following lines
+// for multi-line values. It returns the quoted body, the text after the closing
+// quote and how many extra lines were consumed.
+func readQuoted(first string, quote byte, following []string) (body, rest string, consumed int, ok bool) {
+	text := first
+	for {
+		if end := closingQuote(text, quote); end >= 0 {
+			return text[:end], text[end+1:], consumed, true
+		}
+		if consumed == len(following) {
+			return "", "", consumed, false
+		}
+		text += "\n" + following[consumed]
+		consumed++
+	}
+}
+
+// closingQuote finds the closing quote, skipping backslash escapes inside double quotes
+func closingQuote(text string, quote byte) int {
+	for i := 0; i < len(text); i++ {
+		if quote == '"' && text[i] == '\\' {
+			i++
+			continue
+		}
+		if text[i] == quote {
+			return i
+		}
+	}
+	return -1
+}
+
+// expandEnv replaces ${VAR}, ${VAR:-default} and $VAR; unknown variables expand
+// to the empty string. With escapes (double-quoted values) it also decodes \n,
+// \r, \t and backslash-escaped characters such as \" and \$ in the same pass.
+func expandEnv(s string, resolve func(string) (string, bool), escapes bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escapes && s[i] == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(s[i])
+			}
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				b.WriteString(s[i:])
+				return b.String()
+			}
+			name, fallback, hasFallback := strings.Cut(s[i+2:i+end], ":-")
+			if value, ok := resolve(name); ok && value != "" {
+				b.WriteString(value)
+			} else if hasFallback {
+				b.WriteString(fallback)
+			}
+			i += end
+		case s[i] == '$' && i+1 < len(s) && isEnvKeyStart(s[i+1]):
+			j := i + 1
+			for j < len(s) && isEnvKeyChar(s[j]) {
+				j++
+			}
+			value, _ := resolve(s[i+1 : j])
+			b.WriteString(value)
+			i = j - 1
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func validEnvKey(key string) bool {
+	if key == "" || !isEnvKeyStart(key[0]) {
+		return false
+	}
+	for i := 1; i < len(key); i++ {
+		if !isEnvKeyChar(key[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isEnvKeyStart(c byte) bool {
+	return c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+}
+
+func isEnvKeyChar(c byte) bool {
+	return isEnvKeyStart(c) || (c >= '0' && c <= '9')
+}
+
+// loadSecretFiles resolves KEY_FILE variables, the Docker/Kubernetes secrets
+// convention: when KEY is not set, it is read from the file KEY_FILE points at,
+// minus the trailing newline. An explicitly set KEY wins over KEY_FILE. Only
+// secretFileVars are resolved, so unrelated variables such as SSL_CERT_FILE are
+// never replaced by the contents of the file they name.
+func loadSecretFiles() []string {
+	var warnings []string
+	for _, key := range secretFileVars() {
+		name := key + "_FILE"
+		path := os.Getenv(name)
+		if path == "" {
+			continue
+		}
+		if value, set := os.LookupEnv(key); set && value != "" {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: could not read secret file: %v", name, err))
+			continue
+		}
+		os.Setenv(key, strings.TrimRight(string(data), "\r\n"))
+	}
+	return warnings
+}
+
+// secretFileVars lists the variables that may be read from a KEY_FILE: the
+// provider keys, their pools, and the credentials named by OPENAPI_SERVICES
+func secretFileVars() []string {
+	var names []string
+	for _, name := range secretEnvVars {
+		names = append(names, name, name+"S")
+	}
+	if path := os.Getenv("OPENAPI_SERVICES"); path != "" {
+		// a broken services file is reported when the tools are registered
+		if config, err := loadOpenAPIServices(path); err == nil {
+			for _, service := range config.Services {
+				auth := service.Auth
+				for _, name := range []string{auth.TokenEnv, auth.UsernameEnv, auth.PasswordEnv, auth.ValueEnv} {
+					if name != "" {
+						names = append(names, name)
+					}
+				}
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test quoting, export lines, escapes, comments and multi-line values
+func TestParseDotenv(t *testing.T) {
+	data := `# comment
+export PLAIN=value # trailing comment
+LEGACY=old-style;
+SINGLE='literal ${PLAIN} \n'
+DOUBLE="tab\there \"quoted\" \$5"
+MULTI="first
+second"
+EMPTY=
+`
+	entries, warnings := parseDotenv(data, func(string) (string, bool) { return "", false })
+	if len(warnings) != 0 {
+		t.Errorf("Expected no warnings, got %v", warnings)
+	}
+
+	want := map[string]string{
+		"PLAIN":  "value",
+		"LEGACY": "old-style",
+		"SINGLE": `literal ${PLAIN} \n`,
+		"DOUBLE": "tab\there \"quoted\" $5",
+		"MULTI":  "first\nsecond",
+		"EMPTY":  "",
+	}
+	got := map[string]string{}
+	for _, entry := range entries {
+		got[entry.Key] = entry.Value
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("Expected %s=%q, got %q", key, value, got[key])
+		}
+	}
+}
+
+// Test ${VAR} expansion prefers the process environment over earlier file values
+func TestParseDotenvExpansion(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		if name == "HOST" {
+			return "from-env", true
+		}
+		return "", false
+	}
+	data := `HOST=from-file
+PORT=8080
+URL=http://${HOST}:$PORT/${MISSING:-api}
+`
+	entries, _ := parseDotenv(data, lookup)
+
+	url := entries[len(entries)-1]
+	if url.Value != "http://from-env:8080/api" {
+		t.Errorf("Expected expanded URL, got %q", url.Value)
+	}
+}
+
+// Test malformed lines produce line-numbered warnings and are skipped
+func TestParseDotenvWarnings(t *testing.T) {
+	data := "GOOD=1\nnot a variable\n1BAD=2\nOPEN=\"never closed\n"
+	entries, warnings := parseDotenv(data, func(string) (string, bool) { return "", false })
+
+	if len(entries) != 1 || entries[0].Key != "GOOD" {
+		t.Errorf("Expected only GOOD to be parsed, got %+v", entries)
+	}
+	if len(warnings) != 3 {
+		t.Fatalf("Expected 3 warnings, got %v", warnings)
+	}
+	for i, prefix := range []string{"2:", "3:", "4:"} {
+		if !strings.HasPrefix(warnings[i], prefix) {
+			t.Errorf("Expected warning %d to start with %q, got %q", i, prefix, warnings[i])
+		}
+	}
+}
+
+// Test the process environment wins over the file and KEY_FILE secrets are read
+func TestLoadEnvFilePrecedenceAndSecrets(t *testing.T) {
+	dir := t.TempDir()
+	envPath := filepath.Join(dir, ".env")
+	secretPath := filepath.Join(dir, "secret")
+	os.WriteFile(envPath, []byte("DOTENV_TEST_SET=file\nDOTENV_TEST_NEW=file\nMISTRAL_API_KEY_FILE="+secretPath+"\n"), 0644)
+	os.WriteFile(secretPath, []byte("s3cret\n"), 0600)
+
+	t.Setenv("DOTENV_TEST_SET", "process")
+	t.Setenv("DOTENV_TEST_NEW", "")
+	os.Unsetenv("DOTENV_TEST_NEW")
+	t.Setenv("MISTRAL_API_KEY_FILE", "")
+	os.Unsetenv("MISTRAL_API_KEY_FILE")
+	t.Setenv("MISTRAL_API_KEY", "")
+
+	if err := loadEnvFile(envPath); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	loadSecretFiles()
+
+	if got := os.Getenv("DOTENV_TEST_SET"); got != "process" {
+		t.Errorf("Expected the process environment to win, got %q", got)
+	}
+	if got := os.Getenv("DOTENV_TEST_NEW"); got != "file" {
+		t.Errorf("Expected the file value to be set, got %q", got)
+	}
+	if got := os.Getenv("MISTRAL_API_KEY"); got != "s3cret" {
+		t.Errorf("Expected the secret to be read from its file, got %q", got)
+	}
+}
+
+// Test only known secret variables are read from KEY_FILE
+func TestLoadSecretFilesOnlyKnownSecrets(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "ca.pem")
+	tokenPath := filepath.Join(dir, "token")
+	servicesPath := filepath.Join(dir, "services.yaml")
+	os.WriteFile(certPath, []byte("-----BEGIN CERTIFICATE-----\n"), 0644)
+	os.WriteFile(tokenPath, []byte("pet-token\n"), 0600)
+	os.WriteFile(servicesPath, []byte("services:\n  - name: pets\n    spec: pets.yaml\n    auth: {type: bearer, token_env: DOTENV_TEST_PET_TOKEN}\n"), 0644)
+
+	t.Setenv("SSL_CERT_FILE", certPath)
+	t.Setenv("SSL_CERT", "")
+	os.Unsetenv("SSL_CERT")
+	t.Setenv("OPENAPI_SERVICES", servicesPath)
+	t.Setenv("DOTENV_TEST_PET_TOKEN_FILE", tokenPath)
+	t.Setenv("DOTENV_TEST_PET_TOKEN", "")
+
+	if warnings := loadSecretFiles(); len(warnings) != 0 {
+		t.Errorf("Expected no warnings, got %v", warnings)
+	}
+
+	if value, set := os.LookupEnv("SSL_CERT"); set {
+		t.Errorf("Expected SSL_CERT_FILE to be left alone, got SSL_CERT=%q", value)
+	}
+	if got := os.Getenv("SSL_CERT_FILE"); got != certPath {
+		t.Errorf("Expected SSL_CERT_FILE to keep its path, got %q", got)
+	}
+	if got := os.Getenv("DOTENV_TEST_PET_TOKEN"); got != "pet-token" {
+		t.Errorf("Expected the OpenAPI token to be read from its file, got %q", got)
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// geminiBaseURL is a variable so tests can point it at a mock server
+var geminiBaseURL = "https://generativelanguage.googleapis.com/v1beta/models/"
+
+// geminiModelPattern is the shape of a Gemini model id such as gemini-2.5-flash
+var geminiModelPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+const defaultGeminiModel = "gemini-2.5-flash"
+
+// geminiModelAliases maps the short names accepted by ask_gemini to model ids
+var geminiModelAliases = map[string]string{
+	"pro":        "gemini-2.5-pro",
+	"flash":      "gemini-2.5-flash",
+	"flash-lite": "gemini-2.5-flash-lite",
+}
+
+// geminiHarmCategories are the categories a safety threshold applies to
+var geminiHarmCategories = []string{
+	"HARM_CATEGORY_HARASSMENT",
+	"HARM_CATEGORY_HATE_SPEECH",
+	"HARM_CATEGORY_SEXUALLY_EXPLICIT",
+	"HARM_CATEGORY_DANGEROUS_CONTENT",
+}
+
+// geminiThresholds are the accepted values for a safety setting
+var geminiThresholds = map[string]bool{
+	"BLOCK_NONE":             true,
+	"BLOCK_ONLY_HIGH":        true,
+	"BLOCK_MEDIUM_AND_ABOVE": true,
+	"BLOCK_LOW_AND_ABOVE":    true,
+	"OFF":                    true,
+}
+
+type GeminiArguments struct {
+	Question         string  `json:"question" jsonschema:"required,description=The question to ask Google Gemini"`
+	Model            string  `json:"model" jsonschema:"description=Gemini model to use: pro\\, flash\\, flash-lite or a full model id (default: flash)"`
+	SafetyThreshold  string  `json:"safety_threshold" jsonschema:"description=Block threshold for every harm category: BLOCK_NONE\\, BLOCK_ONLY_HIGH\\, BLOCK_MEDIUM_AND_ABOVE\\, BLOCK_LOW_AND_ABOVE or OFF (default: GEMINI_SAFETY_SETTINGS or Google's defaults)"`
+	AutoContinue     bool    `json:"auto_continue" jsonschema:"description=Keep asking for more when the answer is cut off at the token limit and stitch the parts together"`
+	MaxContinuations int     `json:"max_continuations" jsonschema:"description=Maximum continuation turns with auto_continue (default: 3\\, max: 10)"`
+	SessionID        string  `json:"session_id" jsonschema:"description=History session to record this exchange under (default: this server run's session)"`
+	HedgeWith        string  `json:"hedge_with" jsonschema:"description=Backup provider to also ask if this one is slower than usual; the first good answer wins"`
+	HedgePercentile  float64 `json:"hedge_percentile" jsonschema:"description=Latency percentile of recent calls after which the backup is asked (default: HEDGE_PERCENTILE or 95)"`
+}
+
+// Gemini types
+type GeminiRequest struct {
+	Contents         []GeminiContent         `json:"contents"`
+	SafetySettings   []GeminiSafetySetting   `json:"safetySettings,omitempty"`
+	GenerationConfig *GeminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+type GeminiGenerationConfig struct {
+	MaxOutputTokens int `json:"maxOutputTokens,omitempty"`
+}
+
+type GeminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []GeminiPart `json:"parts"`
+}
+
+type GeminiPart struct {
+	Text    string `json:"text"`
+	Thought bool   `json:"thought,omitempty"`
+}
+
+type GeminiSafetySetting struct {
+	Category  string `json:"category"`
+	Threshold string `json:"threshold"`
+}
+
+type GeminiSafetyRating struct {
+	Category    string `json:"category"`
+	Probability string `json:"probability"`
+	Blocked     bool   `json:"blocked,omitempty"`
+}
+
+type GeminiResponse struct {
+	Candidates []struct {
+		Content       GeminiContent        `json:"content"`
+		FinishReason  string               `json:"finishReason"`
+		FinishMessage string               `json:"finishMessage"`
+		SafetyRatings []GeminiSafetyRating `json:"safetyRatings"`
+	} `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason        string               `json:"blockReason"`
+		BlockReasonMessage string               `json:"blockReasonMessage"`
+		SafetyRatings      []GeminiSafetyRating `json:"safetyRatings"`
+	} `json:"promptFeedback"`
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+	ModelVersion string `json:"modelVersion"`
+}
+
+func askGemini(ctx context.Context, req completionRequest) (*completion, error) {
+	apiKey := providerKey(ctx, "GEMINI_API_KEY")
+	if apiKey == "" {
+		return nil, errors.New("GEMINI_API_KEY not found in environment")
+	}
+
+	model := resolveGeminiModel(req.Model)
+	requestBody := GeminiRequest{
+		Contents:       geminiContents(req.Messages),
+		SafetySettings: req.SafetySettings,
+	}
+	if req.MaxTokens > 0 {
+		requestBody.GenerationConfig = &GeminiGenerationConfig{MaxOutputTokens: req.MaxTokens}
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// The key goes in a header rather than ?key= so it can't end up in net/http errors
+	endpoint, err := geminiModelURL(model, "generateContent")
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-goog-api-key", apiKey)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, upstreamError("Gemini", resp.StatusCode, body)
+	}
+
+	var geminiResp GeminiResponse
+	err = json.NewDecoder(resp.Body).Decode(&geminiResp)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseGeminiResponse(model, &geminiResp)
+}
+
+// parseGeminiResponse joins every text part of the first candidate and turns
+// refusals into errors that say when and why Gemini refused
+func parseGeminiResponse(model string, geminiResp *GeminiResponse) (*completion, error) {
+	if feedback := geminiResp.PromptFeedback; feedback.BlockReason != "" {
+		msg := fmt.Sprintf("Gemini blocked the prompt before answering: %s", feedback.BlockReason)
+		if feedback.BlockReasonMessage != "" {
+			msg += " (" + feedback.BlockReasonMessage + ")"
+		}
+		if flagged := flaggedSafetyRatings(feedback.SafetyRatings); len(flagged) > 0 {
+			msg += "; flagged: " + formatSafetyRatings(flagged)
+		}
+		return nil, errors.New(msg)
+	}
+
+	if len(geminiResp.Candidates) == 0 {
+		return nil, errors.New("no response from Gemini")
+	}
+
+	candidate := geminiResp.Candidates[0]
+	var parts []string
+	for _, part := range candidate.Content.Parts {
+		if part.Thought || part.Text == "" {
+			continue
+		}
+		parts = append(parts, part.Text)
+	}
+
+	result := &completion{
+		Model:         model,
+		Text:          strings.Join(parts, ""),
+		FinishReason:  candidate.FinishReason,
+		Truncated:     candidate.FinishReason == "MAX_TOKENS",
+		InputTokens:   geminiResp.UsageMetadata.PromptTokenCount,
+		OutputTokens:  geminiResp.UsageMetadata.CandidatesTokenCount,
+		SafetyRatings: candidate.SafetyRatings,
+	}
+	if geminiResp.ModelVersion != "" {
+		result.Model = geminiResp.ModelVersion
+	}
+
+	switch candidate.FinishReason {
+	case "", "STOP", "MAX_TOKENS":
+	default:
+		// SAFETY, RECITATION, BLOCKLIST, PROHIBITED_CONTENT, SPII, ...: the answer was
+		// withheld or cut short on purpose, so don't pass a partial answer off as complete
+		msg := fmt.Sprintf("Gemini stopped the answer: finish reason %s", candidate.FinishReason)
+		if candidate.FinishMessage != "" {
+			msg += " (" + candidate.FinishMessage + ")"
+		}
+		if flagged := flaggedSafetyRatings(candidate.SafetyRatings); len(flagged) > 0 {
+			msg += "; flagged: " + formatSafetyRatings(flagged)
+		}
+		if result.Text != "" {
+			msg += fmt.Sprintf("; %d characters were produced before it stopped", len(result.Text))
+		}
+		return nil, errors.New(msg)
+	}
+
+	if result.Text == "" && !result.Truncated {
+		return nil, fmt.Errorf("no response from Gemini (finish reason %s)", candidate.FinishReason)
+	}
+
+	return result, nil
+}
+
+// geminiContents converts chat messages to Gemini contents, where the assistant role is "model"
+func geminiContents(messages []Message) []GeminiContent {
+	var contents []GeminiContent
+	for _, m := range messages {
+		role := m.Role
+		if role == "assistant" {
+			role = "model"
+		}
+		contents = append(contents, GeminiContent{Role: role, Parts: []GeminiPart{{Text: m.Content}}})
+	}
+	return contents
+}
+
+// resolveGeminiModel expands the short aliases and falls back to the default model
+func resolveGeminiModel(model string) string {
+	model = strings.TrimPrefix(strings.TrimSpace(model), "models/")
+	if model == "" {
+		return defaultGeminiModel
+	}
+	if id, ok := geminiModelAliases[strings.ToLower(model)]; ok {
+		return id
+	}
+	return model
+}
+
+// geminiModelURL is the endpoint for a method of a model. The id comes from the
+// client, so anything that could change the request path is rejected.
+func geminiModelURL(model, method string) (string, error) {
+	if !geminiModelPattern.MatchString(model) || strings.Trim(model, ".") == "" {
+		return "", fmt.Errorf("invalid Gemini model id %q (use letters, digits, '.', '_' or '-', e.g. gemini-2.5-flash)", model)
+	}
+	return geminiBaseURL + url.PathEscape(model) + ":" + method, nil
+}
+
+// geminiSafetySettings builds the safetySettings for a request. A threshold
+// argument applies to every category; otherwise GEMINI_SAFETY_SETTINGS may hold
+// per-category overrides such as
+// "HARM_CATEGORY_HARASSMENT=BLOCK_ONLY_HIGH,HARM_CATEGORY_HATE_SPEECH=BLOCK_NONE".
+// With neither, Google's defaults apply.
+func geminiSafetySettings(threshold string) ([]GeminiSafetySetting, error) {
+	if threshold = strings.ToUpper(strings.TrimSpace(threshold)); threshold != "" {
+		if !geminiThresholds[threshold] {
+			return nil, fmt.Errorf("unknown Gemini safety threshold %q", threshold)
+		}
+		var settings []GeminiSafetySetting
+		for _, category := range geminiHarmCategories {
+			settings = append(settings, GeminiSafetySetting{Category: category, Threshold: threshold})
+		}
+		return settings, nil
+	}
+
+	raw := strings.TrimSpace(os.Getenv("GEMINI_SAFETY_SETTINGS"))
+	if raw == "" {
+		return nil, nil
+	}
+
+	var settings []GeminiSafetySetting
+	for _, entry := range strings.Split(raw, ",") {
+		category, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		category = strings.ToUpper(strings.TrimSpace(category))
+		value = strings.ToUpper(strings.TrimSpace(value))
+		if !ok || category == "" || !geminiThresholds[value] {
+			return nil, fmt.Errorf("invalid GEMINI_SAFETY_SETTINGS entry %q", entry)
+		}
+		if !strings.HasPrefix(category, "HARM_CATEGORY_") {
+			category = "HARM_CATEGORY_" + category
+		}
+		settings = append(settings, GeminiSafetySetting{Category: category, Threshold: value})
+	}
+	return settings, nil
+}
+
+// flaggedSafetyRatings returns the ratings above NEGLIGIBLE or that caused a block
+func flaggedSafetyRatings(ratings []GeminiSafetyRating) []GeminiSafetyRating {
+	var flagged []GeminiSafetyRating
+	for _, r := range ratings {
+		if r.Blocked || (r.Probability != "" && r.Probability != "NEGLIGIBLE") {
+			flagged = append(flagged, r)
+		}
+	}
+	return flagged
+}
+
+func formatSafetyRatings(ratings []GeminiSafetyRating) string {
+	var out []string
+	for _, r := range ratings {
+		s := strings.TrimPrefix(r.Category, "HARM_CATEGORY_") + "=" + r.Probability
+		if r.Blocked {
+			s += " (blocked)"
+		}
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return strings.Join(out, ", ")
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useGeminiServer points the Gemini endpoint at a mock server for one test
+func useGeminiServer(t *testing.T, handler http.HandlerFunc) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	base := geminiBaseURL
+	geminiBaseURL = server.URL + "/v1beta/models/"
+	t.Cleanup(func() { geminiBaseURL = base })
+
+	t.Setenv("GEMINI_API_KEY", "gemini-test-key")
+}
+
+// Test that every text part is joined and the finish reason is reported
+func TestAskGeminiMultiPart(t *testing.T) {
+	useGeminiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1beta/models/gemini-2.5-pro:generateContent" {
+			t.Errorf("Unexpected path '%s'", r.URL.Path)
+		}
+		if r.URL.RawQuery != "" {
+			t.Errorf("Expected no query string, got '%s'", r.URL.RawQuery)
+		}
+		if r.Header.Get("x-goog-api-key") != "gemini-test-key" {
+			t.Error("Expected API key in x-goog-api-key header")
+		}
+
+		var req GeminiRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if len(req.SafetySettings) != len(geminiHarmCategories) || req.SafetySettings[0].Threshold != "BLOCK_ONLY_HIGH" {
+			t.Errorf("Expected safety settings for every category, got %+v", req.SafetySettings)
+		}
+
+		w.Write([]byte(`{"candidates":[{"content":{"parts":[{"text":"thinking","thought":true},{"text":"Hello, "},{"text":"world"}]},
+			"finishReason":"MAX_TOKENS","safetyRatings":[{"category":"HARM_CATEGORY_HARASSMENT","probability":"NEGLIGIBLE"}]}]}`))
+	})
+
+	safetySettings, err := geminiSafetySettings("block_only_high")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	result, err := askGemini(context.Background(), completionRequest{Model: "pro", Messages: userMessage("hi"), SafetySettings: safetySettings})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Text != "Hello, world" {
+		t.Errorf("Expected 'Hello, world', got '%s'", result.Text)
+	}
+	if result.FinishReason != "MAX_TOKENS" || !result.Truncated {
+		t.Errorf("Expected truncated MAX_TOKENS answer, got '%s'", result.FinishReason)
+	}
+	out := result.String()
+	if !strings.Contains(out, "truncated: true") || !strings.Contains(out, "HARASSMENT=NEGLIGIBLE") {
+		t.Errorf("Expected finish reason and ratings in output, got '%s'", out)
+	}
+}
+
+// Test a blocked prompt is reported with its reason
+func TestParseGeminiBlockedPrompt(t *testing.T) {
+	var resp GeminiResponse
+	json.Unmarshal([]byte(`{"promptFeedback":{"blockReason":"SAFETY","safetyRatings":[
+		{"category":"HARM_CATEGORY_DANGEROUS_CONTENT","probability":"HIGH","blocked":true},
+		{"category":"HARM_CATEGORY_HARASSMENT","probability":"NEGLIGIBLE"}]}}`), &resp)
+
+	_, err := parseGeminiResponse(defaultGeminiModel, &resp)
+	if err == nil {
+		t.Fatal("Expected an error for a blocked prompt")
+	}
+	if !strings.Contains(err.Error(), "blocked the prompt") || !strings.Contains(err.Error(), "DANGEROUS_CONTENT=HIGH (blocked)") {
+		t.Errorf("Expected block reason and flagged category, got '%s'", err.Error())
+	}
+	if strings.Contains(err.Error(), "HARASSMENT") {
+		t.Errorf("Expected negligible ratings to be left out, got '%s'", err.Error())
+	}
+}
+
+// Test a SAFETY finish reason becomes an error rather than an empty answer
+func TestParseGeminiSafetyStop(t *testing.T) {
+	var resp GeminiResponse
+	json.Unmarshal([]byte(`{"candidates":[{"content":{"parts":[]},"finishReason":"SAFETY",
+		"safetyRatings":[{"category":"HARM_CATEGORY_HATE_SPEECH","probability":"MEDIUM","blocked":true}]}]}`), &resp)
+
+	_, err := parseGeminiResponse(defaultGeminiModel, &resp)
+	if err == nil || !strings.Contains(err.Error(), "finish reason SAFETY") {
+		t.Errorf("Expected a SAFETY error, got %v", err)
+	}
+}
+
+// Test safety settings from the environment
+func TestGeminiSafetySettingsFromEnv(t *testing.T) {
+	t.Setenv("GEMINI_SAFETY_SETTINGS", "harassment=BLOCK_NONE, HARM_CATEGORY_HATE_SPEECH=block_low_and_above")
+
+	settings, err := geminiSafetySettings("")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(settings) != 2 || settings[0].Category != "HARM_CATEGORY_HARASSMENT" || settings[1].Threshold != "BLOCK_LOW_AND_ABOVE" {
+		t.Errorf("Unexpected settings %+v", settings)
+	}
+
+	t.Setenv("GEMINI_SAFETY_SETTINGS", "harassment=SOMETIMES")
+	if _, err := geminiSafetySettings(""); err == nil {
+		t.Error("Expected an error for an unknown threshold")
+	}
+}
+
+// Test model aliases
+func TestResolveGeminiModel(t *testing.T) {
+	cases := map[string]string{
+		"":                        defaultGeminiModel,
+		"flash-lite":              "gemini-2.5-flash-lite",
+		"models/gemini-1.5-flash": "gemini-1.5-flash",
+	}
+	for input, want := range cases {
+		if got := resolveGeminiModel(input); got != want {
+			t.Errorf("resolveGeminiModel(%q): expected '%s', got '%s'", input, want, got)
+		}
+	}
+}
+
+// Test model ids that could rewrite the request path are rejected before any request is sent
+func TestGeminiInvalidModel(t *testing.T) {
+	requests := 0
+	useGeminiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"candidates":[{"content":{"parts":[{"text":"ok"}]},"finishReason":"STOP"}]}`))
+	})
+
+	for _, model := range []string{"../../v1/files", "gemini-2.5-flash?alt=sse", "gemini#x", "gemini/other", ".."} {
+		if _, err := askGemini(context.Background(), completionRequest{Messages: userMessage("hi"), Model: model}); err == nil || !strings.Contains(err.Error(), "invalid Gemini model id") {
+			t.Errorf("Expected '%s' to be rejected, got %v", model, err)
+		}
+		if _, err := countGeminiTokens(context.Background(), model, userMessage("hi")); err == nil {
+			t.Errorf("Expected counting tokens with '%s' to be rejected", model)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("Expected no request to reach Gemini, got %d", requests)
+	}
+
+	if _, err := askGemini(context.Background(), completionRequest{Messages: userMessage("hi"), Model: "gemini-2.5-flash"}); err != nil || requests != 1 {
+		t.Errorf("Expected a valid model id to be sent, got %v after %d requests", err, requests)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Hedging defaults; HEDGE_PERCENTILE and HEDGE_DELAY override them
+const (
+	defaultHedgePercentile = 95
+	defaultHedgeDelay      = 2 * time.Second
+	minHedgeSamples        = 5
+)
+
+// hedgeOptions asks a backup provider when the primary is slower than usual
+type hedgeOptions struct {
+	Backup     string  // provider to hedge with; empty disables hedging
+	Percentile float64 // of the primary's recent latencies, 1-99
+}
+
+// hedgeReport says how a hedged call went
+type hedgeReport struct {
+	Primary    string
+	Backup     string
+	Delay      time.Duration // how long the primary had before the backup was asked
+	BackupSent bool
+	Winner     string
+}
+
+func (h *hedgeReport) String() string {
+	if !h.BackupSent {
+		return fmt.Sprintf("hedge: %s answered within %s, %s was not asked", h.Primary, h.Delay.Round(time.Millisecond), h.Backup)
+	}
+	return fmt.Sprintf("hedge: %s was asked after %s; winner: %s", h.Backup, h.Delay.Round(time.Millisecond), h.Winner)
+}
+
+// hedgeAttempt is one provider's outcome in a hedged call
+type hedgeAttempt struct {
+	name   string
+	req    completionRequest
+	result *completion
+	err    error
+}
+
+// hedgeDelay is how long the primary gets before the backup is asked: the
+// requested percentile of its recent latencies, or HEDGE_DELAY until enough
+// calls have been seen
+func hedgeDelay(name string, percentile float64) time.Duration {
+	if percentile <= 0 || percentile >= 100 {
+		percentile = floatFromEnv("HEDGE_PERCENTILE", defaultHedgePercentile)
+	}
+	if providerLatency.count(name) >= minHedgeSamples {
+		if d, ok := providerLatency.percentile(name, percentile); ok {
+			return d
+		}
+	}
+	return durationFromEnv("HEDGE_DELAY", defaultHedgeDelay)
+}
+
+// askHedged runs askAndRecord, and with hedging on also asks the backup provider
+// when the primary hasn't answered within its latency percentile, or as soon as
+// it fails. The first good answer wins and the other call is cancelled through
+// its context. Only the winning exchange is recorded in history.
+func askHedged(ctx context.Context, sessionID, name string, req completionRequest, opts continueOptions, hedge hedgeOptions) (*completion, *hedgeReport, error) {
+	backup := strings.ToLower(strings.TrimSpace(hedge.Backup))
+	if backup == "" {
+		result, err := askAndRecord(ctx, sessionID, name, req, opts)
+		return result, nil, err
+	}
+	if _, ok := providers[backup]; !ok {
+		return nil, nil, fmt.Errorf("unknown hedge_with provider %q", hedge.Backup)
+	}
+	if backup == name {
+		return nil, nil, fmt.Errorf("hedge_with must be a different provider than %s", name)
+	}
+	if err := validateSessionID(sessionID); err != nil {
+		return nil, nil, err
+	}
+
+	// The backup gets the same conversation with its own default model
+	backupReq := completionRequest{Messages: req.Messages, MaxTokens: req.MaxTokens}
+	report := &hedgeReport{Primary: name, Backup: backup, Delay: hedgeDelay(name, hedge.Percentile)}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	start := time.Now()
+	attempts := make(chan hedgeAttempt, 2)
+	run := func(name string, req completionRequest) {
+		result, err := ask(ctx, name, req, opts)
+		attempts <- hedgeAttempt{name: name, req: req, result: result, err: err}
+	}
+	go run(name, req)
+
+	timer := time.NewTimer(report.Delay)
+	defer timer.Stop()
+
+	pending := 1
+	var failures []hedgeAttempt
+	sendBackup := func() {
+		if !report.BackupSent {
+			report.BackupSent = true
+			pending++
+			go run(backup, backupReq)
+		}
+	}
+
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			sendBackup()
+		case a := <-attempts:
+			pending--
+			if a.err == nil {
+				report.Winner = a.name
+				recordExchange(sessionID, a.name, a.req, start, a.result, nil)
+				return a.result, report, nil
+			}
+			failures = append(failures, a)
+			if a.name == name && ctx.Err() == nil {
+				sendBackup()
+			}
+		}
+	}
+
+	// Both failed: report the primary's error, mentioning the backup's
+	if len(failures) > 1 && failures[0].name != name {
+		failures[0], failures[1] = failures[1], failures[0]
+	}
+	primary := failures[0]
+	recordExchange(sessionID, primary.name, primary.req, start, nil, primary.err)
+	if len(failures) > 1 {
+		return nil, report, fmt.Errorf("%w (hedge with %s also failed: %v)", primary.err, failures[1].name, failures[1].err)
+	}
+	return nil, report, primary.err
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test the backup provider wins when the primary is slower than its usual latency
+func TestAskHedgedBackupWins(t *testing.T) {
+	useRoutingState(t)
+	history := useHistory(t)
+	t.Setenv("CLAUDE_API_KEY", "test-claude-key")
+	t.Setenv("OPENAI_API_KEY", "test-openai-key")
+	for i := 0; i < minHedgeSamples; i++ {
+		providerLatency.observe("claude", 20*time.Millisecond)
+	}
+
+	cancelled := make(chan struct{})
+	useProviderURL(t, &claudeMessagesURL, func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices the client hanging up once the body is read
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+			w.Write([]byte(`{"content":[{"type":"text","text":"too late"}],"stop_reason":"end_turn"}`))
+		}
+	})
+	useProviderURL(t, &openAIChatURL, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[{"message":{"content":"Canberra"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":1}}`))
+	})
+
+	result, report, err := askHedged(context.Background(), "", "claude", completionRequest{Messages: userMessage("Capital of Australia?")}, continueOptions{}, hedgeOptions{Backup: "OpenAI"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Text != "Canberra" || result.Provider != "openai" {
+		t.Errorf("Expected the backup's answer, got '%s' from %s", result.Text, result.Provider)
+	}
+	if !report.BackupSent || report.Winner != "openai" || report.Delay != 20*time.Millisecond {
+		t.Errorf("Expected openai to win after 20ms, got %+v", report)
+	}
+	if !strings.Contains(report.String(), "winner: openai") {
+		t.Errorf("Expected the report to name the winner, got '%s'", report)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Error("Expected the primary call to be cancelled")
+	}
+
+	session, _ := history.session("default-session")
+	if len(session.Entries) != 1 || session.Entries[0].Provider != "openai" {
+		t.Errorf("Expected only the winning exchange to be recorded, got %+v", session.Entries)
+	}
+}
+
+// Test a primary that answers in time is used and the backup is never asked
+func TestAskHedgedPrimaryInTime(t *testing.T) {
+	useRoutingState(t)
+	useHistory(t)
+	t.Setenv("CLAUDE_API_KEY", "test-claude-key")
+	t.Setenv("OPENAI_API_KEY", "test-openai-key")
+	t.Setenv("HEDGE_DELAY", "5s")
+
+	useProviderURL(t, &claudeMessagesURL, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"content":[{"type":"text","text":"Canberra"}],"stop_reason":"end_turn","usage":{"input_tokens":5,"output_tokens":1}}`))
+	})
+	backupCalls := 0
+	useProviderURL(t, &openAIChatURL, func(w http.ResponseWriter, r *http.Request) {
+		backupCalls++
+	})
+
+	result, report, err := askHedged(context.Background(), "", "claude", completionRequest{Messages: userMessage("Capital of Australia?")}, continueOptions{}, hedgeOptions{Backup: "openai"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Provider != "claude" || report.Winner != "claude" || report.BackupSent || backupCalls != 0 {
+		t.Errorf("Expected claude to answer alone, got %+v after %d backup calls", report, backupCalls)
+	}
+	if report.Delay != 5*time.Second {
+		t.Errorf("Expected HEDGE_DELAY before enough samples, got %s", report.Delay)
+	}
+}
+
+// Test a failing primary sends the backup right away, and bad hedge_with values are rejected
+func TestAskHedgedPrimaryFails(t *testing.T) {
+	useRoutingState(t)
+	useHistory(t)
+	t.Setenv("CLAUDE_API_KEY", "test-claude-key")
+	t.Setenv("OPENAI_API_KEY", "test-openai-key")
+	t.Setenv("HEDGE_DELAY", "1m")
+
+	useProviderURL(t, &claudeMessagesURL, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"message":"bad request"}}`))
+	})
+	useProviderURL(t, &openAIChatURL, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[{"message":{"content":"Canberra"},"finish_reason":"stop"}]}`))
+	})
+
+	start := time.Now()
+	result, report, err := askHedged(context.Background(), "", "claude", completionRequest{Messages: userMessage("Capital of Australia?")}, continueOptions{}, hedgeOptions{Backup: "openai"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Provider != "openai" || report.Winner != "openai" || time.Since(start) > 10*time.Second {
+		t.Errorf("Expected the backup to answer straight after the failure, got %+v", report)
+	}
+
+	for _, backup := range []string{"claude", "nope"} {
+		if _, _, err := askHedged(context.Background(), "", "claude", completionRequest{Messages: userMessage("hi")}, continueOptions{}, hedgeOptions{Backup: backup}); err == nil {
+			t.Errorf("Expected hedge_with '%s' to be rejected", backup)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	bolterrors "go.etcd.io/bbolt/errors"
+)
+
+// maxSessionEntries caps how many exchanges are kept per session; the oldest go first
+const maxSessionEntries = 1000
+
+var sessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// HistoryEntry is one recorded ask_* exchange
+type HistoryEntry struct {
+	ID            string    `json:"id"`
+	SessionID     string    `json:"session_id"`
+	Provider      string    `json:"provider"`
+	Model         string    `json:"model,omitempty"`
+	Question      string    `json:"question"`
+	Answer        string    `json:"answer,omitempty"`
+	FinishReason  string    `json:"finish_reason,omitempty"`
+	MaxTokens     int       `json:"max_tokens,omitempty"`
+	Truncated     bool      `json:"truncated,omitempty"`
+	Continuations int       `json:"continuations,omitempty"`
+	InputTokens   int       `json:"input_tokens,omitempty"`
+	OutputTokens  int       `json:"output_tokens,omitempty"`
+	LatencyMS     int64     `json:"latency_ms"`
+	Error         string    `json:"error,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+
+	// Rating is a 1-5 grade given with rate_answer; 0 means unrated
+	Rating int `json:"rating,omitempty"`
+}
+
+// HistorySession groups the exchanges made under one session id
+type HistorySession struct {
+	ID      string         `json:"id"`
+	Started time.Time      `json:"started"`
+	Updated time.Time      `json:"updated"`
+	Entries []HistoryEntry `json:"entries"`
+}
+
+// Buckets of the history database. Entries are keyed by time then id, so a
+// cursor walks them in order; the other buckets index into them.
+var (
+	historyEntriesBucket  = []byte("entries")  // entry key -> HistoryEntry JSON
+	historyIDsBucket      = []byte("ids")      // entry id -> entry key
+	historySessionsBucket = []byte("sessions") // one nested bucket per session: entry key -> nothing
+	historyWordsBucket    = []byte("words")    // word + 0x00 + entry key -> nothing
+)
+
+// maxIndexedWordLen keeps pasted blobs and base64 out of the word index
+const maxIndexedWordLen = 64
+
+// historyStore records exchanges in an embedded bbolt database, with a word
+// index for history_search. Until open is called nothing is recorded.
+type historyStore struct {
+	mu             sync.Mutex
+	defaultSession string
+	db             *bolt.DB
+	path           string // the database file; empty when history is off
+	temporary      bool   // the database only lives for this run and is removed on close
+
+	// onNewSession is called, outside the lock, the first time a session id is seen
+	onNewSession func(id string)
+}
+
+var conversationHistory = newHistoryStore(newSessionID())
+
+func newHistoryStore(defaultSession string) *historyStore {
+	return &historyStore{defaultSession: defaultSession}
+}
+
+// newSessionID names the default session of a server run, e.g. 20261019-153000-a1b2c3
+func newSessionID() string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// newEntryID identifies one recorded exchange
+func newEntryID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// validateSessionID checks a client-supplied session id; empty means the default session
+func validateSessionID(id string) error {
+	if id != "" && !sessionIDPattern.MatchString(id) {
+		return fmt.Errorf("invalid session_id %q: use up to 64 letters, digits, '.', '_' or '-'", id)
+	}
+	return nil
+}
+
+// historyPath is the database history is kept in; HISTORY_PATH overrides it and
+// HISTORY_PATH=off keeps history for the current run only
+func historyPath() string {
+	switch path := os.Getenv("HISTORY_PATH"); path {
+	case "":
+		return filepath.Join(stateDir(), "history.db")
+	case "off":
+		return ""
+	default:
+		return path
+	}
+}
+
+// open opens or creates the history database at path. An empty path keeps
+// history for this run only, in a temporary database removed by close.
+func (h *historyStore) open(path string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.path = path
+	if path == "" {
+		f, err := os.CreateTemp("", "mcp-history-*.db")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		path, h.temporary = f.Name(), true
+	} else if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	// bbolt locks the file; a second server on the same path waits a moment, then fails
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return historyOpenError(path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{historyEntriesBucket, historyIDsBucket, historySessionsBucket, historyWordsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return err
+	}
+	h.db = db
+	return nil
+}
+
+// openReadOnly opens an existing history database for reading, e.g. to export it
+func (h *historyStore) openReadOnly(path string) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		return historyOpenError(path, err)
+	}
+	h.mu.Lock()
+	h.db, h.path = db, path
+	h.mu.Unlock()
+	return nil
+}
+
+// historyOpenError explains the lock timeout bbolt reports while another
+// process has the database open
+func historyOpenError(path string, err error) error {
+	if errors.Is(err, bolterrors.ErrTimeout) {
+		return fmt.Errorf("%s is in use by another process (a running server keeps it locked)", path)
+	}
+	return err
+}
+
+// close closes the database, removing it when it was only kept for this run
+func (h *historyStore) close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.db == nil {
+		return nil
+	}
+	path := h.db.Path()
+	err := h.db.Close()
+	h.db = nil
+	if h.temporary {
+		os.Remove(path)
+	}
+	return err
+}
+
+// database returns the open database, or nil when history isn't being kept
+func (h *historyStore) database() *bolt.DB {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.db
+}
+
+// historyKey orders entries by time; the id keeps keys unique
+func historyKey(entry HistoryEntry) []byte {
+	return []byte(entry.Timestamp.UTC().Format("20060102T150405.000000000Z") + "/" + entry.ID)
+}
+
+// historyWords returns the distinct lowercase words of an exchange, for the index
+func historyWords(entry HistoryEntry) []string {
+	var words []string
+	seen := map[string]bool{}
+	for _, word := range wordPattern.FindAllString(strings.ToLower(entry.Question+"\n"+entry.Answer), -1) {
+		if len(word) <= maxIndexedWordLen && !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+func historyWordKey(word string, key []byte) []byte {
+	return append([]byte(word+"\x00"), key...)
+}
+
+// record stores an exchange in its session and indexes its words. An entry with
+// the id of an earlier one replaces it.
+func (h *historyStore) record(entry HistoryEntry) {
+	h.mu.Lock()
+	if entry.SessionID == "" {
+		entry.SessionID = h.defaultSession
+	}
+	notify := h.onNewSession
+	db := h.db
+	h.mu.Unlock()
+
+	if entry.ID == "" {
+		entry.ID = newEntryID()
+	}
+	if db == nil {
+		return
+	}
+
+	created := false
+	err := db.Update(func(tx *bolt.Tx) error {
+		var err error
+		created, err = putHistoryEntry(tx, entry)
+		return err
+	})
+	if err != nil {
+		log.Printf("history: could not save to %s: %v", db.Path(), err)
+		return
+	}
+	if created && notify != nil {
+		notify(entry.SessionID)
+	}
+}
+
+// putHistoryEntry writes an entry and its index records, trimming its session to
+// maxSessionEntries, and reports whether the session is new
+func putHistoryEntry(tx *bolt.Tx, entry HistoryEntry) (bool, error) {
+	ids := tx.Bucket(historyIDsBucket)
+	if old := ids.Get([]byte(entry.ID)); old != nil {
+		if err := deleteHistoryEntry(tx, append([]byte(nil), old...)); err != nil {
+			return false, err
+		}
+	}
+
+	sessions := tx.Bucket(historySessionsBucket)
+	created := sessions.Bucket([]byte(entry.SessionID)) == nil
+	session, err := sessions.CreateBucketIfNotExists([]byte(entry.SessionID))
+	if err != nil {
+		return false, err
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return false, err
+	}
+	key := historyKey(entry)
+	if err := tx.Bucket(historyEntriesBucket).Put(key, data); err != nil {
+		return false, err
+	}
+	if err := ids.Put([]byte(entry.ID), key); err != nil {
+		return false, err
+	}
+	if err := session.Put(key, nil); err != nil {
+		return false, err
+	}
+	words := tx.Bucket(historyWordsBucket)
+	for _, word := range historyWords(entry) {
+		if err := words.Put(historyWordKey(word, key), nil); err != nil {
+			return false, err
+		}
+	}
+
+	var stale [][]byte
+	n := 0
+	c := session.Cursor()
+	for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+		if n++; n > maxSessionEntries {
+			stale = append(stale, append([]byte(nil), k...))
+		}
+	}
+	for _, key := range stale {
+		if err := deleteHistoryEntry(tx, key); err != nil {
+			return false, err
+		}
+	}
+	return created, nil
+}
+
+// deleteHistoryEntry removes an entry and everything that points at it
+func deleteHistoryEntry(tx *bolt.Tx, key []byte) error {
+	entries := tx.Bucket(historyEntriesBucket)
+	var entry HistoryEntry
+	if err := json.Unmarshal(entries.Get(key), &entry); err != nil {
+		return err
+	}
+	if err := entries.Delete(key); err != nil {
+		return err
+	}
+	if err := tx.Bucket(historyIDsBucket).Delete([]byte(entry.ID)); err != nil {
+		return err
+	}
+	if session := tx.Bucket(historySessionsBucket).Bucket([]byte(entry.SessionID)); session != nil {
+		if err := session.Delete(key); err != nil {
+			return err
+		}
+	}
+	words := tx.Bucket(historyWordsBucket)
+	for _, word := range historyWords(entry) {
+		if err := words.Delete(historyWordKey(word, key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rate grades a recorded exchange
+func (h *historyStore) rate(id string, rating int) (HistoryEntry, error) {
+	if rating < 1 || rating > 5 {
+		return HistoryEntry{}, fmt.Errorf("rating must be between 1 and 5, got %d", rating)
+	}
+	db := h.database()
+	if db == nil {
+		return HistoryEntry{}, fmt.Errorf("unknown history entry %q", id)
+	}
+
+	var entry HistoryEntry
+	err := db.Update(func(tx *bolt.Tx) error {
+		key := tx.Bucket(historyIDsBucket).Get([]byte(id))
+		if key == nil {
+			return fmt.Errorf("unknown history entry %q", id)
+		}
+		entries := tx.Bucket(historyEntriesBucket)
+		if err := json.Unmarshal(entries.Get(key), &entry); err != nil {
+			return err
+		}
+		entry.Rating = rating
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return entries.Put(key, data)
+	})
+	return entry, err
+}
+
+// session returns one session with its entries, oldest first
+func (h *historyStore) session(id string) (HistorySession, bool) {
+	db := h.database()
+	if db == nil {
+		return HistorySession{}, false
+	}
+
+	out := HistorySession{ID: id}
+	found := false
+	db.View(func(tx *bolt.Tx) error {
+		session := tx.Bucket(historySessionsBucket).Bucket([]byte(id))
+		if session == nil {
+			return nil
+		}
+		found = true
+		entries := tx.Bucket(historyEntriesBucket)
+		return session.ForEach(func(key, _ []byte) error {
+			var entry HistoryEntry
+			if err := json.Unmarshal(entries.Get(key), &entry); err != nil {
+				return err
+			}
+			out.Entries = append(out.Entries, entry)
+			return nil
+		})
+	})
+	if n := len(out.Entries); n > 0 {
+		out.Started, out.Updated = out.Entries[0].Timestamp, out.Entries[n-1].Timestamp
+	}
+	return out, found
+}
+
+// sessionIDs returns the known session ids, oldest first
+func (h *historyStore) sessionIDs() []string {
+	db := h.database()
+	if db == nil {
+		return nil
+	}
+
+	var ids []string
+	started := map[string]string{}
+	db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(historySessionsBucket).ForEachBucket(func(id []byte) error {
+			first, _ := tx.Bucket(historySessionsBucket).Bucket(id).Cursor().First()
+			ids = append(ids, string(id))
+			started[string(id)] = string(first)
+			return nil
+		})
+	})
+	sort.SliceStable(ids, func(i, j int) bool { return started[ids[i]] < started[ids[j]] })
+	return ids
+}
+
+// askAndRecord runs ask and records the exchange in the given history session
+func askAndRecord(ctx context.Context, sessionID, name string, req completionRequest, opts continueOptions) (*completion, error) {
+	if err := validateSessionID(sessionID); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	result, err := ask(ctx, name, req, opts)
+	recordExchange(sessionID, name, req, start, result, err)
+	return result, err
+}
+
+// recordExchange records one question and its answer, or error, in a history session
+func recordExchange(sessionID, name string, req completionRequest, start time.Time, result *completion, err error) {
+	entry := HistoryEntry{
+		SessionID: sessionID,
+		Provider:  name,
+		Model:     req.Model,
+		MaxTokens: req.MaxTokens,
+		LatencyMS: time.Since(start).Milliseconds(),
+		Timestamp: start.UTC(),
+	}
+	if len(req.Messages) > 0 {
+		entry.Question = req.Messages[len(req.Messages)-1].Content
+	}
+	if err != nil {
+		entry.Error = redactError(err).Error()
+	} else {
+		entry.Model = defaultString(result.Model, req.Model)
+		entry.Answer = result.Text
+		entry.FinishReason = result.FinishReason
+		entry.Truncated = result.Truncated
+		entry.Continuations = result.Continuations
+		entry.InputTokens = result.InputTokens
+		entry.OutputTokens = result.OutputTokens
+	}
+	conversationHistory.record(entry)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultHistorySearchLimit = 20
+	maxHistorySearchLimit     = 200
+)
+
+// HistorySearchArguments are the history_search tool arguments
+type HistorySearchArguments struct {
+	Query     string `json:"query" jsonschema:"description=Words to look for in recorded questions and answers; every word must match the start of a word (case-insensitive). Empty lists the latest exchanges"`
+	Provider  string `json:"provider" jsonschema:"description=Only exchanges with this provider: claude\\, openai\\, gemini\\, mistral or huggingface"`
+	SessionID string `json:"session_id" jsonschema:"description=Only exchanges recorded under this session"`
+	Since     string `json:"since" jsonschema:"description=Only exchanges on or after this date (YYYY-MM-DD or RFC 3339)"`
+	Until     string `json:"until" jsonschema:"description=Only exchanges on or before this date (YYYY-MM-DD or RFC 3339)"`
+	MinRating int    `json:"min_rating" jsonschema:"description=Only exchanges rated at least this (1-5) with rate_answer"`
+	Limit     int    `json:"limit" jsonschema:"description=Maximum number of results\\, newest first (default: 20\\, max: 200)"`
+}
+
+// HistorySearchResult is the history_search tool result
+type HistorySearchResult struct {
+	Total   int            `json:"total"`
+	Results []HistoryEntry `json:"results"`
+}
+
+// historyFilter selects recorded exchanges
+type historyFilter struct {
+	Words     []string
+	Provider  string
+	SessionID string
+	Since     time.Time
+	Until     time.Time
+	MinRating int
+}
+
+// matches reports whether an entry passes every filter
+func (f historyFilter) matches(entry HistoryEntry) bool {
+	if f.Provider != "" && entry.Provider != f.Provider {
+		return false
+	}
+	if f.SessionID != "" && entry.SessionID != f.SessionID {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Timestamp.After(f.Until) {
+		return false
+	}
+	if entry.Rating < f.MinRating {
+		return false
+	}
+	if len(f.Words) == 0 {
+		return true
+	}
+	text := strings.ToLower(entry.Question + "\n" + entry.Answer)
+	for _, word := range f.Words {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// search returns the entries passing the filter, newest first. Query words are
+// looked up in the word index and dates bound the scan of the time-ordered
+// entries, so only candidate entries are decoded.
+func (h *historyStore) search(filter historyFilter) []HistoryEntry {
+	db := h.database()
+	if db == nil {
+		return nil
+	}
+
+	var found []HistoryEntry
+	db.View(func(tx *bolt.Tx) error {
+		entries := tx.Bucket(historyEntriesBucket)
+		check := func(key []byte) {
+			var entry HistoryEntry
+			if json.Unmarshal(entries.Get(key), &entry) == nil && filter.matches(entry) {
+				found = append(found, entry)
+			}
+		}
+
+		if terms := historyTerms(filter.Words); len(terms) > 0 {
+			for _, key := range indexedEntries(tx.Bucket(historyWordsBucket), terms) {
+				check(key)
+			}
+			return nil
+		}
+
+		keys := entries
+		if filter.SessionID != "" {
+			if keys = tx.Bucket(historySessionsBucket).Bucket([]byte(filter.SessionID)); keys == nil {
+				return nil
+			}
+		}
+		c := keys.Cursor()
+		var key []byte
+		if filter.Until.IsZero() {
+			key, _ = c.Last()
+		} else if key, _ = c.Seek(historyKey(HistoryEntry{Timestamp: filter.Until.Add(time.Nanosecond)})); key == nil {
+			key, _ = c.Last()
+		} else {
+			key, _ = c.Prev()
+		}
+		since := historyKey(HistoryEntry{Timestamp: filter.Since})
+		for ; key != nil && (filter.Since.IsZero() || bytes.Compare(key, since) >= 0); key, _ = c.Prev() {
+			check(key)
+		}
+		return nil
+	})
+
+	sort.SliceStable(found, func(i, j int) bool { return found[i].Timestamp.After(found[j].Timestamp) })
+	return found
+}
+
+// historyTerms splits the query words the way exchanges are indexed
+func historyTerms(words []string) []string {
+	var terms []string
+	for _, word := range words {
+		terms = append(terms, wordPattern.FindAllString(word, -1)...)
+	}
+	return terms
+}
+
+// indexedEntries returns the keys of the entries that have a word starting with
+// every term, so "goroutine" also finds "goroutines"
+func indexedEntries(words *bolt.Bucket, terms []string) [][]byte {
+	var keys [][]byte
+	for i, term := range terms {
+		matched := map[string]bool{}
+		c := words.Cursor()
+		prefix := []byte(term)
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			if sep := bytes.IndexByte(k, 0); sep >= 0 {
+				matched[string(k[sep+1:])] = true
+			}
+		}
+
+		if i == 0 {
+			for key := range matched {
+				keys = append(keys, []byte(key))
+			}
+			continue
+		}
+		kept := keys[:0]
+		for _, key := range keys {
+			if matched[string(key)] {
+				kept = append(kept, key)
+			}
+		}
+		keys = kept
+	}
+	return keys
+}
+
+// searchHistory runs a history_search call
+func searchHistory(args HistorySearchArguments) (*HistorySearchResult, error) {
+	filter, err := newHistoryFilter(args)
+	if err != nil {
+		return nil, err
+	}
+	filter.MinRating = args.MinRating
+
+	limit := defaultInt(args.Limit, defaultHistorySearchLimit)
+	if limit > maxHistorySearchLimit {
+		limit = maxHistorySearchLimit
+	}
+
+	found := conversationHistory.search(filter)
+	result := &HistorySearchResult{Total: len(found), Results: found}
+	if len(found) > limit {
+		result.Results = found[:limit]
+	}
+	if result.Results == nil {
+		result.Results = []HistoryEntry{}
+	}
+	return result, nil
+}
+
+// newHistoryFilter checks and parses the query, provider, session and date arguments
+func newHistoryFilter(args HistorySearchArguments) (historyFilter, error) {
+	filter := historyFilter{
+		Words:     strings.Fields(strings.ToLower(args.Query)),
+		Provider:  strings.ToLower(strings.TrimSpace(args.Provider)),
+		SessionID: args.SessionID,
+	}
+	if filter.Provider != "" {
+		if _, ok := providers[filter.Provider]; !ok {
+			return filter, fmt.Errorf("unknown provider %q", args.Provider)
+		}
+	}
+	if err := validateSessionID(args.SessionID); err != nil {
+		return filter, err
+	}
+
+	var err error
+	if filter.Since, err = parseHistoryDate(args.Since, false); err != nil {
+		return filter, fmt.Errorf("invalid since: %w", err)
+	}
+	if filter.Until, err = parseHistoryDate(args.Until, true); err != nil {
+		return filter, fmt.Errorf("invalid until: %w", err)
+	}
+	return filter, nil
+}
+
+// parseHistoryDate reads a YYYY-MM-DD date (UTC) or an RFC 3339 time. A bare date
+// used as an upper bound covers the whole day.
+func parseHistoryDate(value string, endOfDay bool) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a YYYY-MM-DD date or an RFC 3339 time", value)
+	}
+	if endOfDay {
+		return day.Add(24*time.Hour - time.Nanosecond), nil
+	}
+	return day, nil
+}
+
+// RateAnswerArguments are the rate_answer tool arguments
+type RateAnswerArguments struct {
+	ID     string `json:"id" jsonschema:"required,description=Id of a recorded exchange\\, as returned by history_search"`
+	Rating int    `json:"rating" jsonschema:"required,description=Grade from 1 (bad) to 5 (great)"`
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useHistory gives one test its own history store
+func useHistory(t *testing.T) *historyStore {
+	original := conversationHistory
+	conversationHistory = newHistoryStore("default-session")
+	if err := conversationHistory.open(filepath.Join(t.TempDir(), "history.db")); err != nil {
+		t.Fatalf("Unexpected error opening history: %v", err)
+	}
+	history := conversationHistory
+	t.Cleanup(func() {
+		history.close()
+		conversationHistory = original
+	})
+	return history
+}
+
+// Test ask calls are recorded under the requested or default session
+func TestAskAndRecord(t *testing.T) {
+	t.Setenv("CLAUDE_API_KEY", "test-claude-key")
+	history := useHistory(t)
+
+	var created []string
+	history.onNewSession = func(id string) { created = append(created, id) }
+
+	useProviderURL(t, &claudeMessagesURL, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"model":"claude-test","content":[{"type":"text","text":"4"}],"stop_reason":"end_turn","usage":{"input_tokens":3,"output_tokens":1}}`))
+	})
+
+	for _, session := range []string{"", "", "math"} {
+		if _, err := askAndRecord(context.Background(), session, "claude", completionRequest{Messages: userMessage("2+2?")}, continueOptions{}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if len(created) != 2 || created[0] != "default-session" || created[1] != "math" {
+		t.Errorf("Expected one notification per new session, got %v", created)
+	}
+
+	session, ok := history.session("default-session")
+	if !ok || len(session.Entries) != 2 {
+		t.Fatalf("Expected 2 entries in the default session, got %+v", session)
+	}
+	entry := session.Entries[0]
+	if entry.Question != "2+2?" || entry.Answer != "4" || entry.Model != "claude-test" || entry.OutputTokens != 1 {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+}
+
+// Test failed calls are recorded with their error and invalid session ids are rejected
+func TestAskAndRecordErrors(t *testing.T) {
+	t.Setenv("CLAUDE_API_KEY", "")
+	history := useHistory(t)
+
+	if _, err := askAndRecord(context.Background(), "", "claude", completionRequest{Messages: userMessage("hi")}, continueOptions{}); err == nil {
+		t.Fatalf("Expected an error without an API key")
+	}
+	session, _ := history.session("default-session")
+	if len(session.Entries) != 1 || session.Entries[0].Error == "" {
+		t.Errorf("Expected the failure to be recorded, got %+v", session.Entries)
+	}
+
+	if _, err := askAndRecord(context.Background(), "../etc", "claude", completionRequest{Messages: userMessage("hi")}, continueOptions{}); err == nil {
+		t.Errorf("Expected an invalid session id to be rejected")
+	}
+}
+
+// Test history survives a restart, ratings included, and sessions are trimmed to their last entries
+func TestHistoryPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	first := newHistoryStore("run-1")
+	if err := first.open(path); err != nil {
+		t.Fatalf("Unexpected error opening a new database: %v", err)
+	}
+	first.record(HistoryEntry{Provider: "claude", Question: "2+2?", Answer: "4", MaxTokens: 50, Timestamp: time.Now().UTC()})
+	first.record(HistoryEntry{SessionID: "math", Provider: "openai", Question: "3+3?", Answer: "6", Timestamp: time.Now().UTC()})
+	recorded, _ := first.session("run-1")
+	if _, err := first.rate(recorded.Entries[0].ID, 4); err != nil {
+		t.Fatalf("Unexpected error rating: %v", err)
+	}
+	if err := first.close(); err != nil {
+		t.Fatalf("Unexpected error closing: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected a private database file, got %v (%v)", info.Mode().Perm(), err)
+	}
+
+	second := newHistoryStore("run-2")
+	if err := second.open(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer second.close()
+	if ids := second.sessionIDs(); len(ids) != 2 || ids[0] != "run-1" || ids[1] != "math" {
+		t.Errorf("Expected both sessions to be loaded, got %v", ids)
+	}
+	session, _ := second.session("run-1")
+	if len(session.Entries) != 1 || session.Entries[0].Answer != "4" || session.Entries[0].MaxTokens != 50 || session.Entries[0].Rating != 4 {
+		t.Errorf("Expected the rated exchange to survive a restart, got %+v", session.Entries)
+	}
+	if _, err := second.rate("missing", 3); err == nil {
+		t.Errorf("Expected rating an unknown entry to fail")
+	}
+
+	start := time.Now().UTC()
+	for i := 0; i < maxSessionEntries+5; i++ {
+		second.record(HistoryEntry{SessionID: "busy", Provider: "claude", Question: fmt.Sprintf("question %d", i), Timestamp: start.Add(time.Duration(i) * time.Millisecond)})
+	}
+	busy, _ := second.session("busy")
+	if len(busy.Entries) != maxSessionEntries || busy.Entries[0].Question != "question 5" {
+		t.Errorf("Expected the oldest entries to be trimmed, got %d starting at %q", len(busy.Entries), busy.Entries[0].Question)
+	}
+	if found := second.search(historyFilter{Words: []string{"question"}, SessionID: "busy"}); len(found) != maxSessionEntries {
+		t.Errorf("Expected trimmed entries to leave the word index, got %d results", len(found))
+	}
+}
+
+// Test a temporary history works for one run and is removed when closed
+func TestHistoryTemporary(t *testing.T) {
+	history := newHistoryStore("run")
+	if err := history.open(""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	path := history.database().Path()
+	history.record(HistoryEntry{Provider: "claude", Question: "q", Answer: "a", Timestamp: time.Now().UTC()})
+	if ids := history.sessionIDs(); len(ids) != 1 {
+		t.Errorf("Expected the exchange to be recorded, got sessions %v", ids)
+	}
+	history.close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the temporary database to be removed, got %v", err)
+	}
+}
+
+// Test history_search matches every word and filters by provider, session and date
+func TestSearchHistory(t *testing.T) {
+	history := useHistory(t)
+	day := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	history.record(HistoryEntry{Provider: "claude", Question: "What is a goroutine?", Answer: "A lightweight thread", Timestamp: day})
+	history.record(HistoryEntry{SessionID: "go", Provider: "openai", Question: "Explain goroutine leaks", Answer: "A blocked goroutine is never freed", Timestamp: day.Add(24 * time.Hour)})
+	history.record(HistoryEntry{SessionID: "go", Provider: "openai", Question: "Capital of Australia?", Answer: "Canberra", Timestamp: day.Add(48 * time.Hour)})
+
+	cases := []struct {
+		args HistorySearchArguments
+		want []string
+	}{
+		{HistorySearchArguments{Query: "GOROUTINE"}, []string{"Explain goroutine leaks", "What is a goroutine?"}},
+		{HistorySearchArguments{Query: "goroutine thread"}, []string{"What is a goroutine?"}},
+		{HistorySearchArguments{Provider: "OpenAI", Limit: 1}, []string{"Capital of Australia?"}},
+		{HistorySearchArguments{SessionID: "go", Query: "canberra"}, []string{"Capital of Australia?"}},
+		{HistorySearchArguments{Since: "2026-03-11", Until: "2026-03-11"}, []string{"Explain goroutine leaks"}},
+		{HistorySearchArguments{Query: "rust"}, nil},
+		{HistorySearchArguments{Query: "leak"}, []string{"Explain goroutine leaks"}},
+		{HistorySearchArguments{SessionID: "go", Until: "2026-03-11"}, []string{"Explain goroutine leaks"}},
+	}
+	for _, c := range cases {
+		result, err := searchHistory(c.args)
+		if err != nil {
+			t.Fatalf("Unexpected error for %+v: %v", c.args, err)
+		}
+		var got []string
+		for _, entry := range result.Results {
+			got = append(got, entry.Question)
+		}
+		if strings.Join(got, "|") != strings.Join(c.want, "|") {
+			t.Errorf("Expected %v for %+v, got %v", c.want, c.args, got)
+		}
+	}
+
+	if result, _ := searchHistory(HistorySearchArguments{Provider: "openai", Limit: 1}); result.Total != 2 {
+		t.Errorf("Expected the total to count results past the limit, got %d", result.Total)
+	}
+	for _, args := range []HistorySearchArguments{{Provider: "nope"}, {Since: "yesterday"}, {SessionID: "../x"}} {
+		if _, err := searchHistory(args); err == nil {
+			t.Errorf("Expected %+v to be rejected", args)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Hugging Face endpoints; variables so tests can point them at a mock server
+var (
+	huggingFaceInferenceURL = "https://api-inference.huggingface.co/models/"
+	huggingFaceRouterURL    = "https://router.huggingface.co/v1/chat/completions"
+)
+
+// huggingFaceMaxLoadWait bounds how long we sleep on "model is loading" answers
+// before giving up; a request with wait_for_model lets Hugging Face hold it instead
+const huggingFaceMaxLoadWait = 60 * time.Second
+
+// huggingFaceModelPattern is the owner/name shape of a Hub model id. Chat models
+// may carry a router provider suffix such as ":cerebras".
+var huggingFaceModelPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._\-]*/[A-Za-z0-9][A-Za-z0-9._\-]*(:[A-Za-z0-9._\-]+)?$`)
+
+// Supported Hugging Face tasks
+const (
+	hfTaskChat               = "chat"
+	hfTaskTextGeneration     = "text-generation"
+	hfTaskSummarization      = "summarization"
+	hfTaskTextClassification = "text-classification"
+	hfTaskFillMask           = "fill-mask"
+)
+
+// huggingFaceDefaultModels is the model used for each task when none is given
+var huggingFaceDefaultModels = map[string]string{
+	hfTaskChat:               "meta-llama/Llama-3.1-8B-Instruct",
+	hfTaskTextGeneration:     "openai-community/gpt2",
+	hfTaskSummarization:      "facebook/bart-large-cnn",
+	hfTaskTextClassification: "distilbert/distilbert-base-uncased-finetuned-sst-2-english",
+	hfTaskFillMask:           "google-bert/bert-base-uncased",
+}
+
+type HuggingFaceArguments struct {
+	Question         string  `json:"question" jsonschema:"required,description=The question to ask Hugging Face models (for fill-mask include the model's mask token\\, e.g. [MASK])"`
+	Model            string  `json:"model" jsonschema:"description=Hugging Face model id\\, e.g. facebook/bart-large-cnn (default depends on the task)"`
+	Task             string  `json:"task" jsonschema:"description=chat\\, text-generation\\, summarization\\, text-classification or fill-mask (default: chat)"`
+	WaitForModel     bool    `json:"wait_for_model" jsonschema:"description=Ask Hugging Face to hold the request while a cold model loads instead of answering 503"`
+	AutoContinue     bool    `json:"auto_continue" jsonschema:"description=Keep asking for more when a chat or text-generation answer is cut off at the token limit"`
+	MaxContinuations int     `json:"max_continuations" jsonschema:"description=Maximum continuation turns with auto_continue (default: 3\\, max: 10)"`
+	SessionID        string  `json:"session_id" jsonschema:"description=History session to record this exchange under (default: this server run's session)"`
+	HedgeWith        string  `json:"hedge_with" jsonschema:"description=Backup provider to also ask if this one is slower than usual; the first good answer wins"`
+	HedgePercentile  float64 `json:"hedge_percentile" jsonschema:"description=Latency percentile of recent calls after which the backup is asked (default: HEDGE_PERCENTILE or 95)"`
+}
+
+// Hugging Face types
+type HuggingFaceRequest struct {
+	Inputs     string                 `json:"inputs"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Options    *HuggingFaceOptions    `json:"options,omitempty"`
+}
+
+type HuggingFaceOptions struct {
+	WaitForModel bool `json:"wait_for_model"`
+}
+
+// HuggingFaceLoading is the body of a 503 answer while a model is cold
+type HuggingFaceLoading struct {
+	Error         string  `json:"error"`
+	EstimatedTime float64 `json:"estimated_time"`
+}
+
+type HuggingFaceResponse []struct {
+	GeneratedText string `json:"generated_text"`
+	Details       *struct {
+		FinishReason    string `json:"finish_reason"`
+		GeneratedTokens int    `json:"generated_tokens"`
+	} `json:"details"`
+}
+
+type HuggingFaceSummary []struct {
+	SummaryText string `json:"summary_text"`
+}
+
+type HuggingFaceLabel struct {
+	Label string  `json:"label"`
+	Score float64 `json:"score"`
+}
+
+type HuggingFaceFillMask []struct {
+	Sequence string  `json:"sequence"`
+	Score    float64 `json:"score"`
+	TokenStr string  `json:"token_str"`
+}
+
+func askHuggingFace(ctx context.Context, req completionRequest) (*completion, error) {
+	apiKey := providerKey(ctx, "HUGGINGFACEHUB_API_TOKEN")
+	if apiKey == "" {
+		return nil, errors.New("HUGGINGFACEHUB_API_TOKEN not found in environment")
+	}
+
+	task := huggingFaceTask(req.Task)
+	if _, ok := huggingFaceDefaultModels[task]; !ok {
+		return nil, fmt.Errorf("unsupported Hugging Face task %q (use chat, text-generation, summarization, text-classification or fill-mask)", req.Task)
+	}
+	model := huggingFaceModel(req)
+	if !validHuggingFaceModel(task, model) {
+		return nil, fmt.Errorf("invalid Hugging Face model id %q (expected owner/name, e.g. facebook/bart-large-cnn)", model)
+	}
+
+	if task == hfTaskChat {
+		requestBody := OpenAIRequest{
+			Model:     model,
+			Messages:  req.Messages,
+			MaxTokens: defaultInt(req.MaxTokens, defaultMaxTokens),
+		}
+		chatResp, err := postChatCompletion(ctx, "Hugging Face", huggingFaceRouterURL, apiKey, requestBody)
+		if err != nil {
+			return nil, err
+		}
+		return openAIStyleCompletion("Hugging Face", model, chatResp)
+	}
+
+	requestBody := HuggingFaceRequest{Inputs: huggingFaceInputs(req.Messages)}
+	if task == hfTaskTextGeneration {
+		requestBody.Parameters = map[string]interface{}{
+			"max_new_tokens":   defaultInt(req.MaxTokens, 250),
+			"return_full_text": false,
+			"details":          true,
+		}
+	}
+	if req.WaitForModel {
+		requestBody.Options = &HuggingFaceOptions{WaitForModel: true}
+	}
+
+	body, err := postHuggingFaceInference(ctx, apiKey, model, requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := parseHuggingFaceTask(task, body)
+	if err != nil {
+		return nil, err
+	}
+	result.Model = model
+	return result, nil
+}
+
+// huggingFaceModel returns the requested model or the task's default
+func huggingFaceModel(req completionRequest) string {
+	if model := strings.TrimSpace(req.Model); model != "" {
+		return model
+	}
+	return huggingFaceDefaultModels[huggingFaceTask(req.Task)]
+}
+
+// validHuggingFaceModel reports whether model is an owner/name id; the provider
+// suffix is only meaningful on the chat router
+func validHuggingFaceModel(task, model string) bool {
+	match := huggingFaceModelPattern.FindStringSubmatch(model)
+	return match != nil && (match[1] == "" || task == hfTaskChat)
+}
+
+// huggingFaceModelPath escapes each segment of a model id for the inference URL
+func huggingFaceModelPath(model string) string {
+	segments := strings.Split(model, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// huggingFaceTask normalises the task argument, defaulting to chat
+func huggingFaceTask(task string) string {
+	task = strings.ToLower(strings.TrimSpace(task))
+	if task == "" {
+		return hfTaskChat
+	}
+	return task
+}
+
+// huggingFaceInputs flattens a conversation for the classic inference API. A
+// trailing assistant message is a partial answer, so text-generation carries on
+// from the prompt plus that text.
+func huggingFaceInputs(messages []Message) string {
+	var inputs strings.Builder
+	for _, m := range messages {
+		inputs.WriteString(m.Content)
+	}
+	return inputs.String()
+}
+
+// postHuggingFaceInference calls the classic inference API. Cold models answer 503
+// with an estimated_time; we sleep for that long and retry until
+// huggingFaceMaxLoadWait is spent, or let Hugging Face hold the request when the
+// caller asked for wait_for_model.
+func postHuggingFaceInference(ctx context.Context, apiKey, model string, requestBody HuggingFaceRequest) ([]byte, error) {
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := 30 * time.Second
+	if requestBody.Options != nil && requestBody.Options.WaitForModel {
+		timeout += huggingFaceMaxLoadWait
+	}
+	client := &http.Client{Timeout: timeout}
+	deadline := time.Now().Add(huggingFaceMaxLoadWait)
+
+	for {
+		req, err := http.NewRequestWithContext(ctx, "POST", huggingFaceInferenceURL+huggingFaceModelPath(model), bytes.NewBuffer(jsonData))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			return body, nil
+		}
+
+		var loading HuggingFaceLoading
+		if resp.StatusCode != http.StatusServiceUnavailable || json.Unmarshal(body, &loading) != nil || loading.EstimatedTime <= 0 {
+			return nil, upstreamError("Hugging Face", resp.StatusCode, body)
+		}
+
+		wait := time.Duration(loading.EstimatedTime * float64(time.Second))
+		if time.Now().Add(wait).After(deadline) {
+			return nil, fmt.Errorf("Hugging Face model %s is still loading (estimated %.0fs); retry later or set wait_for_model", model, loading.EstimatedTime)
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// parseHuggingFaceTask turns a task-specific inference response into a completion
+func parseHuggingFaceTask(task string, body []byte) (*completion, error) {
+	switch task {
+	case hfTaskTextGeneration:
+		var hfResp HuggingFaceResponse
+		if err := json.Unmarshal(body, &hfResp); err != nil {
+			return nil, err
+		}
+		if len(hfResp) > 0 {
+			result := &completion{Text: hfResp[0].GeneratedText, FinishReason: "stop"}
+			if details := hfResp[0].Details; details != nil {
+				result.FinishReason = details.FinishReason
+				result.Truncated = details.FinishReason == "length"
+				result.OutputTokens = details.GeneratedTokens
+			}
+			return result, nil
+		}
+
+	case hfTaskSummarization:
+		var hfResp HuggingFaceSummary
+		if err := json.Unmarshal(body, &hfResp); err != nil {
+			return nil, err
+		}
+		if len(hfResp) > 0 {
+			return &completion{Text: hfResp[0].SummaryText, FinishReason: "stop"}, nil
+		}
+
+	case hfTaskTextClassification:
+		// Single inputs come back either flat or wrapped in an outer list
+		var labels []HuggingFaceLabel
+		if err := json.Unmarshal(body, &labels); err != nil {
+			var nested [][]HuggingFaceLabel
+			if err := json.Unmarshal(body, &nested); err != nil {
+				return nil, err
+			}
+			if len(nested) > 0 {
+				labels = nested[0]
+			}
+		}
+		if len(labels) > 0 {
+			sort.Slice(labels, func(i, j int) bool { return labels[i].Score > labels[j].Score })
+			var lines []string
+			for _, l := range labels {
+				lines = append(lines, fmt.Sprintf("%s (%.4f)", l.Label, l.Score))
+			}
+			return &completion{Text: strings.Join(lines, "\n"), FinishReason: "stop"}, nil
+		}
+
+	case hfTaskFillMask:
+		var hfResp HuggingFaceFillMask
+		if err := json.Unmarshal(body, &hfResp); err != nil {
+			return nil, err
+		}
+		if len(hfResp) > 0 {
+			var lines []string
+			for _, c := range hfResp {
+				lines = append(lines, fmt.Sprintf("%s (%s, %.4f)", c.Sequence, strings.TrimSpace(c.TokenStr), c.Score))
+			}
+			return &completion{Text: strings.Join(lines, "\n"), FinishReason: "stop"}, nil
+		}
+	}
+
+	return nil, errors.New("no response from Hugging Face")
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// useHuggingFaceServer points the Hugging Face endpoints at a mock server for one test
+func useHuggingFaceServer(t *testing.T, handler http.HandlerFunc) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	inference, router := huggingFaceInferenceURL, huggingFaceRouterURL
+	huggingFaceInferenceURL = server.URL + "/models/"
+	huggingFaceRouterURL = server.URL + "/v1/chat/completions"
+	t.Cleanup(func() {
+		huggingFaceInferenceURL, huggingFaceRouterURL = inference, router
+	})
+
+	t.Setenv("HUGGINGFACEHUB_API_TOKEN", "hf_test_token")
+}
+
+// Test each classic task gets its own response parsing
+func TestParseHuggingFaceTask(t *testing.T) {
+	cases := []struct {
+		task string
+		body string
+		want string
+	}{
+		{hfTaskTextGeneration, `[{"generated_text":"hello there"}]`, "hello there"},
+		{hfTaskSummarization, `[{"summary_text":"short"}]`, "short"},
+		{hfTaskTextClassification, `[[{"label":"NEGATIVE","score":0.1},{"label":"POSITIVE","score":0.9}]]`, "POSITIVE (0.9000)\nNEGATIVE (0.1000)"},
+		{hfTaskTextClassification, `[{"label":"POSITIVE","score":0.5}]`, "POSITIVE (0.5000)"},
+		{hfTaskFillMask, `[{"sequence":"paris is nice","score":0.7,"token_str":"paris"}]`, "paris is nice (paris, 0.7000)"},
+	}
+
+	for _, c := range cases {
+		result, err := parseHuggingFaceTask(c.task, []byte(c.body))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.task, err)
+			continue
+		}
+		if got := result.Text; got != c.want {
+			t.Errorf("%s: expected '%s', got '%s'", c.task, c.want, got)
+		}
+	}
+
+	if _, err := parseHuggingFaceTask(hfTaskSummarization, []byte(`[]`)); err == nil {
+		t.Error("Expected an error for an empty response")
+	}
+}
+
+// Test the chat task goes through the router with the requested model
+func TestAskHuggingFaceChat(t *testing.T) {
+	useHuggingFaceServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("Expected router path, got '%s'", r.URL.Path)
+		}
+		var req OpenAIRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model != "Qwen/Qwen2.5-7B-Instruct" {
+			t.Errorf("Expected requested model, got '%s'", req.Model)
+		}
+		w.Write([]byte(`{"choices":[{"message":{"content":"chat answer"}}]}`))
+	})
+
+	result, err := askHuggingFace(context.Background(), completionRequest{Messages: userMessage("hi"), Model: "Qwen/Qwen2.5-7B-Instruct"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Text != "chat answer" {
+		t.Errorf("Expected 'chat answer', got '%s'", result.Text)
+	}
+}
+
+// Test a cold model is retried after its estimated load time
+func TestAskHuggingFaceModelLoading(t *testing.T) {
+	var calls int32
+	useHuggingFaceServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/models/facebook/bart-large-cnn") {
+			t.Errorf("Unexpected path '%s'", r.URL.Path)
+		}
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":"Model facebook/bart-large-cnn is currently loading","estimated_time":0.01}`))
+			return
+		}
+		w.Write([]byte(`[{"summary_text":"loaded"}]`))
+	})
+
+	result, err := askHuggingFace(context.Background(), completionRequest{Messages: userMessage("long text"), Task: "summarization"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Text != "loaded" || calls != 2 {
+		t.Errorf("Expected 'loaded' after 2 calls, got '%s' after %d", result.Text, calls)
+	}
+}
+
+// Test that an estimated load time beyond our budget fails with a clear message
+func TestAskHuggingFaceModelLoadingTooSlow(t *testing.T) {
+	useHuggingFaceServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var req HuggingFaceRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Options == nil || !req.Options.WaitForModel {
+			t.Error("Expected wait_for_model option to be sent")
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":"Model is currently loading","estimated_time":3600}`))
+	})
+
+	_, err := askHuggingFace(context.Background(), completionRequest{Messages: userMessage("x"), Task: "text-generation", WaitForModel: true})
+	if err == nil || !strings.Contains(err.Error(), "still loading") {
+		t.Errorf("Expected a loading error, got %v", err)
+	}
+}
+
+// Test unknown tasks are rejected before any request is sent
+func TestAskHuggingFaceUnknownTask(t *testing.T) {
+	t.Setenv("HUGGINGFACEHUB_API_TOKEN", "hf_test_token")
+
+	_, err := askHuggingFace(context.Background(), completionRequest{Messages: userMessage("x"), Task: "image-to-text"})
+	if err == nil || !strings.Contains(err.Error(), "unsupported") {
+		t.Errorf("Expected unsupported task error, got %v", err)
+	}
+}
+
+// Test model ids that aren't owner/name shaped are rejected before any request is sent
+func TestAskHuggingFaceInvalidModel(t *testing.T) {
+	t.Setenv("HUGGINGFACEHUB_API_TOKEN", "hf_test_token")
+
+	for _, model := range []string{"gpt2", "../../api/whoami-v2", "owner/name/extra", "owner/name?x=1", "facebook/bart-large-cnn:fast"} {
+		_, err := askHuggingFace(context.Background(), completionRequest{Messages: userMessage("x"), Task: "summarization", Model: model})
+		if err == nil || !strings.Contains(err.Error(), "invalid Hugging Face model id") {
+			t.Errorf("Expected invalid model error for '%s', got %v", model, err)
+		}
+	}
+
+	if !validHuggingFaceModel(hfTaskChat, "meta-llama/Llama-3.1-8B-Instruct:cerebras") {
+		t.Error("Expected chat models to accept a provider suffix")
+	}
+	if got := huggingFaceModelPath("facebook/bart-large-cnn"); got != "facebook/bart-large-cnn" {
+		t.Errorf("Expected 'facebook/bart-large-cnn', got '%s'", got)
+	}
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mcp_golang "github.com/metoro-io/mcp-golang"
+)
+
+// Job limits; JOB_CONCURRENCY and JOB_RETENTION override the defaults
+const (
+	defaultJobConcurrency = 2
+	defaultJobRetention   = 7 * 24 * time.Hour
+	maxListedJobs         = 20
+)
+
+// Job statuses. Queued and running jobs are active; the others are final.
+const (
+	jobQueued      = "queued"
+	jobRunning     = "running"
+	jobSucceeded   = "succeeded"
+	jobFailed      = "failed"
+	jobCancelled   = "cancelled"
+	jobInterrupted = "interrupted"
+)
+
+// jobTools can't be run as jobs themselves
+var jobTools = []string{"submit_job", "job_status", "job_result", "cancel_job"}
+
+// resumableTools only read and answer, so running them again from the start
+// after a restart is safe. Tools that write files or state, plugins and OpenAPI
+// operations are never resumed.
+var resumableTools = map[string]bool{
+	"zipcode":         true,
+	"ask_claude":      true,
+	"ask_openai":      true,
+	"ask_gemini":      true,
+	"ask_mistral":     true,
+	"ask_huggingface": true,
+	"ask_best":        true,
+	"list_models":     true,
+	"provider_status": true,
+	"summarize":       true,
+	"count_tokens":    true,
+	"translate":       true,
+	"history_search":  true,
+}
+
+// Job is a tool call running in the background
+type Job struct {
+	ID         string          `json:"id"`
+	Tool       string          `json:"tool"`
+	Arguments  json.RawMessage `json:"arguments,omitempty"`
+	Resume     bool            `json:"resume"`
+	Status     string          `json:"status"`
+	Attempts   int             `json:"attempts"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	Result     string          `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+func (j *Job) active() bool {
+	return j.Status == jobQueued || j.Status == jobRunning
+}
+
+// SubmitJobArguments starts a tool call in the background
+type SubmitJobArguments struct {
+	Tool      string                 `json:"tool" jsonschema:"required,description=Name of the tool to run\\, e.g. ask_batch or summarize"`
+	Arguments map[string]interface{} `json:"arguments,omitempty" jsonschema:"description=Arguments of the tool\\, as it would be called directly"`
+	Resume    bool                   `json:"resume,omitempty" jsonschema:"description=Run the job again from the start if the server restarts before it finishes (default false). Only the read-only tools listed in the submit_job description can be resumed"`
+}
+
+// JobStatusArguments asks for one job, or the most recent ones
+type JobStatusArguments struct {
+	JobID string `json:"job_id,omitempty" jsonschema:"description=Job to report on; without it the 20 most recent jobs are listed"`
+}
+
+// JobIDArguments names a job
+type JobIDArguments struct {
+	JobID string `json:"job_id" jsonschema:"required,description=Job id returned by submit_job"`
+}
+
+// jobManager runs tool calls in the background, at most JOB_CONCURRENCY at a
+// time, and keeps their state in a file so it survives restarts. Jobs still
+// active when the server stops are run again at the next start when they are
+// resumable, and marked interrupted otherwise.
+type jobManager struct {
+	mu      sync.Mutex
+	saveMu  sync.Mutex // orders writes of the state file
+	path    string
+	jobs    map[string]*Job
+	cancels map[string]context.CancelFunc
+	slots   chan struct{}
+	wg      sync.WaitGroup
+
+	// run calls a tool; base is cancelled when the server shuts down
+	run  func(ctx context.Context, tool string, args json.RawMessage) (*mcp_golang.ToolResponse, error)
+	has  func(tool string) bool
+	base context.Context
+}
+
+var backgroundJobs = newJobManager(serverLifecycle.ctx)
+
+func newJobManager(base context.Context) *jobManager {
+	return &jobManager{
+		jobs:    map[string]*Job{},
+		cancels: map[string]context.CancelFunc{},
+		slots:   make(chan struct{}, max(intFromEnv("JOB_CONCURRENCY", defaultJobConcurrency), 1)),
+		base:    base,
+	}
+}
+
+// jobsPath is the file job state is kept in
+func jobsPath() string {
+	return filepath.Join(stateDir(), "jobs.json")
+}
+
+// open loads the jobs saved at path and drops finished ones past JOB_RETENTION.
+// Jobs left active by the previous run are queued again when resumable and
+// marked interrupted otherwise; resume starts the queued ones.
+func (m *jobManager) open(path string) error {
+	m.mu.Lock()
+	m.path = path
+	m.slots = make(chan struct{}, max(intFromEnv("JOB_CONCURRENCY", defaultJobConcurrency), 1))
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		m.mu.Unlock()
+		return nil
+	}
+	if err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	var jobs []*Job
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	now := time.Now()
+	for _, job := range jobs {
+		if job.active() {
+			job.StartedAt = nil
+			if job.Resume && resumableTools[job.Tool] {
+				job.Status = jobQueued
+			} else {
+				job.Status, job.Error, job.FinishedAt = jobInterrupted, "the server stopped before the job finished", &now
+			}
+		}
+		m.jobs[job.ID] = job
+	}
+	m.prune(now)
+	m.mu.Unlock()
+	return m.save()
+}
+
+// prune drops finished jobs past the retention period; m.mu must be held
+func (m *jobManager) prune(now time.Time) {
+	retention := durationFromEnv("JOB_RETENTION", defaultJobRetention)
+	for id, job := range m.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > retention {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+// resume starts the jobs queued when the server last stopped
+func (m *jobManager) resume() {
+	m.mu.Lock()
+	var queued []*Job
+	for _, job := range m.jobs {
+		if job.Status == jobQueued {
+			queued = append(queued, job)
+		}
+	}
+	sort.Slice(queued, func(i, j int) bool { return queued[i].CreatedAt.Before(queued[j].CreatedAt) })
+	for _, job := range queued {
+		m.start(job)
+	}
+	m.mu.Unlock()
+	if len(queued) > 0 {
+		log.Printf("jobs: resumed %d jobs", len(queued))
+	}
+}
+
+// submit queues a tool call and starts it as soon as a slot is free
+func (m *jobManager) submit(tool string, args map[string]interface{}, resume bool) (*Job, error) {
+	for _, name := range jobTools {
+		if tool == name {
+			return nil, fmt.Errorf("%s can't be run as a job", tool)
+		}
+	}
+	if m.has == nil || !m.has(tool) {
+		return nil, fmt.Errorf("unknown tool %s", tool)
+	}
+	if !toolEnabled(tool) {
+		return nil, fmt.Errorf("tool %s is disabled on this server", tool)
+	}
+	if resume && !resumableTools[tool] {
+		return nil, fmt.Errorf("%s can't be resumed after a restart; only read-only tools can, so submit it with resume false", tool)
+	}
+	var encoded json.RawMessage
+	if args != nil {
+		data, err := json.Marshal(args)
+		if err != nil {
+			return nil, err
+		}
+		encoded = data
+	}
+
+	job := &Job{ID: newEntryID(), Tool: tool, Arguments: encoded, Resume: resume, Status: jobQueued, CreatedAt: time.Now()}
+	m.mu.Lock()
+	m.prune(job.CreatedAt)
+	m.jobs[job.ID] = job
+	m.start(job)
+	copied := *job
+	m.mu.Unlock()
+	return &copied, m.save()
+}
+
+// start runs a queued job in the background; m.mu must be held
+func (m *jobManager) start(job *Job) {
+	ctx, cancel := context.WithCancel(m.base)
+	m.cancels[job.ID] = cancel
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer cancel()
+
+		select {
+		case m.slots <- struct{}{}:
+			defer func() { <-m.slots }()
+		case <-ctx.Done():
+			m.finish(job.ID, ctx, nil, ctx.Err())
+			return
+		}
+
+		m.mu.Lock()
+		if job.Status != jobQueued {
+			m.mu.Unlock()
+			return
+		}
+		now := time.Now()
+		job.Status, job.StartedAt = jobRunning, &now
+		job.Attempts++
+		tool, args := job.Tool, job.Arguments
+		m.mu.Unlock()
+		if err := m.save(); err != nil {
+			log.Printf("jobs: saving state: %v", err)
+		}
+
+		response, err := m.run(ctx, tool, args)
+		m.finish(job.ID, ctx, response, err)
+	}()
+}
+
+// finish records how a job ended. A job cancelled with cancel_job stays
+// cancelled; one stopped by shutdown stays queued if it is resumable.
+func (m *jobManager) finish(id string, ctx context.Context, response *mcp_golang.ToolResponse, err error) {
+	m.mu.Lock()
+	job := m.jobs[id]
+	delete(m.cancels, id)
+	if job == nil || !job.active() {
+		m.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	switch {
+	case ctx.Err() != nil && m.base.Err() != nil:
+		if job.Resume {
+			job.Status, job.StartedAt = jobQueued, nil
+		} else {
+			job.Status, job.Error, job.FinishedAt = jobInterrupted, "the server stopped before the job finished", &now
+		}
+	case err != nil:
+		job.Status, job.Error, job.FinishedAt = jobFailed, redactSecrets(err.Error()), &now
+	default:
+		job.Status, job.Result, job.FinishedAt = jobSucceeded, responseText(response), &now
+	}
+	m.mu.Unlock()
+	if err := m.save(); err != nil {
+		log.Printf("jobs: saving state: %v", err)
+	}
+}
+
+// responseText joins the text content of a tool response
+func responseText(response *mcp_golang.ToolResponse) string {
+	if response == nil {
+		return ""
+	}
+	var parts []string
+	for _, content := range response.Content {
+		if content != nil && content.TextContent != nil {
+			parts = append(parts, content.TextContent.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// cancel stops a queued or running job
+func (m *jobManager) cancel(id string) (*Job, error) {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	if !ok {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("no job %s", id)
+	}
+	if !job.active() {
+		status := job.Status
+		m.mu.Unlock()
+		return nil, fmt.Errorf("job %s already %s", id, status)
+	}
+	now := time.Now()
+	job.Status, job.FinishedAt = jobCancelled, &now
+	if cancel := m.cancels[id]; cancel != nil {
+		cancel()
+	}
+	copied := *job
+	m.mu.Unlock()
+	return &copied, m.save()
+}
+
+// get returns a copy of a job
+func (m *jobManager) get(id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("no job %s", id)
+	}
+	copied := *job
+	return &copied, nil
+}
+
+// list returns copies of the most recent jobs, newest first
+func (m *jobManager) list(limit int) []*Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		copied := *job
+		jobs = append(jobs, &copied)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	return jobs
+}
+
+// result returns what a succeeded job's tool returned
+func (m *jobManager) result(id string) (string, error) {
+	job, err := m.get(id)
+	if err != nil {
+		return "", err
+	}
+	switch job.Status {
+	case jobSucceeded:
+		return job.Result, nil
+	case jobFailed, jobInterrupted:
+		return "", fmt.Errorf("job %s %s: %s", id, job.Status, job.Error)
+	default:
+		return "", fmt.Errorf("job %s is %s; check job_status and try again later", id, job.Status)
+	}
+}
+
+// stop waits a moment for jobs cancelled by shutdown to unwind and saves their
+// state. It runs as a shutdown hook, after the server context is cancelled.
+func (m *jobManager) stop() error {
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+	}
+	return m.save()
+}
+
+// save writes every job to the state file through a temporary file
+func (m *jobManager) save() error {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+	m.mu.Lock()
+	if m.path == "" {
+		m.mu.Unlock()
+		return nil
+	}
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.Before(jobs[j].CreatedAt) })
+	data, err := json.MarshalIndent(jobs, "", "  ")
+	path := m.path
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Jobs keep their arguments and results, so the file is as private as history
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// resumableToolNames lists resumableTools in a stable order
+func resumableToolNames() []string {
+	names := make([]string, 0, len(resumableTools))
+	for name := range resumableTools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// registerJobTools registers submit_job, job_status, job_result and cancel_job
+func registerJobTools(tools *toolRegistry, jobs *jobManager) error {
+	description := "Start any other tool call in the background, e.g. a long ask_batch or summarize, and get a job id to poll with job_status and job_result; jobs survive server restarts. These read-only tools can be resumed after a restart with resume true: " + strings.Join(resumableToolNames(), ", ")
+	err := tools.register("submit_job", description, func(arguments SubmitJobArguments) (*mcp_golang.ToolResponse, error) {
+		job, err := jobs.submit(arguments.Tool, arguments.Arguments, arguments.Resume)
+		if err != nil {
+			return nil, redactError(err)
+		}
+
+		return newTextResponse(fmt.Sprintf("job %s submitted: %s is %s. Poll job_status with this id, then read the answer with job_result.", job.ID, job.Tool, job.Status)), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = tools.register("job_status", "Show the status of a background job, or list the most recent jobs", func(arguments JobStatusArguments) (*mcp_golang.ToolResponse, error) {
+		var result interface{}
+		if arguments.JobID == "" {
+			listed := jobs.list(maxListedJobs)
+			for _, job := range listed {
+				job.Arguments, job.Result = nil, ""
+			}
+			result = listed
+		} else {
+			job, err := jobs.get(arguments.JobID)
+			if err != nil {
+				return nil, err
+			}
+			job.Result = ""
+			result = job
+		}
+
+		out, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return newTextResponse(string(out)), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = tools.register("job_result", "Get what the tool of a finished background job returned", func(arguments JobIDArguments) (*mcp_golang.ToolResponse, error) {
+		result, err := jobs.result(arguments.JobID)
+		if err != nil {
+			return nil, redactError(err)
+		}
+
+		return newTextResponse(result), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return tools.register("cancel_job", "Cancel a queued or running background job", func(arguments JobIDArguments) (*mcp_golang.ToolResponse, error) {
+		job, err := jobs.cancel(arguments.JobID)
+		if err != nil {
+			return nil, err
+		}
+
+		return newTextResponse(fmt.Sprintf("job %s (%s) cancelled", job.ID, job.Tool)), nil
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	mcp_golang "github.com/metoro-io/mcp-golang"
+)
+
+// fakeJobTools runs "echo", which returns its text argument, "fail", and
+// "block", which waits for its context
+func fakeJobTools(m *jobManager) {
+	m.has = func(tool string) bool { return tool == "echo" || tool == "fail" || tool == "block" }
+	m.run = func(ctx context.Context, tool string, args json.RawMessage) (*mcp_golang.ToolResponse, error) {
+		switch tool {
+		case "echo":
+			var decoded struct{ Text string }
+			json.Unmarshal(args, &decoded)
+			return newTextResponse(decoded.Text), nil
+		case "block":
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return nil, errors.New("no luck")
+	}
+}
+
+// allowResume lets fake tools be resumed for the length of a test
+func allowResume(t *testing.T, tools ...string) {
+	for _, tool := range tools {
+		resumableTools[tool] = true
+	}
+	t.Cleanup(func() {
+		for _, tool := range tools {
+			delete(resumableTools, tool)
+		}
+	})
+}
+
+// waitForJob polls a job until it leaves the active statuses
+func waitForJob(t *testing.T, m *jobManager, id string) *Job {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		job, err := m.get(id)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !job.active() {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Expected job %s to finish", id)
+	return nil
+}
+
+// Test jobs run tools in the background and report their results, failures and cancellation
+func TestJobs(t *testing.T) {
+	m := newJobManager(context.Background())
+	fakeJobTools(m)
+	if err := m.open(filepath.Join(t.TempDir(), "jobs.json")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	job, err := m.submit("echo", map[string]interface{}{"text": "hello"}, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if done := waitForJob(t, m, job.ID); done.Status != jobSucceeded || done.Attempts != 1 {
+		t.Errorf("Expected the job to succeed on the first attempt, got %s after %d", done.Status, done.Attempts)
+	}
+	if result, err := m.result(job.ID); err != nil || result != "hello" {
+		t.Errorf("Expected 'hello', got '%s', %v", result, err)
+	}
+
+	failed, _ := m.submit("fail", nil, false)
+	waitForJob(t, m, failed.ID)
+	if _, err := m.result(failed.ID); err == nil || !strings.Contains(err.Error(), "failed: no luck") {
+		t.Errorf("Expected the tool's error, got %v", err)
+	}
+
+	blocked, _ := m.submit("block", nil, false)
+	if _, err := m.result(blocked.ID); err == nil || !strings.Contains(err.Error(), "try again later") {
+		t.Errorf("Expected a running job to have no result yet, got %v", err)
+	}
+	if _, err := m.cancel(blocked.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if done := waitForJob(t, m, blocked.ID); done.Status != jobCancelled {
+		t.Errorf("Expected the job to be cancelled, got %s", done.Status)
+	}
+	if _, err := m.cancel(blocked.ID); err == nil {
+		t.Errorf("Expected a finished job not to be cancelled again")
+	}
+
+	for _, tool := range []string{"missing", "submit_job"} {
+		if _, err := m.submit(tool, nil, false); err == nil {
+			t.Errorf("Expected %s to be refused", tool)
+		}
+	}
+	if _, err := m.submit("echo", nil, true); err == nil || !strings.Contains(err.Error(), "can't be resumed") {
+		t.Errorf("Expected resuming a tool off the read-only list to be refused, got %v", err)
+	}
+	if jobs := m.list(maxListedJobs); len(jobs) != 3 || jobs[0].ID != blocked.ID {
+		t.Errorf("Expected 3 jobs, newest first, got %d", len(jobs))
+	}
+}
+
+// Test jobs cut short by a shutdown are resumed at the next start when resumable
+func TestJobsResumeAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	base, shutdown := context.WithCancel(context.Background())
+	allowResume(t, "block")
+	m := newJobManager(base)
+	fakeJobTools(m)
+	m.open(path)
+
+	resumable, _ := m.submit("block", nil, true)
+	once, _ := m.submit("block", nil, false)
+	for _, id := range []string{resumable.ID, once.ID} {
+		for i := 0; i < 200; i++ {
+			if job, _ := m.get(id); job.Status == jobRunning {
+				break
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	shutdown()
+	if err := m.stop(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	restarted := newJobManager(context.Background())
+	fakeJobTools(restarted)
+	restarted.run = func(ctx context.Context, tool string, args json.RawMessage) (*mcp_golang.ToolResponse, error) {
+		return newTextResponse("done"), nil
+	}
+	if err := restarted.open(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected a private jobs file, got %v (%v)", info.Mode().Perm(), err)
+	}
+	if job, _ := restarted.get(once.ID); job.Status != jobInterrupted {
+		t.Errorf("Expected the non-resumable job to be interrupted, got %s", job.Status)
+	}
+	restarted.resume()
+	job := waitForJob(t, restarted, resumable.ID)
+	if job.Status != jobSucceeded || job.Attempts != 2 {
+		t.Errorf("Expected the resumable job to succeed on its second attempt, got %s after %d", job.Status, job.Attempts)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Key selection strategies for KEY_ROTATION
+const (
+	keyRotationRoundRobin = "round_robin"
+	keyRotationLeastUsed  = "least_used"
+)
+
+// defaultKeyBenchDuration is how long a rate-limited key sits out; rejected keys
+// (401/403) sit out authBenchFactor times longer
+const (
+	defaultKeyBenchDuration = time.Minute
+	authBenchFactor         = 10
+)
+
+// KeyUsage counts the calls made with one key. Keys are identified by their last
+// four characters only.
+type KeyUsage struct {
+	Key          string     `json:"key"`
+	Requests     int        `json:"requests"`
+	Errors       int        `json:"errors"`
+	InputTokens  int        `json:"input_tokens"`
+	OutputTokens int        `json:"output_tokens"`
+	BenchedUntil *time.Time `json:"benched_until,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// poolKey is one API key and its usage
+type poolKey struct {
+	value        string
+	benchedUntil time.Time
+	usage        KeyUsage
+}
+
+func (k *poolKey) benched(now time.Time) bool {
+	return now.Before(k.benchedUntil)
+}
+
+// keyPool holds the keys configured for one provider. Keys come from the
+// provider's variable (CLAUDE_API_KEY) plus a comma-separated list in the same
+// name with an S (CLAUDE_API_KEYS), and are re-read on every call so changes to
+// the environment are picked up; usage survives as long as the key stays.
+type keyPool struct {
+	mu    sync.Mutex
+	keys  map[string]*poolKey
+	order []string
+	next  int
+}
+
+// keyPoolSet is every provider's key pool, by key variable
+type keyPoolSet struct {
+	mu    sync.Mutex
+	pools map[string]*keyPool
+}
+
+var keyPools = &keyPoolSet{pools: map[string]*keyPool{}}
+
+// apiKeyContext carries the key chosen for a call, by key variable
+type apiKeyContext struct{ env string }
+
+// configuredKeys returns the distinct keys set for a provider's key variable
+func configuredKeys(env string) []string {
+	var keys []string
+	seen := map[string]bool{}
+	for _, k := range append(strings.Split(os.Getenv(env+"S"), ","), os.Getenv(env)) {
+		if k = strings.TrimSpace(k); k != "" && !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// hasAPIKey reports whether at least one key is configured for the variable
+func hasAPIKey(env string) bool {
+	return len(configuredKeys(env)) > 0
+}
+
+// pool returns the provider's pool, in sync with the environment
+func (s *keyPoolSet) pool(env string) *keyPool {
+	s.mu.Lock()
+	p := s.pools[env]
+	if p == nil {
+		p = &keyPool{keys: map[string]*poolKey{}}
+		s.pools[env] = p
+	}
+	s.mu.Unlock()
+
+	keys := configuredKeys(env)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if strings.Join(keys, "\x00") != strings.Join(p.order, "\x00") {
+		current := map[string]*poolKey{}
+		for _, k := range keys {
+			if existing, ok := p.keys[k]; ok {
+				current[k] = existing
+			} else {
+				current[k] = &poolKey{value: k, usage: KeyUsage{Key: maskKey(k)}}
+			}
+		}
+		p.keys, p.order, p.next = current, keys, 0
+	}
+	return p
+}
+
+// choose picks a key by the KEY_ROTATION strategy, preferring keys that aren't
+// benched. When every key is benched it picks the one freed soonest, so a
+// single-key setup still reaches the provider. It returns nil when no key is set.
+func (p *keyPool) choose(count bool) *poolKey {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.order) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	var available []*poolKey
+	for i := range p.order {
+		k := p.keys[p.order[(p.next+i)%len(p.order)]]
+		if !k.benched(now) {
+			available = append(available, k)
+		}
+	}
+
+	var chosen *poolKey
+	switch {
+	case len(available) == 0:
+		for _, value := range p.order {
+			if k := p.keys[value]; chosen == nil || k.benchedUntil.Before(chosen.benchedUntil) {
+				chosen = k
+			}
+		}
+	case strings.EqualFold(os.Getenv("KEY_ROTATION"), keyRotationLeastUsed):
+		chosen = available[0]
+		for _, k := range available[1:] {
+			if k.usage.Requests < chosen.usage.Requests {
+				chosen = k
+			}
+		}
+	default:
+		chosen = available[0]
+	}
+
+	if count {
+		for i, value := range p.order {
+			if value == chosen.value {
+				p.next = (i + 1) % len(p.order)
+			}
+		}
+		chosen.usage.Requests++
+	}
+	return chosen
+}
+
+// acquire picks the key for a provider call and counts the request against it
+func (s *keyPoolSet) acquire(env string) *poolKey {
+	return s.pool(env).choose(true)
+}
+
+// release records a call's outcome against its key and benches keys the
+// provider rejected (401/403) or rate limited (429). It reports whether the key
+// was benched.
+func (s *keyPoolSet) release(env string, key *poolKey, result *completion, err error) bool {
+	p := s.pool(env)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err == nil {
+		key.usage.InputTokens += result.InputTokens
+		key.usage.OutputTokens += result.OutputTokens
+		return false
+	}
+	key.usage.Errors++
+	key.usage.LastError = redactError(err).Error()
+
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	bench := durationFromEnv("KEY_BENCH_DURATION", defaultKeyBenchDuration)
+	switch apiErr.StatusCode {
+	case 401, 403:
+		bench *= authBenchFactor
+	case 429:
+	default:
+		return false
+	}
+	key.benchedUntil = time.Now().Add(bench)
+	return true
+}
+
+// spare reports whether the provider has a key that isn't benched
+func (s *keyPoolSet) spare(env string) bool {
+	p := s.pool(env)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for _, k := range p.keys {
+		if !k.benched(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// usage reports per-key usage for every provider with keys, by provider name
+func (s *keyPoolSet) usage() map[string][]KeyUsage {
+	now := time.Now()
+	out := map[string][]KeyUsage{}
+	for _, name := range providerNames() {
+		p := s.pool(providers[name].KeyEnv)
+		p.mu.Lock()
+		for _, value := range p.order {
+			k := p.keys[value]
+			usage := k.usage
+			if k.benched(now) {
+				until := k.benchedUntil.UTC()
+				usage.BenchedUntil = &until
+			}
+			out[name] = append(out[name], usage)
+		}
+		p.mu.Unlock()
+	}
+	return out
+}
+
+// withAPIKey makes provider calls under ctx use key
+func withAPIKey(ctx context.Context, env, key string) context.Context {
+	return context.WithValue(ctx, apiKeyContext{env}, key)
+}
+
+// providerKey returns the key a provider call should send: the one chosen for this
+// call, or the pool's next key for calls outside ask such as pings and model lists
+func providerKey(ctx context.Context, env string) string {
+	if key, ok := ctx.Value(apiKeyContext{env}).(string); ok {
+		return key
+	}
+	if k := keyPools.pool(env).choose(false); k != nil {
+		return k.value
+	}
+	return ""
+}
+
+// maskKey identifies a key by its last four characters
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return "..."
+	}
+	return "..." + key[len(key)-4:]
+}
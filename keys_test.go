@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// useKeyPools gives the test empty key pools
+func useKeyPools(t *testing.T) {
+	original := keyPools
+	keyPools = &keyPoolSet{pools: map[string]*keyPool{}}
+	t.Cleanup(func() { keyPools = original })
+}
+
+// Test keys from both variables are pooled and rotated round-robin or by least use
+func TestKeyPoolRotation(t *testing.T) {
+	useKeyPools(t)
+	t.Setenv("OPENAI_API_KEYS", "key-one-1111, key-two-2222,key-one-1111")
+	t.Setenv("OPENAI_API_KEY", "key-three-3333")
+
+	if keys := configuredKeys("OPENAI_API_KEY"); len(keys) != 3 {
+		t.Fatalf("Expected 3 distinct keys, got %v", keys)
+	}
+
+	var picked []string
+	for i := 0; i < 4; i++ {
+		picked = append(picked, keyPools.acquire("OPENAI_API_KEY").value)
+	}
+	if strings.Join(picked, ",") != "key-one-1111,key-two-2222,key-three-3333,key-one-1111" {
+		t.Errorf("Expected round-robin order, got %v", picked)
+	}
+
+	t.Setenv("KEY_ROTATION", "least_used")
+	if got := keyPools.acquire("OPENAI_API_KEY").value; got != "key-two-2222" {
+		t.Errorf("Expected the least used key, got %s", got)
+	}
+
+	if redacted := redactSecrets("using key-two-2222 now"); strings.Contains(redacted, "key-two-2222") {
+		t.Errorf("Expected pooled keys to be redacted, got '%s'", redacted)
+	}
+}
+
+// Test a rate-limited key is benched and the call fails over to the next key
+func TestCompleteFailsOverToNextKey(t *testing.T) {
+	useKeyPools(t)
+	t.Setenv("CLAUDE_API_KEY", "")
+	t.Setenv("CLAUDE_API_KEYS", "limited-key-aaaa,healthy-key-bbbb")
+
+	var used []string
+	useProviderURL(t, &claudeMessagesURL, func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("x-api-key")
+		used = append(used, key)
+		if key == "limited-key-aaaa" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error":{"message":"rate limited"}}`))
+			return
+		}
+		w.Write([]byte(`{"content":[{"type":"text","text":"ok"}],"stop_reason":"end_turn","usage":{"input_tokens":3,"output_tokens":1}}`))
+	})
+
+	for i := 0; i < 2; i++ {
+		result, err := ask(context.Background(), "claude", completionRequest{Messages: userMessage("hi")}, continueOptions{})
+		if err != nil || result.Text != "ok" {
+			t.Fatalf("Expected the call to fail over, got %v", err)
+		}
+	}
+	if strings.Join(used, ",") != "limited-key-aaaa,healthy-key-bbbb,healthy-key-bbbb" {
+		t.Errorf("Expected the benched key to be skipped, got %v", used)
+	}
+
+	usage := keyPools.usage()["claude"]
+	if len(usage) != 2 || usage[0].Key != "...aaaa" || usage[0].Errors != 1 || usage[0].BenchedUntil == nil {
+		t.Errorf("Expected the limited key to be benched with one error, got %+v", usage)
+	}
+	if usage[1].Requests != 2 || usage[1].InputTokens != 6 || usage[1].BenchedUntil != nil {
+		t.Errorf("Expected the healthy key to carry both calls, got %+v", usage[1])
+	}
+}
+
+// Test a call gives up after one pass over the keys even when benches expire at once
+func TestCompleteTriesEachKeyOnce(t *testing.T) {
+	useKeyPools(t)
+	t.Setenv("CLAUDE_API_KEY", "")
+	t.Setenv("CLAUDE_API_KEYS", "limited-key-aaaa,limited-key-bbbb")
+	t.Setenv("KEY_BENCH_DURATION", "1ns")
+
+	requests := 0
+	useProviderURL(t, &claudeMessagesURL, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error":{"message":"rate limited"}}`))
+	})
+
+	if _, err := ask(context.Background(), "claude", completionRequest{Messages: userMessage("hi")}, continueOptions{}); err == nil || !strings.Contains(err.Error(), "rate limited") {
+		t.Errorf("Expected the last rate limit error, got %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected one request per key, got %d", requests)
+	}
+}
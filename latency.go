@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyWindow is how many recent successful calls are kept per provider
+const latencyWindow = 100
+
+// latencyTracker keeps a rolling window of call latencies per provider so
+// routing can prefer the fast ones
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples map[string][]time.Duration
+	next    map[string]int
+}
+
+var providerLatency = newLatencyTracker()
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{samples: map[string][]time.Duration{}, next: map[string]int{}}
+}
+
+// observe records one successful call, replacing the oldest sample once the window is full
+func (l *latencyTracker) observe(provider string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.samples[provider]) < latencyWindow {
+		l.samples[provider] = append(l.samples[provider], d)
+		return
+	}
+	l.samples[provider][l.next[provider]] = d
+	l.next[provider] = (l.next[provider] + 1) % latencyWindow
+}
+
+// percentile returns the p-th percentile (0-100) of the provider's recent
+// latencies; ok is false when nothing has been observed yet
+func (l *latencyTracker) percentile(provider string, p float64) (time.Duration, bool) {
+	l.mu.Lock()
+	sorted := append([]time.Duration(nil), l.samples[provider]...)
+	l.mu.Unlock()
+
+	if len(sorted) == 0 {
+		return 0, false
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(p / 100 * float64(len(sorted)-1))
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i], true
+}
+
+// count returns how many latencies are in the provider's window
+func (l *latencyTracker) count(provider string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.samples[provider])
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// defaultDrainTimeout is how long shutdown waits for in-flight tool calls;
+// MCP_DRAIN_TIMEOUT overrides it
+const defaultDrainTimeout = 10 * time.Second
+
+// Process exit codes
+const (
+	exitOK             = 0
+	exitStartupFailure = 1
+	exitCommandFailure = 1 // a command such as export failed
+)
+
+// errShuttingDown is returned to tool calls that arrive after shutdown started
+var errShuttingDown = errors.New("server is shutting down; not accepting new calls")
+
+// lifecycle tracks in-flight tool calls so shutdown can stop accepting new ones,
+// drain the rest and then run the registered flush hooks
+type lifecycle struct {
+	mu       sync.Mutex
+	draining bool
+	inflight sync.WaitGroup
+	hooks    []shutdownHook
+
+	// ctx is cancelled when the drain period runs out, aborting provider requests
+	// that are still in flight
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type shutdownHook struct {
+	name string
+	fn   func() error
+}
+
+var serverLifecycle = newLifecycle()
+
+func newLifecycle() *lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &lifecycle{ctx: ctx, cancel: cancel}
+}
+
+// begin registers a call, or refuses it once shutdown has started
+func (l *lifecycle) begin() (done func(), err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.draining {
+		return nil, errShuttingDown
+	}
+	l.inflight.Add(1)
+	return l.inflight.Done, nil
+}
+
+// onShutdown registers fn to run after in-flight calls have drained, in
+// registration order
+func (l *lifecycle) onShutdown(name string, fn func() error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hooks = append(l.hooks, shutdownHook{name: name, fn: fn})
+}
+
+// shutdown stops accepting calls, waits up to drain for in-flight ones, cancels
+// whatever is left and runs the shutdown hooks. It reports whether every call
+// finished in time.
+func (l *lifecycle) shutdown(drain time.Duration) bool {
+	l.mu.Lock()
+	l.draining = true
+	hooks := append([]shutdownHook(nil), l.hooks...)
+	l.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		l.inflight.Wait()
+		close(finished)
+	}()
+
+	drained := true
+	select {
+	case <-finished:
+	case <-time.After(drain):
+		drained = false
+		log.Printf("drain period of %s expired; cancelling in-flight calls", drain)
+		l.cancel()
+		// Give cancelled requests a moment to unwind before flushing state
+		select {
+		case <-finished:
+		case <-time.After(time.Second):
+		}
+	}
+	l.cancel()
+
+	for _, hook := range hooks {
+		if err := hook.fn(); err != nil {
+			log.Printf("shutdown: %s: %v", hook.name, err)
+		}
+	}
+	return drained
+}
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// track wraps a tool handler so the call is counted as in flight and refused
+// during shutdown. Handlers that take a context get one that is also cancelled
+// when the drain period expires. The wrapper keeps the handler's signature, which
+// mcp-golang reflects on to build the tool's input schema.
+func (l *lifecycle) track(handler any) any {
+	v := reflect.ValueOf(handler)
+	t := v.Type()
+	return reflect.MakeFunc(t, func(args []reflect.Value) []reflect.Value {
+		done, err := l.begin()
+		if err != nil {
+			return []reflect.Value{reflect.Zero(t.Out(0)), reflect.ValueOf(&err).Elem()}
+		}
+		defer done()
+
+		if t.NumIn() == 2 && t.In(0) == contextType {
+			ctx, cancel := context.WithCancel(args[0].Interface().(context.Context))
+			defer cancel()
+			stop := context.AfterFunc(l.ctx, cancel)
+			defer stop()
+			args[0] = reflect.ValueOf(ctx)
+		}
+		return v.Call(args)
+	}).Interface()
+}
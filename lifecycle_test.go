@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	mcp_golang "github.com/metoro-io/mcp-golang"
+)
+
+// Test calls are refused once shutdown has started and hooks run after the drain
+func TestLifecycleRefusesCallsAfterShutdown(t *testing.T) {
+	l := newLifecycle()
+
+	flushed := false
+	l.onShutdown("flush", func() error {
+		flushed = true
+		return nil
+	})
+
+	handler := l.track(func(args MyFunctionsArguments) (*mcp_golang.ToolResponse, error) {
+		return newTextResponse("ok"), nil
+	}).(func(MyFunctionsArguments) (*mcp_golang.ToolResponse, error))
+
+	if _, err := handler(MyFunctionsArguments{}); err != nil {
+		t.Fatalf("Unexpected error before shutdown: %v", err)
+	}
+
+	if !l.shutdown(time.Second) {
+		t.Errorf("Expected an idle server to drain in time")
+	}
+	if !flushed {
+		t.Errorf("Expected the shutdown hook to run")
+	}
+
+	if _, err := handler(MyFunctionsArguments{}); !errors.Is(err, errShuttingDown) {
+		t.Errorf("Expected errShuttingDown, got %v", err)
+	}
+}
+
+// Test shutdown waits for in-flight calls and cancels them when the drain period expires
+func TestLifecycleDrainCancelsSlowCalls(t *testing.T) {
+	l := newLifecycle()
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	handler := l.track(func(ctx context.Context, args ClaudeArguments) (*mcp_golang.ToolResponse, error) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	}).(func(context.Context, ClaudeArguments) (*mcp_golang.ToolResponse, error))
+
+	go handler(context.Background(), ClaudeArguments{})
+	<-started
+
+	if l.shutdown(50 * time.Millisecond) {
+		t.Errorf("Expected the drain period to expire")
+	}
+
+	select {
+	case <-cancelled:
+	default:
+		t.Errorf("Expected the in-flight call to be cancelled")
+	}
+}
@@ -1,15 +1,19 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	mcp_golang "github.com/metoro-io/mcp-golang"
@@ -19,29 +23,50 @@ import (
 
 const cacheTime = 500
 
+// Default models and answer budget for the ask_* tools
+const (
+	defaultClaudeModel  = "claude-3-haiku-20240307"
+	defaultOpenAIModel  = "gpt-3.5-turbo"
+	defaultMistralModel = "mistral-tiny"
+	defaultMaxTokens    = 1000
+)
+
+// Provider endpoints; variables so tests can point them at a mock server
+var (
+	claudeMessagesURL = "https://api.anthropic.com/v1/messages"
+	openAIChatURL     = "https://api.openai.com/v1/chat/completions"
+	mistralChatURL    = "https://api.mistral.ai/v1/chat/completions"
+)
+
 type MyFunctionsArguments struct {
 	ZipCode string `json:"zip_code" jsonschema:"required,description=The zip code to be searched"`
 }
 
 type ClaudeArguments struct {
-	Question string `json:"question" jsonschema:"required,description=The question to ask Claude AI"`
+	Question         string  `json:"question" jsonschema:"required,description=The question to ask Claude AI"`
+	AutoContinue     bool    `json:"auto_continue" jsonschema:"description=Keep asking for more when the answer is cut off at the token limit and stitch the parts together"`
+	MaxContinuations int     `json:"max_continuations" jsonschema:"description=Maximum continuation turns with auto_continue (default: 3\\, max: 10)"`
+	SessionID        string  `json:"session_id" jsonschema:"description=History session to record this exchange under (default: this server run's session)"`
+	HedgeWith        string  `json:"hedge_with" jsonschema:"description=Backup provider to also ask if this one is slower than usual; the first good answer wins"`
+	HedgePercentile  float64 `json:"hedge_percentile" jsonschema:"description=Latency percentile of recent calls after which the backup is asked (default: HEDGE_PERCENTILE or 95)"`
 }
 
 type OpenAIArguments struct {
-	Question string `json:"question" jsonschema:"required,description=The question to ask OpenAI GPT"`
-}
-
-type GeminiArguments struct {
-	Question string `json:"question" jsonschema:"required,description=The question to ask Google Gemini"`
-	Model    string `json:"model" jsonschema:"description=Gemini model to use: pro, flash, flash-lite (default: flash)"`
+	Question         string  `json:"question" jsonschema:"required,description=The question to ask OpenAI GPT"`
+	AutoContinue     bool    `json:"auto_continue" jsonschema:"description=Keep asking for more when the answer is cut off at the token limit and stitch the parts together"`
+	MaxContinuations int     `json:"max_continuations" jsonschema:"description=Maximum continuation turns with auto_continue (default: 3\\, max: 10)"`
+	SessionID        string  `json:"session_id" jsonschema:"description=History session to record this exchange under (default: this server run's session)"`
+	HedgeWith        string  `json:"hedge_with" jsonschema:"description=Backup provider to also ask if this one is slower than usual; the first good answer wins"`
+	HedgePercentile  float64 `json:"hedge_percentile" jsonschema:"description=Latency percentile of recent calls after which the backup is asked (default: HEDGE_PERCENTILE or 95)"`
 }
 
 type MistralArguments struct {
-	Question string `json:"question" jsonschema:"required,description=The question to ask Mistral AI"`
-}
-
-type HuggingFaceArguments struct {
-	Question string `json:"question" jsonschema:"required,description=The question to ask Hugging Face models"`
+	Question         string  `json:"question" jsonschema:"required,description=The question to ask Mistral AI"`
+	AutoContinue     bool    `json:"auto_continue" jsonschema:"description=Keep asking for more when the answer is cut off at the token limit and stitch the parts together"`
+	MaxContinuations int     `json:"max_continuations" jsonschema:"description=Maximum continuation turns with auto_continue (default: 3\\, max: 10)"`
+	SessionID        string  `json:"session_id" jsonschema:"description=History session to record this exchange under (default: this server run's session)"`
+	HedgeWith        string  `json:"hedge_with" jsonschema:"description=Backup provider to also ask if this one is slower than usual; the first good answer wins"`
+	HedgePercentile  float64 `json:"hedge_percentile" jsonschema:"description=Latency percentile of recent calls after which the backup is asked (default: HEDGE_PERCENTILE or 95)"`
 }
 
 type ClaudeRequest struct {
@@ -80,30 +105,17 @@ type OpenAIRequest struct {
 }
 
 type OpenAIResponse struct {
+	Model   string `json:"model"`
 	Choices []struct {
 		Message struct {
 			Content string `json:"content"`
 		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
-}
-
-// Gemini types
-type GeminiRequest struct {
-	Contents []struct {
-		Parts []struct {
-			Text string `json:"text"`
-		} `json:"parts"`
-	} `json:"contents"`
-}
-
-type GeminiResponse struct {
-	Candidates []struct {
-		Content struct {
-			Parts []struct {
-				Text string `json:"text"`
-			} `json:"parts"`
-		} `json:"content"`
-	} `json:"candidates"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
 }
 
 // Mistral types
@@ -113,22 +125,8 @@ type MistralRequest struct {
 	MaxTokens int       `json:"max_tokens,omitempty"`
 }
 
-type MistralResponse struct {
-	Choices []struct {
-		Message struct {
-			Content string `json:"content"`
-		} `json:"message"`
-	} `json:"choices"`
-}
-
-// Hugging Face types
-type HuggingFaceRequest struct {
-	Inputs string `json:"inputs"`
-}
-
-type HuggingFaceResponse []struct {
-	GeneratedText string `json:"generated_text"`
-}
+// Mistral answers in the OpenAI format
+type MistralResponse = OpenAIResponse
 
 // Cep is the brazilian postal code and address information
 type Cep struct {
@@ -143,394 +141,516 @@ type Cep struct {
 	Gia         string `json:"gia"`
 }
 
-// loadEnv loads environment variables from .env file (fails silently if file doesn't exist)
-func loadEnv() {
-	file, err := os.Open(".env")
-	if err != nil {
-		// Silently ignore if .env file doesn't exist (normal in Docker)
-		return
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) == 2 {
-			key := strings.TrimSpace(parts[0])
-			value := strings.TrimSpace(parts[1])
-			// Remove trailing semicolon if present
-			value = strings.TrimSuffix(value, ";")
-			os.Setenv(key, value)
-		}
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		loadEnv()
+		os.Exit(runExport(os.Args[2:], os.Stdout, os.Stderr))
 	}
+	os.Exit(run())
 }
 
-func main() {
+// run starts the server and blocks until SIGINT or SIGTERM. On a signal it stops
+// accepting tool calls, drains the in-flight ones for MCP_DRAIN_TIMEOUT, flushes
+// state and returns the process exit code.
+func run() int {
 	// Try to load .env file for local development
 	// This will fail silently in Docker, which is fine since
 	// environment variables are passed via docker-compose
 	loadEnv()
 
-	server := mcp_golang.NewServer(stdio.NewStdioServerTransport()) // Register zipcode tool
-	err := server.RegisterTool("zipcode", "Find an address by his zip code", func(arguments MyFunctionsArguments) (*mcp_golang.ToolResponse, error) {
+	// Everything logged goes to stderr (stdout carries the MCP protocol) and is
+	// scrubbed of API keys on the way out
+	log.SetOutput(&redactingWriter{w: os.Stderr})
+
+	usageStats = newUsageTracker(filepath.Join(stateDir(), "usage.json"))
+	if err := usageStats.load(); err != nil {
+		log.Printf("usage: could not load %s, starting from zero: %v", usageStats.path, err)
+	}
+	serverLifecycle.onShutdown("flush usage", usageStats.flush)
+
+	if err := conversationHistory.open(historyPath()); err != nil {
+		log.Printf("history: could not open %s, not recording this run: %v", conversationHistory.path, err)
+	}
+
+	if err := backgroundJobs.open(jobsPath()); err != nil {
+		log.Printf("jobs: could not load %s, starting empty: %v", jobsPath(), err)
+	}
+	serverLifecycle.onShutdown("save jobs", backgroundJobs.stop)
+	// Closed after the jobs stop, since a stopping job may still record its exchange
+	serverLifecycle.onShutdown("close history", conversationHistory.close)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	server := mcp_golang.NewServer(stdio.NewStdioServerTransport())
+	if err := registerTools(server); err != nil {
+		log.Printf("startup failed: %v", err)
+		return exitStartupFailure
+	}
+
+	backgroundJobs.resume()
+
+	if err := registerResources(server); err != nil {
+		log.Printf("startup failed: registering resources: %v", err)
+		return exitStartupFailure
+	}
+
+	prompts := newPromptLibrary(promptsDir(), server)
+	if err := prompts.reload(); err != nil {
+		log.Printf("startup failed: loading prompts: %v", err)
+		return exitStartupFailure
+	}
+	log.Printf("loaded %d prompts from %s", len(prompts.names()), prompts.dir)
+
+	if err := server.Serve(); err != nil {
+		log.Printf("startup failed: %v", err)
+		return exitStartupFailure
+	}
+	go prompts.watch(serverLifecycle.ctx, durationFromEnv("PROMPTS_RELOAD_INTERVAL", defaultPromptsReloadInterval))
+
+	// Keep the server running until we're told to stop
+	<-ctx.Done()
+	stop()
+
+	drain := durationFromEnv("MCP_DRAIN_TIMEOUT", defaultDrainTimeout)
+	log.Printf("shutting down: waiting up to %s for in-flight calls", drain)
+	if serverLifecycle.shutdown(drain) {
+		log.Printf("shutdown complete")
+	}
+	return exitOK
+}
+
+// registerTools registers every tool through the middleware chain, which also
+// lets shutdown drain them
+func registerTools(server *mcp_golang.Server) error {
+	tools := newToolRegistry(server, defaultMiddlewares()...)
+
+	// Register zipcode tool
+	err := tools.register("zipcode", "Find an address by his zip code", func(arguments MyFunctionsArguments) (*mcp_golang.ToolResponse, error) {
 		address, err := getCep(arguments.ZipCode)
 		if err != nil {
-			return nil, err
+			return nil, redactError(err)
 		}
 
-		return mcp_golang.NewToolResponse(mcp_golang.NewTextContent(fmt.Sprintf("Your address is %s!", address))), nil
+		return newTextResponse(fmt.Sprintf("Your address is %s!", address)), nil
 	})
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	// Register Claude AI tool
-	err = server.RegisterTool("ask_claude", "Ask a question to Claude AI", func(arguments ClaudeArguments) (*mcp_golang.ToolResponse, error) {
-		answer, err := askClaude(arguments.Question)
+	err = tools.register("ask_claude", "Ask a question to Claude AI", func(ctx context.Context, arguments ClaudeArguments) (*mcp_golang.ToolResponse, error) {
+		req := completionRequest{Messages: userMessage(arguments.Question)}
+		hedge := hedgeOptions{Backup: arguments.HedgeWith, Percentile: arguments.HedgePercentile}
+		answer, report, err := askHedged(ctx, arguments.SessionID, "claude", req, continueOptions{Auto: arguments.AutoContinue, MaxContinuations: arguments.MaxContinuations}, hedge)
 		if err != nil {
-			return nil, err
+			return nil, redactError(err)
 		}
 
-		return mcp_golang.NewToolResponse(mcp_golang.NewTextContent(fmt.Sprintf("Claude says: %s", answer))), nil
+		return newTextResponse(hedgedAnswer(answer, report)), nil
 	})
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	// Register OpenAI GPT tool
-	err = server.RegisterTool("ask_openai", "Ask a question to OpenAI GPT", func(arguments OpenAIArguments) (*mcp_golang.ToolResponse, error) {
-		answer, err := askOpenAI(arguments.Question)
+	err = tools.register("ask_openai", "Ask a question to OpenAI GPT", func(ctx context.Context, arguments OpenAIArguments) (*mcp_golang.ToolResponse, error) {
+		req := completionRequest{Messages: userMessage(arguments.Question)}
+		hedge := hedgeOptions{Backup: arguments.HedgeWith, Percentile: arguments.HedgePercentile}
+		answer, report, err := askHedged(ctx, arguments.SessionID, "openai", req, continueOptions{Auto: arguments.AutoContinue, MaxContinuations: arguments.MaxContinuations}, hedge)
 		if err != nil {
-			return nil, err
+			return nil, redactError(err)
 		}
 
-		return mcp_golang.NewToolResponse(mcp_golang.NewTextContent(fmt.Sprintf("OpenAI says: %s", answer))), nil
+		return newTextResponse(hedgedAnswer(answer, report)), nil
 	})
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	// Register Gemini tool
-	err = server.RegisterTool("ask_gemini", "Ask a question to Google Gemini", func(arguments GeminiArguments) (*mcp_golang.ToolResponse, error) {
-		answer, err := askGemini(arguments.Question)
+	err = tools.register("ask_gemini", "Ask a question to Google Gemini", func(ctx context.Context, arguments GeminiArguments) (*mcp_golang.ToolResponse, error) {
+		safetySettings, err := geminiSafetySettings(arguments.SafetyThreshold)
 		if err != nil {
 			return nil, err
 		}
 
-		return mcp_golang.NewToolResponse(mcp_golang.NewTextContent(fmt.Sprintf("Gemini says: %s", answer))), nil
+		req := completionRequest{Model: arguments.Model, Messages: userMessage(arguments.Question), SafetySettings: safetySettings}
+		hedge := hedgeOptions{Backup: arguments.HedgeWith, Percentile: arguments.HedgePercentile}
+		answer, report, err := askHedged(ctx, arguments.SessionID, "gemini", req, continueOptions{Auto: arguments.AutoContinue, MaxContinuations: arguments.MaxContinuations}, hedge)
+		if err != nil {
+			return nil, redactError(err)
+		}
+
+		return newTextResponse(hedgedAnswer(answer, report)), nil
 	})
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	// Register Mistral tool
-	err = server.RegisterTool("ask_mistral", "Ask a question to Mistral AI", func(arguments MistralArguments) (*mcp_golang.ToolResponse, error) {
-		answer, err := askMistral(arguments.Question)
+	err = tools.register("ask_mistral", "Ask a question to Mistral AI", func(ctx context.Context, arguments MistralArguments) (*mcp_golang.ToolResponse, error) {
+		req := completionRequest{Messages: userMessage(arguments.Question)}
+		hedge := hedgeOptions{Backup: arguments.HedgeWith, Percentile: arguments.HedgePercentile}
+		answer, report, err := askHedged(ctx, arguments.SessionID, "mistral", req, continueOptions{Auto: arguments.AutoContinue, MaxContinuations: arguments.MaxContinuations}, hedge)
 		if err != nil {
-			return nil, err
+			return nil, redactError(err)
 		}
 
-		return mcp_golang.NewToolResponse(mcp_golang.NewTextContent(fmt.Sprintf("Mistral says: %s", answer))), nil
+		return newTextResponse(hedgedAnswer(answer, report)), nil
 	})
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	// Register Hugging Face tool
-	err = server.RegisterTool("ask_huggingface", "Ask a question to Hugging Face models", func(arguments HuggingFaceArguments) (*mcp_golang.ToolResponse, error) {
-		answer, err := askHuggingFace(arguments.Question)
+	err = tools.register("ask_huggingface", "Ask a question to Hugging Face models (chat or a classic inference task)", func(ctx context.Context, arguments HuggingFaceArguments) (*mcp_golang.ToolResponse, error) {
+		req := completionRequest{Model: arguments.Model, Messages: userMessage(arguments.Question), Task: arguments.Task, WaitForModel: arguments.WaitForModel}
+		hedge := hedgeOptions{Backup: arguments.HedgeWith, Percentile: arguments.HedgePercentile}
+		answer, report, err := askHedged(ctx, arguments.SessionID, "huggingface", req, continueOptions{Auto: arguments.AutoContinue, MaxContinuations: arguments.MaxContinuations}, hedge)
 		if err != nil {
-			return nil, err
+			return nil, redactError(err)
 		}
 
-		return mcp_golang.NewToolResponse(mcp_golang.NewTextContent(fmt.Sprintf("Hugging Face says: %s", answer))), nil
+		return newTextResponse(hedgedAnswer(answer, report)), nil
 	})
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	err = server.Serve()
+	// Register model listing tool
+	err = tools.register("list_models", "List the models each configured AI provider offers, with context window and capabilities where available", func(arguments ListModelsArguments) (*mcp_golang.ToolResponse, error) {
+		result, err := listModels(strings.ToLower(strings.TrimSpace(arguments.Provider)), arguments.Refresh)
+		if err != nil {
+			return nil, redactError(err)
+		}
+
+		out, err := json.MarshalIndent(map[string]interface{}{"providers": result}, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		return newTextResponse(string(out)), nil
+	})
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	// Keep the server running
-	select {}
-}
+	// Register provider status tool
+	err = tools.register("provider_status", "Check each AI provider's credentials with a cheap live call and report ok, missing_key, invalid_key, quota_exceeded or unreachable with latency and circuit breaker state", func(arguments ProviderStatusArguments) (*mcp_golang.ToolResponse, error) {
+		result, err := providerStatuses(strings.ToLower(strings.TrimSpace(arguments.Provider)), arguments.Refresh)
+		if err != nil {
+			return nil, redactError(err)
+		}
 
-func getCep(id string) (string, error) {
-	cached := getFromCache(id)
-	if cached != "" {
-		return cached, nil
-	}
-	req, err := http.Get(fmt.Sprintf("http://viacep.com.br/ws/%s/json/", id))
+		out, err := json.MarshalIndent(map[string]interface{}{"providers": result}, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		return newTextResponse(string(out)), nil
+	})
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	var c Cep
-	err = json.NewDecoder(req.Body).Decode(&c)
+	// Register batch tool
+	err = tools.register("ask_batch", "Run many prompts (or a template over rows of variables) against one provider with bounded concurrency; failures are reported per item", func(ctx context.Context, arguments AskBatchArguments) (*mcp_golang.ToolResponse, error) {
+		result, err := askBatch(ctx, arguments)
+		if err != nil {
+			return nil, redactError(err)
+		}
+
+		out, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		return newTextResponse(string(out)), nil
+	})
 	if err != nil {
-		return "", err
+		return err
 	}
-	res, err := json.Marshal(c)
+
+	// Register summarize tool
+	err = tools.register("summarize", "Summarize text or a file of any length: splits it into overlapping chunks, summarizes them in parallel and merges the results (style: bullets, abstract or tldr)", func(ctx context.Context, arguments SummarizeArguments) (*mcp_golang.ToolResponse, error) {
+		result, err := summarizeDocument(ctx, arguments)
+		if err != nil {
+			return nil, redactError(err)
+		}
+
+		return newTextResponse(result.String()), nil
+	})
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	return saveOnCache(id, string(res)), nil
-}
+	// Register count_tokens tool
+	err = tools.register("count_tokens", "Count the tokens of a text for each provider's model, using the provider's counting endpoint where there is one and a local estimate otherwise, and check it fits the context window", func(ctx context.Context, arguments CountTokensArguments) (*mcp_golang.ToolResponse, error) {
+		result, err := countTokens(ctx, arguments)
+		if err != nil {
+			return nil, redactError(err)
+		}
 
-func askClaude(question string) (string, error) {
-	apiKey := os.Getenv("CLAUDE_API_KEY")
-	if apiKey == "" {
-		return "", errors.New("CLAUDE_API_KEY not found in environment")
-	}
+		out, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return nil, err
+		}
 
-	requestBody := ClaudeRequest{
-		Model:     "claude-3-haiku-20240307",
-		MaxTokens: 1000,
-		Messages: []Message{
-			{
-				Role:    "user",
-				Content: question,
-			},
-		},
+		return newTextResponse(string(out)), nil
+	})
+	if err != nil {
+		return err
 	}
 
-	jsonData, err := json.Marshal(requestBody)
+	// Register translate tool
+	err = tools.register("translate", "Translate text to a target language, detecting the source language, keeping markdown, code and URLs intact and applying a glossary of fixed terms; optionally back-translate with a second provider to check fidelity", func(ctx context.Context, arguments TranslateArguments) (*mcp_golang.ToolResponse, error) {
+		result, err := translate(ctx, arguments)
+		if err != nil {
+			return nil, redactError(err)
+		}
+
+		return newTextResponse(result.String()), nil
+	})
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	req, err := http.NewRequest("POST", "https://api.anthropic.com/v1/messages", bytes.NewBuffer(jsonData))
+	// Register ask_best tool
+	err = tools.register("ask_best", "Answer a question with the provider best suited to it: the question is classified (code, math, creative, Portuguese, long-context or general) and routed by configurable rules weighing cost, latency and health; the chosen route and why are reported", func(ctx context.Context, arguments AskBestArguments) (*mcp_golang.ToolResponse, error) {
+		result, decision, err := askBest(ctx, arguments)
+		if err != nil {
+			return nil, redactError(err)
+		}
+		if result == nil {
+			return newTextResponse(decision.String()), nil
+		}
+
+		return newTextResponse(result.String() + "\n\n" + decision.String()), nil
+	})
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("x-api-key", apiKey)
-	req.Header.Set("anthropic-version", "2023-06-01")
+	// Register history search tool
+	err = tools.register("history_search", "Search the recorded ask_* questions and answers by words, provider, session and date, newest first; history is kept across restarts", func(arguments HistorySearchArguments) (*mcp_golang.ToolResponse, error) {
+		result, err := searchHistory(arguments)
+		if err != nil {
+			return nil, redactError(err)
+		}
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+		out, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		return newTextResponse(string(out)), nil
+	})
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("Claude API error %d: %s", resp.StatusCode, string(body))
-	}
+	// Register rate answer tool
+	err = tools.register("rate_answer", "Grade a recorded exchange from 1 to 5 so it can be picked for datasets with export_dataset min_rating", func(arguments RateAnswerArguments) (*mcp_golang.ToolResponse, error) {
+		entry, err := conversationHistory.rate(arguments.ID, arguments.Rating)
+		if err != nil {
+			return nil, redactError(err)
+		}
 
-	var claudeResp ClaudeResponse
-	err = json.NewDecoder(resp.Body).Decode(&claudeResp)
+		return newTextResponse(fmt.Sprintf("rated %s (%s, session %s): %d", entry.ID, entry.Provider, entry.SessionID, entry.Rating)), nil
+	})
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	if len(claudeResp.Content) > 0 {
-		return claudeResp.Content[0].Text, nil
-	}
+	// Register export dataset tool
+	err = tools.register("export_dataset", "Export recorded exchanges as JSONL for fine-tuning or evals (OpenAI chat, Anthropic messages, Gemini contents or generic prompt/completion), filtered by provider, session, date and rating, with optional PII scrubbing", func(arguments ExportDatasetArguments) (*mcp_golang.ToolResponse, error) {
+		result, err := exportDataset(conversationHistory, arguments)
+		if err != nil {
+			return nil, redactError(err)
+		}
 
-	return "", errors.New("no response from Claude")
-}
+		return newTextResponse(result.String()), nil
+	})
+	if err != nil {
+		return err
+	}
 
-func askOpenAI(question string) (string, error) {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		return "", errors.New("OPENAI_API_KEY not found in environment")
+	// Register the background job tools; jobs run the other tools through the registry
+	backgroundJobs.run, backgroundJobs.has = tools.call, tools.has
+	if err := registerJobTools(tools, backgroundJobs); err != nil {
+		return err
 	}
 
-	requestBody := OpenAIRequest{
-		Model: "gpt-3.5-turbo",
-		Messages: []Message{
-			{
-				Role:    "user",
-				Content: question,
-			},
-		},
-		MaxTokens: 1000,
+	// Register the tools of the executables in the plugins directory
+	if err := registerPlugins(tools, pluginsDir()); err != nil {
+		return fmt.Errorf("loading plugins: %w", err)
 	}
 
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", err
+	// Register the operations of the REST services described by OpenAPI specs
+	if err := registerOpenAPIServices(tools, os.Getenv("OPENAPI_SERVICES")); err != nil {
+		return err
 	}
+	return nil
+}
 
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", err
+// hedgedAnswer renders an ask_* answer under the name of the provider that gave
+// it, followed by how the hedge went when hedging was on
+func hedgedAnswer(answer *completion, report *hedgeReport) string {
+	text := fmt.Sprintf("%s says: %s", providers[answer.Provider].Name, answer)
+	if report != nil {
+		text += "\n" + report.String()
 	}
+	return text
+}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+// newTextResponse wraps text in a tool response, scrubbing anything that looks like a secret
+func newTextResponse(text string) *mcp_golang.ToolResponse {
+	return mcp_golang.NewToolResponse(mcp_golang.NewTextContent(redactSecrets(text)))
+}
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+func getCep(id string) (string, error) {
+	cached := getFromCache(id)
+	if cached != "" {
+		return cached, nil
+	}
+	req, err := http.Get(fmt.Sprintf("http://viacep.com.br/ws/%s/json/", id))
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("OpenAI API error %d: %s", resp.StatusCode, string(body))
+	var c Cep
+	err = json.NewDecoder(req.Body).Decode(&c)
+	if err != nil {
+		return "", err
 	}
-
-	var openaiResp OpenAIResponse
-	err = json.NewDecoder(resp.Body).Decode(&openaiResp)
+	res, err := json.Marshal(c)
 	if err != nil {
 		return "", err
 	}
 
-	if len(openaiResp.Choices) > 0 {
-		return openaiResp.Choices[0].Message.Content, nil
+	saved := saveOnCache(id, string(res))
+	if saved != "" && onCepCached != nil {
+		onCepCached(strings.Replace(id, "-", "", -1))
 	}
-
-	return "", errors.New("no response from OpenAI")
+	return saved, nil
 }
 
-func askGemini(question string) (string, error) {
-	apiKey := os.Getenv("GEMINI_API_KEY")
+func askClaude(ctx context.Context, req completionRequest) (*completion, error) {
+	apiKey := providerKey(ctx, "CLAUDE_API_KEY")
 	if apiKey == "" {
-		return "", errors.New("GEMINI_API_KEY not found in environment")
+		return nil, errors.New("CLAUDE_API_KEY not found in environment")
 	}
 
-	requestBody := GeminiRequest{
-		Contents: []struct {
-			Parts []struct {
-				Text string `json:"text"`
-			} `json:"parts"`
-		}{
-			{
-				Parts: []struct {
-					Text string `json:"text"`
-				}{
-					{Text: question},
-				},
-			},
-		},
+	requestBody := ClaudeRequest{
+		Model:     defaultString(req.Model, defaultClaudeModel),
+		MaxTokens: defaultInt(req.MaxTokens, defaultMaxTokens),
+		Messages:  req.Messages,
 	}
 
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/gemini-2.5-flash:generateContent?key=%s", apiKey)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", claudeMessagesURL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", apiKey)
+	httpReq.Header.Set("anthropic-version", "2023-06-01")
 
 	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := client.Do(httpReq)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("Gemini API error %d: %s", resp.StatusCode, string(body))
+		return nil, upstreamError("Claude", resp.StatusCode, body)
 	}
 
-	var geminiResp GeminiResponse
-	err = json.NewDecoder(resp.Body).Decode(&geminiResp)
+	var claudeResp ClaudeResponse
+	err = json.NewDecoder(resp.Body).Decode(&claudeResp)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	if len(geminiResp.Candidates) > 0 && len(geminiResp.Candidates[0].Content.Parts) > 0 {
-		return geminiResp.Candidates[0].Content.Parts[0].Text, nil
+	var text strings.Builder
+	for _, block := range claudeResp.Content {
+		if block.Type == "text" || block.Type == "" {
+			text.WriteString(block.Text)
+		}
+	}
+	if text.Len() == 0 && claudeResp.StopReason != "max_tokens" {
+		return nil, errors.New("no response from Claude")
 	}
 
-	return "", errors.New("no response from Gemini")
+	return &completion{
+		Model:        claudeResp.Model,
+		Text:         text.String(),
+		FinishReason: claudeResp.StopReason,
+		Truncated:    claudeResp.StopReason == "max_tokens",
+		InputTokens:  claudeResp.Usage.InputTokens,
+		OutputTokens: claudeResp.Usage.OutputTokens,
+	}, nil
 }
 
-func askMistral(question string) (string, error) {
-	apiKey := os.Getenv("MISTRAL_API_KEY")
+func askOpenAI(ctx context.Context, req completionRequest) (*completion, error) {
+	apiKey := providerKey(ctx, "OPENAI_API_KEY")
 	if apiKey == "" {
-		return "", errors.New("MISTRAL_API_KEY not found in environment")
+		return nil, errors.New("OPENAI_API_KEY not found in environment")
 	}
 
-	requestBody := MistralRequest{
-		Model: "mistral-tiny",
-		Messages: []Message{
-			{
-				Role:    "user",
-				Content: question,
-			},
-		},
-		MaxTokens: 1000,
-	}
-
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", err
+	requestBody := OpenAIRequest{
+		Model:     defaultString(req.Model, defaultOpenAIModel),
+		Messages:  req.Messages,
+		MaxTokens: defaultInt(req.MaxTokens, defaultMaxTokens),
 	}
 
-	req, err := http.NewRequest("POST", "https://api.mistral.ai/v1/chat/completions", bytes.NewBuffer(jsonData))
+	openaiResp, err := postChatCompletion(ctx, "OpenAI", openAIChatURL, apiKey, requestBody)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+	return openAIStyleCompletion("OpenAI", requestBody.Model, openaiResp)
+}
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
+func askMistral(ctx context.Context, req completionRequest) (*completion, error) {
+	apiKey := providerKey(ctx, "MISTRAL_API_KEY")
+	if apiKey == "" {
+		return nil, errors.New("MISTRAL_API_KEY not found in environment")
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("Mistral API error %d: %s", resp.StatusCode, string(body))
+	requestBody := MistralRequest{
+		Model:     defaultString(req.Model, defaultMistralModel),
+		Messages:  req.Messages,
+		MaxTokens: defaultInt(req.MaxTokens, defaultMaxTokens),
 	}
 
-	var mistralResp MistralResponse
-	err = json.NewDecoder(resp.Body).Decode(&mistralResp)
+	mistralResp, err := postChatCompletion(ctx, "Mistral", mistralChatURL, apiKey, OpenAIRequest(requestBody))
 	if err != nil {
-		return "", err
-	}
-
-	if len(mistralResp.Choices) > 0 {
-		return mistralResp.Choices[0].Message.Content, nil
+		return nil, err
 	}
 
-	return "", errors.New("no response from Mistral")
+	return openAIStyleCompletion("Mistral", requestBody.Model, mistralResp)
 }
 
-func askHuggingFace(question string) (string, error) {
-	apiKey := os.Getenv("HUGGINGFACEHUB_API_TOKEN")
-	if apiKey == "" {
-		return "", errors.New("HUGGINGFACEHUB_API_TOKEN not found in environment")
-	}
-
-	requestBody := HuggingFaceRequest{
-		Inputs: question,
-	}
-
+// postChatCompletion sends an OpenAI-compatible chat completions request; OpenAI,
+// Mistral and the Hugging Face router all speak this format
+func postChatCompletion(ctx context.Context, providerName, url, apiKey string, requestBody OpenAIRequest) (*OpenAIResponse, error) {
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// Using a popular text generation model
-	req, err := http.NewRequest("POST", "https://api-inference.huggingface.co/models/microsoft/DialoGPT-medium", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -539,26 +659,54 @@ func askHuggingFace(question string) (string, error) {
 	client := &http.Client{Timeout: 30 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("Hugging Face API error %d: %s", resp.StatusCode, string(body))
+		return nil, upstreamError(providerName, resp.StatusCode, body)
 	}
 
-	var hfResp HuggingFaceResponse
-	err = json.NewDecoder(resp.Body).Decode(&hfResp)
+	var chatResp OpenAIResponse
+	err = json.NewDecoder(resp.Body).Decode(&chatResp)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	if len(hfResp) > 0 {
-		return hfResp[0].GeneratedText, nil
+	return &chatResp, nil
+}
+
+// openAIStyleCompletion normalises a chat completions response. finish_reason
+// "length" (and Mistral's "model_length") means the answer hit the token limit.
+func openAIStyleCompletion(providerName, model string, chatResp *OpenAIResponse) (*completion, error) {
+	if len(chatResp.Choices) == 0 {
+		return nil, fmt.Errorf("no response from %s", providerName)
+	}
+
+	choice := chatResp.Choices[0]
+	return &completion{
+		Model:        defaultString(chatResp.Model, model),
+		Text:         choice.Message.Content,
+		FinishReason: choice.FinishReason,
+		Truncated:    choice.FinishReason == "length" || choice.FinishReason == "model_length",
+		InputTokens:  chatResp.Usage.PromptTokens,
+		OutputTokens: chatResp.Usage.CompletionTokens,
+	}, nil
+}
+
+func defaultString(value, fallback string) string {
+	if value == "" {
+		return fallback
 	}
+	return value
+}
 
-	return "", errors.New("no response from Hugging Face")
+func defaultInt(value, fallback int) int {
+	if value <= 0 {
+		return fallback
+	}
+	return value
 }
 
 func getFromCache(id string) string {
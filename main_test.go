@@ -2,13 +2,18 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	mcp_golang "github.com/metoro-io/mcp-golang"
+	"github.com/metoro-io/mcp-golang/transport/stdio"
 	"github.com/ryanuber/go-filecache"
 )
 
@@ -135,23 +140,31 @@ func createMockClaudeServer() *httptest.Server {
 
 // Test cache functionality
 func TestCacheOperations(t *testing.T) {
-	cache := filecache.NewCache("test_cache", cacheTime*time.Millisecond)
-	defer cache.Clear() // Clean up after test
-
-	key := "test_key"
+	path := filepath.Join(t.TempDir(), "test_cache")
 	value := "test_value"
 
+	writer := func(path string) error {
+		return os.WriteFile(path, []byte(value), 0644)
+	}
+	cache := filecache.New(path, cacheTime*time.Millisecond, writer)
+
 	// Test setting cache
-	err := cache.Set(key, []byte(value))
+	fh, err := cache.Get()
 	if err != nil {
 		t.Fatalf("Failed to set cache: %v", err)
 	}
+	fh.Close()
 
 	// Test getting cache immediately
-	cached, err := cache.Get(key)
+	expired := filecache.New(path, cacheTime*time.Millisecond, func(string) error {
+		return errors.New("expired")
+	})
+	fh, err = expired.Get()
 	if err != nil {
 		t.Fatalf("Failed to get cache: %v", err)
 	}
+	cached, _ := io.ReadAll(fh)
+	fh.Close()
 
 	if string(cached) != value {
 		t.Errorf("Expected cached value to be '%s', got '%s'", value, string(cached))
@@ -160,7 +173,7 @@ func TestCacheOperations(t *testing.T) {
 	// Test cache expiration
 	time.Sleep((cacheTime + 100) * time.Millisecond)
 
-	_, err = cache.Get(key)
+	_, err = expired.Get()
 	if err == nil {
 		t.Error("Expected cache to be expired, but got value")
 	}
@@ -168,20 +181,30 @@ func TestCacheOperations(t *testing.T) {
 
 // Test MCP server tool creation
 func TestMCPToolCreation(t *testing.T) {
+	server := mcp_golang.NewServer(stdio.NewStdioServerTransport())
+
 	// Test weather tool creation
-	weatherTool := mcp_golang.NewTool("get_weather")
-	weatherTool.Description = "Get weather information for a zip code"
+	err := server.RegisterTool("get_weather", "Get weather information for a zip code", func(arguments MyFunctionsArguments) (*mcp_golang.ToolResponse, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to register get_weather: %v", err)
+	}
 
-	if weatherTool.Name != "get_weather" {
-		t.Errorf("Expected tool name to be 'get_weather', got '%s'", weatherTool.Name)
+	if !server.CheckToolRegistered("get_weather") {
+		t.Errorf("Expected tool 'get_weather' to be registered")
 	}
 
 	// Test Claude tool creation
-	claudeTool := mcp_golang.NewTool("ask_claude")
-	claudeTool.Description = "Ask a question to Claude AI"
+	err = server.RegisterTool("ask_claude", "Ask a question to Claude AI", func(arguments ClaudeArguments) (*mcp_golang.ToolResponse, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to register ask_claude: %v", err)
+	}
 
-	if claudeTool.Name != "ask_claude" {
-		t.Errorf("Expected tool name to be 'ask_claude', got '%s'", claudeTool.Name)
+	if !server.CheckToolRegistered("ask_claude") {
+		t.Errorf("Expected tool 'ask_claude' to be registered")
 	}
 }
 
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	mcp_golang "github.com/metoro-io/mcp-golang"
 	"github.com/metoro-io/mcp-golang/transport/stdio"
@@ -33,31 +40,116 @@ type FileInfoArguments struct {
 	FilePath string `json:"file_path" jsonschema:"required,description=Path to get file information"`
 }
 
+// defaultDrainTimeout is how long shutdown waits for in-flight tool calls;
+// MCP_DRAIN_TIMEOUT overrides it
+const defaultDrainTimeout = 10 * time.Second
+
+var errShuttingDown = errors.New("server is shutting down; not accepting new calls")
+
+// Calls in flight, so shutdown can let them finish before exiting
+var (
+	inflightMu sync.Mutex
+	draining   bool
+	inflight   sync.WaitGroup
+)
+
+// trackInflight counts a call as in flight and refuses it once shutdown has started
+func trackInflight(next Handler) Handler {
+	return func(call *toolCall) (*mcp_golang.ToolResponse, error) {
+		inflightMu.Lock()
+		if draining {
+			inflightMu.Unlock()
+			return nil, errShuttingDown
+		}
+		inflight.Add(1)
+		inflightMu.Unlock()
+		defer inflight.Done()
+
+		return next(call)
+	}
+}
+
+// drain stops accepting calls and waits up to timeout for in-flight ones
+func drain(timeout time.Duration) bool {
+	inflightMu.Lock()
+	draining = true
+	inflightMu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		inflight.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
 func main() {
-	fmt.Println("Starting MCP File Operations Server...")
+	os.Exit(run())
+}
+
+// run serves until SIGINT or SIGTERM and returns the process exit code. Logs go
+// to stderr because stdout carries the MCP protocol.
+func run() int {
+	log.Println("Starting MCP File Operations Server...")
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
 	server := mcp_golang.NewServer(stdio.NewStdioServerTransport())
+	if err := registerTools(server); err != nil {
+		log.Printf("failed to register tools: %v", err)
+		return 1
+	}
+	if err := server.Serve(); err != nil {
+		log.Printf("failed to start server: %v", err)
+		return 1
+	}
+
+	<-ctx.Done()
+	stop()
+
+	timeout := defaultDrainTimeout
+	if d, err := time.ParseDuration(os.Getenv("MCP_DRAIN_TIMEOUT")); err == nil && d > 0 {
+		timeout = d
+	}
+	log.Printf("shutting down; waiting up to %s for in-flight calls", timeout)
+	if !drain(timeout) {
+		log.Printf("drain period of %s expired with calls still in flight", timeout)
+	}
+	return 0
+}
 
+func registerTools(server *mcp_golang.Server) error {
 	// Register read file tool
-	server.RegisterTool("read_file", "Read contents of a file", func(args ReadFileArguments) (*mcp_golang.ToolResponse, error) {
+	if err := register(server, "read_file", "Read contents of a file", func(args ReadFileArguments) (*mcp_golang.ToolResponse, error) {
 		content, err := os.ReadFile(args.FilePath)
 		if err != nil {
 			return nil, fmt.Errorf("error reading file: %v", err)
 		}
 		return mcp_golang.NewToolResponse(mcp_golang.NewTextContent(string(content))), nil
-	})
+	}); err != nil {
+		return err
+	}
 
 	// Register write file tool
-	server.RegisterTool("write_file", "Write content to a file", func(args WriteFileArguments) (*mcp_golang.ToolResponse, error) {
+	if err := register(server, "write_file", "Write content to a file", func(args WriteFileArguments) (*mcp_golang.ToolResponse, error) {
 		err := os.WriteFile(args.FilePath, []byte(args.Content), 0644)
 		if err != nil {
 			return nil, fmt.Errorf("error writing file: %v", err)
 		}
 		return mcp_golang.NewToolResponse(mcp_golang.NewTextContent(fmt.Sprintf("Successfully wrote to %s", args.FilePath))), nil
-	})
+	}); err != nil {
+		return err
+	}
 
 	// Register list files tool
-	server.RegisterTool("list_files", "List files in a directory", func(args ListFilesArguments) (*mcp_golang.ToolResponse, error) {
+	if err := register(server, "list_files", "List files in a directory", func(args ListFilesArguments) (*mcp_golang.ToolResponse, error) {
 		files, err := os.ReadDir(args.Directory)
 		if err != nil {
 			return nil, fmt.Errorf("error reading directory: %v", err)
@@ -74,10 +166,12 @@ func main() {
 
 		result := strings.Join(fileList, "\n")
 		return mcp_golang.NewToolResponse(mcp_golang.NewTextContent(result)), nil
-	})
+	}); err != nil {
+		return err
+	}
 
 	// Register search files tool
-	server.RegisterTool("search_files", "Search for text in files", func(args SearchFilesArguments) (*mcp_golang.ToolResponse, error) {
+	if err := register(server, "search_files", "Search for text in files", func(args SearchFilesArguments) (*mcp_golang.ToolResponse, error) {
 		var results []string
 
 		err := filepath.Walk(args.Directory, func(path string, info os.FileInfo, err error) error {
@@ -103,10 +197,12 @@ func main() {
 		}
 
 		return mcp_golang.NewToolResponse(mcp_golang.NewTextContent(strings.Join(results, "\n"))), nil
-	})
+	}); err != nil {
+		return err
+	}
 
 	// Register file info tool
-	server.RegisterTool("file_info", "Get file information", func(args FileInfoArguments) (*mcp_golang.ToolResponse, error) {
+	if err := register(server, "file_info", "Get file information", func(args FileInfoArguments) (*mcp_golang.ToolResponse, error) {
 		info, err := os.Stat(args.FilePath)
 		if err != nil {
 			return nil, fmt.Errorf("error getting file info: %v", err)
@@ -124,7 +220,9 @@ Mode: %s`,
 			info.Mode())
 
 		return mcp_golang.NewToolResponse(mcp_golang.NewTextContent(fileInfo)), nil
-	})
+	}); err != nil {
+		return err
+	}
 
-	server.Serve()
+	return nil
 }
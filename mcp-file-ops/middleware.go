@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	mcp_golang "github.com/metoro-io/mcp-golang"
+)
+
+// toolCall is one tool invocation on its way through the middleware chain
+type toolCall struct {
+	Tool string
+	Args any // the decoded arguments struct
+}
+
+// Handler runs a tool call
+type Handler func(call *toolCall) (*mcp_golang.ToolResponse, error)
+
+// Middleware wraps a Handler with behaviour shared by every tool
+type Middleware func(next Handler) Handler
+
+// middlewares is the chain every tool runs through, outermost first
+var middlewares = []Middleware{recoverPanics, toolToggle, trackInflight, timeCalls, validateArguments}
+
+// register adds a tool whose handler runs through the middleware chain
+func register[T any](server *mcp_golang.Server, name, description string, fn func(T) (*mcp_golang.ToolResponse, error)) error {
+	next := chain(func(call *toolCall) (*mcp_golang.ToolResponse, error) {
+		return fn(call.Args.(T))
+	})
+	return server.RegisterTool(name, description, func(args T) (*mcp_golang.ToolResponse, error) {
+		return next(&toolCall{Tool: name, Args: args})
+	})
+}
+
+// chain wraps a handler in every middleware
+func chain(handler Handler) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// recoverPanics turns a panicking tool into an error result instead of taking
+// the whole server down. The panic value can hold file contents or paths, so it
+// only goes to the log; the client gets a generic message.
+func recoverPanics(next Handler) Handler {
+	return func(call *toolCall) (response *mcp_golang.ToolResponse, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("tool %s panicked: %v\n%s", call.Tool, p, debug.Stack())
+				response, err = nil, fmt.Errorf("internal error in %s; see the server log for details", call.Tool)
+			}
+		}()
+		return next(call)
+	}
+}
+
+// toolToggle refuses calls to tools switched off by TOOLS_DISABLED, or left out
+// of TOOLS_ENABLED when that is set. Both are comma-separated tool names.
+func toolToggle(next Handler) Handler {
+	return func(call *toolCall) (*mcp_golang.ToolResponse, error) {
+		if enabled := os.Getenv("TOOLS_ENABLED"); (enabled != "" && !listContains(enabled, call.Tool)) || listContains(os.Getenv("TOOLS_DISABLED"), call.Tool) {
+			return nil, fmt.Errorf("tool %s is disabled on this server", call.Tool)
+		}
+		return next(call)
+	}
+}
+
+// listContains reports whether a comma-separated list has name in it
+func listContains(list, name string) bool {
+	for _, item := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(item), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// timeCalls logs how long each tool call took and whether it failed
+func timeCalls(next Handler) Handler {
+	return func(call *toolCall) (*mcp_golang.ToolResponse, error) {
+		start := time.Now()
+		response, err := next(call)
+		outcome := "ok"
+		if err != nil {
+			outcome = "error"
+		}
+		log.Printf("tool %s: %s in %s", call.Tool, outcome, time.Since(start).Round(time.Millisecond))
+		return response, err
+	}
+}
+
+// validateArguments rejects calls missing a field tagged jsonschema:"required"
+func validateArguments(next Handler) Handler {
+	return func(call *toolCall) (*mcp_golang.ToolResponse, error) {
+		v := reflect.ValueOf(call.Args)
+		if v.Kind() == reflect.Struct {
+			var missing []string
+			for i := 0; i < v.NumField(); i++ {
+				field := v.Type().Field(i)
+				if strings.Split(field.Tag.Get("jsonschema"), ",")[0] != "required" {
+					continue
+				}
+				if value := v.Field(i); value.IsZero() || (value.Kind() == reflect.String && strings.TrimSpace(value.String()) == "") {
+					missing = append(missing, strings.Split(field.Tag.Get("json"), ",")[0])
+				}
+			}
+			if len(missing) > 0 {
+				return nil, fmt.Errorf("%s: missing required argument %s", call.Tool, strings.Join(missing, ", "))
+			}
+		}
+		return next(call)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	mcp_golang "github.com/metoro-io/mcp-golang"
+)
+
+// Test a panicking tool becomes an error that doesn't echo the panic value
+func TestRecoverPanics(t *testing.T) {
+	handler := chain(func(call *toolCall) (*mcp_golang.ToolResponse, error) {
+		panic("could not parse /home/alice/.ssh/id_rsa: BEGIN PRIVATE KEY")
+	})
+
+	response, err := handler(&toolCall{Tool: "read_file", Args: ReadFileArguments{FilePath: "x"}})
+	if response != nil || err == nil {
+		t.Fatalf("Expected an error result, got %v, %v", response, err)
+	}
+	if strings.Contains(err.Error(), "id_rsa") || strings.Contains(err.Error(), "PRIVATE KEY") {
+		t.Errorf("Expected the panic value to stay out of the error, got '%s'", err.Error())
+	}
+	if !strings.Contains(err.Error(), "internal error in read_file") {
+		t.Errorf("Expected a generic internal error, got '%s'", err.Error())
+	}
+}
+
+// Test disabled tools are refused before their handler runs
+func TestToolToggle(t *testing.T) {
+	called := false
+	handler := chain(func(call *toolCall) (*mcp_golang.ToolResponse, error) {
+		called = true
+		return mcp_golang.NewToolResponse(mcp_golang.NewTextContent("ok")), nil
+	})
+	call := &toolCall{Tool: "write_file", Args: WriteFileArguments{FilePath: "a.txt", Content: "hi"}}
+
+	t.Setenv("TOOLS_DISABLED", "list_files, Write_File")
+	if _, err := handler(call); err == nil || !strings.Contains(err.Error(), "disabled") || called {
+		t.Errorf("Expected write_file to be disabled, got %v (handler called: %v)", err, called)
+	}
+
+	t.Setenv("TOOLS_DISABLED", "")
+	t.Setenv("TOOLS_ENABLED", "read_file")
+	if _, err := handler(call); err == nil || called {
+		t.Errorf("Expected tools missing from TOOLS_ENABLED to be disabled, got %v", err)
+	}
+
+	t.Setenv("TOOLS_ENABLED", "read_file,write_file")
+	if _, err := handler(call); err != nil || !called {
+		t.Errorf("Expected an enabled tool to run, got %v", err)
+	}
+}
+
+// Test calls missing a required argument are rejected
+func TestValidateArguments(t *testing.T) {
+	handler := chain(func(call *toolCall) (*mcp_golang.ToolResponse, error) {
+		return nil, errors.New("should not run")
+	})
+
+	_, err := handler(&toolCall{Tool: "write_file", Args: WriteFileArguments{FilePath: " "}})
+	if err == nil || !strings.Contains(err.Error(), "missing required argument file_path, content") {
+		t.Errorf("Expected both missing arguments to be reported, got %v", err)
+	}
+}
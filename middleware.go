@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	mcp_golang "github.com/metoro-io/mcp-golang"
+)
+
+// toolCall is one tool invocation on its way through the middleware chain
+type toolCall struct {
+	Tool string
+	Ctx  context.Context
+	Args any // the decoded arguments struct
+}
+
+// Handler runs a tool call
+type Handler func(call *toolCall) (*mcp_golang.ToolResponse, error)
+
+// Middleware wraps a Handler with behaviour shared by every tool
+type Middleware func(next Handler) Handler
+
+// toolRegistrar is the part of the MCP server tools are registered with
+type toolRegistrar interface {
+	RegisterTool(name string, description string, handler any) error
+}
+
+// toolRegistry registers tools with the server through a middleware chain. The
+// first middleware is the outermost.
+type toolRegistry struct {
+	server      toolRegistrar
+	middlewares []Middleware
+	calls       map[string]func(ctx context.Context, args json.RawMessage) (*mcp_golang.ToolResponse, error)
+}
+
+func newToolRegistry(server toolRegistrar, middlewares ...Middleware) *toolRegistry {
+	return &toolRegistry{server: server, middlewares: middlewares, calls: map[string]func(context.Context, json.RawMessage) (*mcp_golang.ToolResponse, error){}}
+}
+
+// defaultMiddlewares is the chain every tool of this server runs through
+func defaultMiddlewares() []Middleware {
+	return []Middleware{recoverPanics, toolToggle, timeCalls, validateArguments}
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// register adds a tool. handler is a typed tool handler, func(Args) or
+// func(context.Context, Args), returning (*mcp_golang.ToolResponse, error). The
+// registered function keeps that signature, which mcp-golang reflects on to build
+// the input schema, and is tracked by the server lifecycle.
+func (r *toolRegistry) register(name, description string, handler any) error {
+	if _, ok := r.calls[name]; ok {
+		return fmt.Errorf("tool %s is already registered", name)
+	}
+	v := reflect.ValueOf(handler)
+	t := v.Type()
+	if t.Kind() != reflect.Func || t.NumIn() < 1 || t.NumIn() > 2 || t.NumOut() != 2 || t.Out(1) != errorType {
+		return fmt.Errorf("tool %s: handler must be func([context.Context,] args) (*mcp_golang.ToolResponse, error), got %s", name, t)
+	}
+	withCtx := t.NumIn() == 2 && t.In(0) == contextType
+
+	next := Handler(func(call *toolCall) (*mcp_golang.ToolResponse, error) {
+		in := []reflect.Value{reflect.ValueOf(call.Args)}
+		if withCtx {
+			in = []reflect.Value{reflect.ValueOf(call.Ctx), in[0]}
+		}
+		out := v.Call(in)
+		response, _ := out[0].Interface().(*mcp_golang.ToolResponse)
+		err, _ := out[1].Interface().(error)
+		return response, err
+	})
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		next = r.middlewares[i](next)
+	}
+
+	wrapped := reflect.MakeFunc(t, func(args []reflect.Value) []reflect.Value {
+		call := &toolCall{Tool: name, Ctx: context.Background(), Args: args[len(args)-1].Interface()}
+		if withCtx {
+			call.Ctx = args[0].Interface().(context.Context)
+		}
+		response, err := next(call)
+
+		out := []reflect.Value{reflect.Zero(t.Out(0)), reflect.Zero(errorType)}
+		if response != nil {
+			out[0] = reflect.ValueOf(response)
+		}
+		if err != nil {
+			out[1] = reflect.ValueOf(&err).Elem()
+		}
+		return out
+	}).Interface()
+
+	if err := r.server.RegisterTool(name, description, serverLifecycle.track(wrapped)); err != nil {
+		return err
+	}
+	argsType := t.In(t.NumIn() - 1)
+	r.calls[name] = func(ctx context.Context, args json.RawMessage) (*mcp_golang.ToolResponse, error) {
+		decoded := reflect.New(argsType)
+		if len(args) > 0 {
+			if err := json.Unmarshal(args, decoded.Interface()); err != nil {
+				return nil, fmt.Errorf("%s: invalid arguments: %v", name, err)
+			}
+		}
+		return next(&toolCall{Tool: name, Ctx: ctx, Args: decoded.Elem().Interface()})
+	}
+	return nil
+}
+
+// has reports whether a tool is registered
+func (r *toolRegistry) has(name string) bool {
+	_, ok := r.calls[name]
+	return ok
+}
+
+// call runs a registered tool from JSON arguments through the middleware chain,
+// the way a client call would, but outside the server lifecycle. Background jobs
+// use it.
+func (r *toolRegistry) call(ctx context.Context, name string, args json.RawMessage) (*mcp_golang.ToolResponse, error) {
+	call, ok := r.calls[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown tool %s", name)
+	}
+	return call(ctx, args)
+}
+
+// recoverPanics turns a panicking tool into an error result instead of taking
+// the whole server down
+func recoverPanics(next Handler) Handler {
+	return func(call *toolCall) (response *mcp_golang.ToolResponse, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("tool %s panicked: %v\n%s", call.Tool, p, debug.Stack())
+				response, err = nil, fmt.Errorf("internal error in %s: %v", call.Tool, redactSecrets(fmt.Sprint(p)))
+			}
+		}()
+		return next(call)
+	}
+}
+
+// toolToggle refuses calls to tools switched off by TOOLS_DISABLED, or left out
+// of TOOLS_ENABLED when that is set. Both are comma-separated tool names.
+func toolToggle(next Handler) Handler {
+	return func(call *toolCall) (*mcp_golang.ToolResponse, error) {
+		if !toolEnabled(call.Tool) {
+			return nil, fmt.Errorf("tool %s is disabled on this server", call.Tool)
+		}
+		return next(call)
+	}
+}
+
+func toolEnabled(name string) bool {
+	if enabled := os.Getenv("TOOLS_ENABLED"); enabled != "" && !listContains(enabled, name) {
+		return false
+	}
+	return !listContains(os.Getenv("TOOLS_DISABLED"), name)
+}
+
+// listContains reports whether a comma-separated list has name in it
+func listContains(list, name string) bool {
+	for _, item := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(item), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// timeCalls logs how long each tool call took and whether it failed
+func timeCalls(next Handler) Handler {
+	return func(call *toolCall) (*mcp_golang.ToolResponse, error) {
+		start := time.Now()
+		response, err := next(call)
+		outcome := "ok"
+		if err != nil {
+			outcome = "error"
+		}
+		log.Printf("tool %s: %s in %s", call.Tool, outcome, time.Since(start).Round(time.Millisecond))
+		return response, err
+	}
+}
+
+// argumentValidator is implemented by arguments that check more than their
+// required fields
+type argumentValidator interface {
+	validate() error
+}
+
+// validateArguments rejects calls missing a field tagged jsonschema:"required",
+// then runs the arguments' own validate method when they have one
+func validateArguments(next Handler) Handler {
+	return func(call *toolCall) (*mcp_golang.ToolResponse, error) {
+		if missing := missingArguments(call.Args); len(missing) > 0 {
+			return nil, fmt.Errorf("%s: missing required argument %s", call.Tool, strings.Join(missing, ", "))
+		}
+		if v, ok := call.Args.(argumentValidator); ok {
+			if err := v.validate(); err != nil {
+				return nil, fmt.Errorf("%s: %w", call.Tool, err)
+			}
+		}
+		return next(call)
+	}
+}
+
+// missingArguments lists the JSON names of required fields left empty
+func missingArguments(args any) []string {
+	v := reflect.ValueOf(args)
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var missing []string
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		schema := strings.Split(field.Tag.Get("jsonschema"), ",")
+		if schema[0] != "required" {
+			continue
+		}
+		value := v.Field(i)
+		if value.IsZero() || (value.Kind() == reflect.String && strings.TrimSpace(value.String()) == "") {
+			missing = append(missing, strings.Split(field.Tag.Get("json"), ",")[0])
+		}
+	}
+	return missing
+}
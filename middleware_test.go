@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	mcp_golang "github.com/metoro-io/mcp-golang"
+)
+
+// fakeToolServer keeps the handlers registered with it
+type fakeToolServer struct {
+	handlers map[string]any
+}
+
+func (s *fakeToolServer) RegisterTool(name string, description string, handler any) error {
+	s.handlers[name] = handler
+	return nil
+}
+
+type rangeArguments struct {
+	Name string `json:"name" jsonschema:"required,description=A name"`
+	Min  int    `json:"min"`
+	Max  int    `json:"max"`
+}
+
+func (a rangeArguments) validate() error {
+	if a.Min > a.Max {
+		return errors.New("min is above max")
+	}
+	return nil
+}
+
+// Test middlewares run outermost first and the handler keeps its signature and context
+func TestToolRegistryChain(t *testing.T) {
+	server := &fakeToolServer{handlers: map[string]any{}}
+	var order []string
+	trace := func(label string) Middleware {
+		return func(next Handler) Handler {
+			return func(call *toolCall) (*mcp_golang.ToolResponse, error) {
+				order = append(order, label+":"+call.Tool)
+				return next(call)
+			}
+		}
+	}
+	tools := newToolRegistry(server, trace("outer"), trace("inner"))
+
+	type key struct{}
+	err := tools.register("echo", "Echo", func(ctx context.Context, args rangeArguments) (*mcp_golang.ToolResponse, error) {
+		order = append(order, "handler:"+ctx.Value(key{}).(string)+":"+args.Name)
+		return newTextResponse(args.Name), nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	handler, ok := server.handlers["echo"].(func(context.Context, rangeArguments) (*mcp_golang.ToolResponse, error))
+	if !ok {
+		t.Fatalf("Expected the handler signature to be kept, got %T", server.handlers["echo"])
+	}
+	ctx := context.WithValue(context.Background(), key{}, "ctx")
+	if _, err := handler(ctx, rangeArguments{Name: "x"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Join(order, ",") != "outer:echo,inner:echo,handler:ctx:x" {
+		t.Errorf("Expected outer, inner, handler, got %v", order)
+	}
+
+	order = nil
+	if _, err := tools.call(ctx, "echo", []byte(`{"name":"y"}`)); err != nil || strings.Join(order, ",") != "outer:echo,inner:echo,handler:ctx:y" {
+		t.Errorf("Expected a call by name to run through the chain, got %v, %v", order, err)
+	}
+	if _, err := tools.call(ctx, "missing", nil); err == nil {
+		t.Errorf("Expected calling an unknown tool to fail")
+	}
+
+	if err := tools.register("bad", "Bad", func() error { return nil }); err == nil {
+		t.Errorf("Expected a handler with the wrong signature to be rejected")
+	}
+}
+
+// Test the built-in middlewares recover panics, validate arguments and honour the tool switches
+func TestDefaultMiddlewares(t *testing.T) {
+	server := &fakeToolServer{handlers: map[string]any{}}
+	tools := newToolRegistry(server, defaultMiddlewares()...)
+	tools.register("check", "Check", func(args rangeArguments) (*mcp_golang.ToolResponse, error) {
+		if args.Name == "boom" {
+			panic("boom")
+		}
+		return newTextResponse("ok"), nil
+	})
+	check := server.handlers["check"].(func(rangeArguments) (*mcp_golang.ToolResponse, error))
+
+	cases := map[string]struct {
+		args rangeArguments
+		want string
+	}{
+		"valid":    {rangeArguments{Name: "a", Min: 1, Max: 2}, ""},
+		"missing":  {rangeArguments{Name: "  "}, "check: missing required argument name"},
+		"validate": {rangeArguments{Name: "a", Min: 3, Max: 2}, "check: min is above max"},
+		"panic":    {rangeArguments{Name: "boom"}, "internal error in check: boom"},
+	}
+	for label, c := range cases {
+		response, err := check(c.args)
+		if c.want == "" && (err != nil || response == nil) {
+			t.Errorf("%s: expected a response, got %v", label, err)
+		}
+		if c.want != "" && (err == nil || err.Error() != c.want) {
+			t.Errorf("%s: expected error '%s', got %v", label, c.want, err)
+		}
+	}
+
+	t.Setenv("TOOLS_DISABLED", "other, CHECK")
+	if _, err := check(rangeArguments{Name: "a"}); err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Errorf("Expected a disabled tool to be refused, got %v", err)
+	}
+	t.Setenv("TOOLS_DISABLED", "")
+	t.Setenv("TOOLS_ENABLED", "zipcode")
+	if _, err := check(rangeArguments{Name: "a"}); err == nil {
+		t.Errorf("Expected a tool left out of TOOLS_ENABLED to be refused")
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Model listing endpoints; variables so tests can point them at a mock server.
+// Gemini lists models at geminiBaseURL.
+var (
+	claudeModelsURL  = "https://api.anthropic.com/v1/models"
+	openAIModelsURL  = "https://api.openai.com/v1/models"
+	mistralModelsURL = "https://api.mistral.ai/v1/models"
+)
+
+// defaultModelsCacheTTL is how long a provider's model list is reused;
+// MODELS_CACHE_TTL (a Go duration such as "30m") overrides it
+const defaultModelsCacheTTL = time.Hour
+
+type ListModelsArguments struct {
+	Provider string `json:"provider" jsonschema:"description=Only list models for this provider: claude\\, openai\\, gemini or mistral (default: every provider with a key)"`
+	Refresh  bool   `json:"refresh" jsonschema:"description=Ignore cached results and query the providers again"`
+}
+
+// ModelInfo describes one model as reported by its provider. Context window and
+// capabilities are only filled in when the provider's listing includes them.
+type ModelInfo struct {
+	ID              string   `json:"id"`
+	DisplayName     string   `json:"display_name,omitempty"`
+	ContextWindow   int      `json:"context_window,omitempty"`
+	MaxOutputTokens int      `json:"max_output_tokens,omitempty"`
+	Capabilities    []string `json:"capabilities,omitempty"`
+	OwnedBy         string   `json:"owned_by,omitempty"`
+	Created         string   `json:"created,omitempty"`
+}
+
+// ProviderModels is the list_models result for one provider
+type ProviderModels struct {
+	Provider  string      `json:"provider"`
+	Models    []ModelInfo `json:"models,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Cached    bool        `json:"cached"`
+	FetchedAt time.Time   `json:"fetched_at,omitempty"`
+}
+
+// modelCache keeps successful model listings per provider for the cache TTL
+type modelCache struct {
+	mu      sync.Mutex
+	entries map[string]ProviderModels
+}
+
+var modelListCache = &modelCache{entries: map[string]ProviderModels{}}
+
+func (c *modelCache) get(name string) (ProviderModels, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[name]
+	if !ok || time.Since(entry.FetchedAt) > modelsCacheTTL() {
+		return ProviderModels{}, false
+	}
+	return entry, true
+}
+
+func (c *modelCache) put(entry ProviderModels) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[entry.Provider] = entry
+}
+
+func modelsCacheTTL() time.Duration {
+	return durationFromEnv("MODELS_CACHE_TTL", defaultModelsCacheTTL)
+}
+
+// listModels queries the model-listing endpoint of the requested provider, or of
+// every provider that has a key configured, in parallel
+func listModels(only string, refresh bool) ([]ProviderModels, error) {
+	var names []string
+	if only != "" {
+		p, ok := providers[only]
+		if !ok || p.ListModels == nil {
+			return nil, fmt.Errorf("list_models does not support provider %q", only)
+		}
+		names = []string{only}
+	} else {
+		for _, name := range providerNames() {
+			p := providers[name]
+			if p.ListModels != nil && hasAPIKey(p.KeyEnv) {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no provider with a model listing has an API key configured")
+	}
+
+	results := make([]ProviderModels, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			results[i] = listProviderModels(name, refresh)
+		}(i, name)
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+func listProviderModels(name string, refresh bool) ProviderModels {
+	if !refresh {
+		if entry, ok := modelListCache.get(name); ok {
+			entry.Cached = true
+			return entry
+		}
+	}
+
+	list, err := providers[name].ListModels()
+	if err != nil {
+		return ProviderModels{Provider: name, Error: redactError(err).Error()}
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	entry := ProviderModels{Provider: name, Models: list, FetchedAt: time.Now().UTC()}
+	modelListCache.put(entry)
+	return entry
+}
+
+// providerNames returns the provider keys in a stable order
+func providerNames() []string {
+	var names []string
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getProviderJSON performs an authenticated GET and decodes the JSON answer
+func getProviderJSON(ctx context.Context, providerName, endpoint string, headers map[string]string, out interface{}) error {
+	return providerJSON(ctx, "GET", providerName, endpoint, headers, nil, out)
+}
+
+// postProviderJSON posts body as JSON and decodes the JSON answer into out
+func postProviderJSON(ctx context.Context, providerName, endpoint string, headers map[string]string, body, out interface{}) error {
+	return providerJSON(ctx, "POST", providerName, endpoint, headers, body, out)
+}
+
+func providerJSON(ctx context.Context, method, providerName, endpoint string, headers map[string]string, body, out interface{}) error {
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, payload)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return upstreamError(providerName, resp.StatusCode, body)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func listClaudeModels() ([]ModelInfo, error) {
+	apiKey := providerKey(context.Background(), "CLAUDE_API_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("CLAUDE_API_KEY not found in environment")
+	}
+	headers := map[string]string{"x-api-key": apiKey, "anthropic-version": "2023-06-01"}
+
+	var list []ModelInfo
+	afterID := ""
+	for {
+		query := url.Values{"limit": {"1000"}}
+		if afterID != "" {
+			query.Set("after_id", afterID)
+		}
+
+		var page struct {
+			Data []struct {
+				ID             string `json:"id"`
+				DisplayName    string `json:"display_name"`
+				CreatedAt      string `json:"created_at"`
+				MaxInputTokens int    `json:"max_input_tokens"`
+				MaxTokens      int    `json:"max_tokens"`
+			} `json:"data"`
+			HasMore bool   `json:"has_more"`
+			LastID  string `json:"last_id"`
+		}
+		if err := getProviderJSON(context.Background(), "Claude", claudeModelsURL+"?"+query.Encode(), headers, &page); err != nil {
+			return nil, err
+		}
+
+		for _, m := range page.Data {
+			list = append(list, ModelInfo{
+				ID:              m.ID,
+				DisplayName:     m.DisplayName,
+				ContextWindow:   m.MaxInputTokens,
+				MaxOutputTokens: m.MaxTokens,
+				Capabilities:    []string{"chat"},
+				Created:         m.CreatedAt,
+			})
+		}
+		if !page.HasMore || page.LastID == "" {
+			return list, nil
+		}
+		afterID = page.LastID
+	}
+}
+
+func listOpenAIModels() ([]ModelInfo, error) {
+	apiKey := providerKey(context.Background(), "OPENAI_API_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("OPENAI_API_KEY not found in environment")
+	}
+
+	var page struct {
+		Data []struct {
+			ID      string `json:"id"`
+			Created int64  `json:"created"`
+			OwnedBy string `json:"owned_by"`
+		} `json:"data"`
+	}
+	if err := getProviderJSON(context.Background(), "OpenAI", openAIModelsURL, map[string]string{"Authorization": "Bearer " + apiKey}, &page); err != nil {
+		return nil, err
+	}
+
+	var list []ModelInfo
+	for _, m := range page.Data {
+		info := ModelInfo{ID: m.ID, OwnedBy: m.OwnedBy}
+		if m.Created > 0 {
+			info.Created = time.Unix(m.Created, 0).UTC().Format(time.RFC3339)
+		}
+		list = append(list, info)
+	}
+	return list, nil
+}
+
+func listGeminiModels() ([]ModelInfo, error) {
+	apiKey := providerKey(context.Background(), "GEMINI_API_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("GEMINI_API_KEY not found in environment")
+	}
+	headers := map[string]string{"x-goog-api-key": apiKey}
+
+	var list []ModelInfo
+	pageToken := ""
+	for {
+		query := url.Values{"pageSize": {"1000"}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		var page struct {
+			Models []struct {
+				Name                       string   `json:"name"`
+				DisplayName                string   `json:"displayName"`
+				InputTokenLimit            int      `json:"inputTokenLimit"`
+				OutputTokenLimit           int      `json:"outputTokenLimit"`
+				SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+				Thinking                   bool     `json:"thinking"`
+			} `json:"models"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := getProviderJSON(context.Background(), "Gemini", strings.TrimSuffix(geminiBaseURL, "/")+"?"+query.Encode(), headers, &page); err != nil {
+			return nil, err
+		}
+
+		for _, m := range page.Models {
+			capabilities := append([]string(nil), m.SupportedGenerationMethods...)
+			if m.Thinking {
+				capabilities = append(capabilities, "thinking")
+			}
+			list = append(list, ModelInfo{
+				ID:              strings.TrimPrefix(m.Name, "models/"),
+				DisplayName:     m.DisplayName,
+				ContextWindow:   m.InputTokenLimit,
+				MaxOutputTokens: m.OutputTokenLimit,
+				Capabilities:    capabilities,
+			})
+		}
+		if page.NextPageToken == "" {
+			return list, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+func listMistralModels() ([]ModelInfo, error) {
+	apiKey := providerKey(context.Background(), "MISTRAL_API_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("MISTRAL_API_KEY not found in environment")
+	}
+
+	var page struct {
+		Data []struct {
+			ID               string          `json:"id"`
+			Name             string          `json:"name"`
+			Created          int64           `json:"created"`
+			OwnedBy          string          `json:"owned_by"`
+			MaxContextLength int             `json:"max_context_length"`
+			Capabilities     map[string]bool `json:"capabilities"`
+		} `json:"data"`
+	}
+	if err := getProviderJSON(context.Background(), "Mistral", mistralModelsURL, map[string]string{"Authorization": "Bearer " + apiKey}, &page); err != nil {
+		return nil, err
+	}
+
+	var list []ModelInfo
+	for _, m := range page.Data {
+		var capabilities []string
+		for capability, enabled := range m.Capabilities {
+			if enabled {
+				capabilities = append(capabilities, capability)
+			}
+		}
+		sort.Strings(capabilities)
+
+		info := ModelInfo{
+			ID:            m.ID,
+			DisplayName:   m.Name,
+			ContextWindow: m.MaxContextLength,
+			Capabilities:  capabilities,
+			OwnedBy:       m.OwnedBy,
+		}
+		if m.Created > 0 {
+			info.Created = time.Unix(m.Created, 0).UTC().Format(time.RFC3339)
+		}
+		list = append(list, info)
+	}
+	return list, nil
+}
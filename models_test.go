@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Test Gemini listings are paginated and carry limits and capabilities
+func TestListGeminiModels(t *testing.T) {
+	useGeminiServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-goog-api-key") == "" {
+			t.Error("Expected API key header")
+		}
+		if r.URL.Query().Get("pageToken") == "" {
+			w.Write([]byte(`{"models":[{"name":"models/gemini-2.5-flash","displayName":"Gemini 2.5 Flash","inputTokenLimit":1048576,"outputTokenLimit":65536,
+				"supportedGenerationMethods":["generateContent","countTokens"],"thinking":true}],"nextPageToken":"p2"}`))
+			return
+		}
+		w.Write([]byte(`{"models":[{"name":"models/embedding-001","supportedGenerationMethods":["embedContent"]}]}`))
+	})
+
+	list, err := listGeminiModels()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("Expected 2 models across pages, got %d", len(list))
+	}
+	if list[0].ID != "gemini-2.5-flash" || list[0].ContextWindow != 1048576 || list[0].MaxOutputTokens != 65536 {
+		t.Errorf("Unexpected model %+v", list[0])
+	}
+	if strings.Join(list[0].Capabilities, ",") != "generateContent,countTokens,thinking" {
+		t.Errorf("Unexpected capabilities %v", list[0].Capabilities)
+	}
+}
+
+// Test results are cached until refresh is requested
+func TestListModelsCache(t *testing.T) {
+	t.Setenv("MISTRAL_API_KEY", "test-mistral-key")
+	modelListCache = &modelCache{entries: map[string]ProviderModels{}}
+
+	calls := 0
+	useProviderURL(t, &mistralModelsURL, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"data":[{"id":"mistral-small-latest","max_context_length":32768,"capabilities":{"completion_chat":true,"vision":false,"function_calling":true}}]}`))
+	})
+
+	first, err := listModels("mistral", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if first[0].Cached || first[0].Models[0].ContextWindow != 32768 {
+		t.Errorf("Unexpected first result %+v", first[0])
+	}
+	if strings.Join(first[0].Models[0].Capabilities, ",") != "completion_chat,function_calling" {
+		t.Errorf("Unexpected capabilities %v", first[0].Models[0].Capabilities)
+	}
+
+	second, _ := listModels("mistral", false)
+	if !second[0].Cached || calls != 1 {
+		t.Errorf("Expected a cached result after 1 call, got cached=%t calls=%d", second[0].Cached, calls)
+	}
+
+	listModels("mistral", true)
+	if calls != 2 {
+		t.Errorf("Expected refresh to query again, got %d calls", calls)
+	}
+}
+
+// Test provider errors are reported per provider and scrubbed
+func TestListModelsProviderError(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "sk-test-openai-key-0123456789")
+	modelListCache = &modelCache{entries: map[string]ProviderModels{}}
+
+	useProviderURL(t, &openAIModelsURL, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":{"message":"Incorrect API key provided: sk-test-openai-key-0123456789"}}`))
+	})
+
+	result, err := listModels("openai", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(result[0].Error, "401") || strings.Contains(result[0].Error, "0123456789") {
+		t.Errorf("Expected a scrubbed 401 error, got '%s'", result[0].Error)
+	}
+
+	if _, err := listModels("huggingface", false); err == nil {
+		t.Error("Expected an error for a provider without a model listing")
+	}
+}
@@ -0,0 +1,747 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/invopop/jsonschema"
+	mcp_golang "github.com/metoro-io/mcp-golang"
+	"gopkg.in/yaml.v3"
+)
+
+// OpenAPI limits; a service's timeout setting overrides the default call timeout
+const (
+	defaultOpenAPITimeout = 30 * time.Second
+	openAPISpecTimeout    = 10 * time.Second
+	maxOpenAPIResponse    = 1 << 20
+)
+
+// openAPIMethods are the operations of a path item, in the order they are registered
+var openAPIMethods = []string{"get", "put", "post", "delete", "patch", "head", "options"}
+
+// invalidToolNameChars matches the characters a tool name can't have, with the
+// underscores around them, so "post_/pets" becomes "post_pets"
+var invalidToolNameChars = regexp.MustCompile(`[^A-Za-z0-9-]*[^A-Za-z0-9_-][^A-Za-z0-9-]*`)
+
+// openAPIServices is the file named by OPENAPI_SERVICES. Secrets are not kept in
+// it: auth settings name the environment variables holding them.
+//
+//	services:
+//	  - name: petstore
+//	    spec: ./petstore.yaml        # file relative to this one, or an http(s) URL
+//	    base_url: https://pets.internal/v1
+//	    timeout: 10s
+//	    headers: {X-Team: platform}
+//	    auth: {type: bearer, token_env: PETSTORE_TOKEN}
+//	    operations: [listPets, getPet]
+type openAPIServices struct {
+	Services []*openAPIService `yaml:"services"`
+}
+
+// openAPIService is one REST service whose operations become tools named
+// <name>_<operationId>
+type openAPIService struct {
+	Name       string            `yaml:"name"`
+	Spec       string            `yaml:"spec"`
+	BaseURL    string            `yaml:"base_url"`
+	Timeout    string            `yaml:"timeout"`
+	Headers    map[string]string `yaml:"headers"`
+	Auth       openAPIAuth       `yaml:"auth"`
+	SpecAuth   bool              `yaml:"spec_auth"`
+	Operations []string          `yaml:"operations"`
+
+	timeout time.Duration
+}
+
+// openAPIAuth is how requests to a service authenticate: a bearer token, basic
+// auth, or an API key sent in a header or query parameter
+type openAPIAuth struct {
+	Type        string `yaml:"type"`
+	TokenEnv    string `yaml:"token_env"`
+	UsernameEnv string `yaml:"username_env"`
+	PasswordEnv string `yaml:"password_env"`
+	Name        string `yaml:"name"`
+	In          string `yaml:"in"`
+	ValueEnv    string `yaml:"value_env"`
+}
+
+// loadOpenAPIServices reads the services file; relative spec paths are resolved
+// against the file's directory
+func loadOpenAPIServices(path string) (*openAPIServices, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading OpenAPI services: %v", err)
+	}
+	config := &openAPIServices{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("parsing OpenAPI services %s: %v", path, err)
+	}
+	for _, s := range config.Services {
+		if s.Spec != "" && !isURL(s.Spec) && !filepath.IsAbs(s.Spec) {
+			s.Spec = filepath.Join(filepath.Dir(path), s.Spec)
+		}
+	}
+	return config, nil
+}
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// check validates a service's settings and resolves its timeout
+func (s *openAPIService) check() error {
+	if !toolNamePattern.MatchString(s.Name) {
+		return fmt.Errorf("invalid service name %q", s.Name)
+	}
+	if s.Spec == "" {
+		return fmt.Errorf("service %s has no spec", s.Name)
+	}
+	s.timeout = defaultOpenAPITimeout
+	if s.Timeout != "" {
+		d, err := time.ParseDuration(s.Timeout)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid timeout %q", s.Timeout)
+		}
+		s.timeout = d
+	}
+	return s.Auth.check()
+}
+
+func (a openAPIAuth) check() error {
+	var needed []string
+	switch a.Type {
+	case "", "none":
+		return nil
+	case "bearer":
+		needed = []string{a.TokenEnv}
+	case "basic":
+		needed = []string{a.UsernameEnv, a.PasswordEnv}
+	case "api_key":
+		if a.Name == "" {
+			return fmt.Errorf("api_key auth needs the name of the header or query parameter")
+		}
+		if a.In != "" && a.In != "header" && a.In != "query" {
+			return fmt.Errorf("api_key auth goes in a header or query, not %q", a.In)
+		}
+		needed = []string{a.ValueEnv}
+	default:
+		return fmt.Errorf("unknown auth type %q", a.Type)
+	}
+	for _, name := range needed {
+		if name == "" || os.Getenv(name) == "" {
+			return fmt.Errorf("%s auth: environment variable %q is not set", a.Type, name)
+		}
+	}
+	return nil
+}
+
+// apply adds the credentials to a request
+func (a openAPIAuth) apply(req *http.Request) {
+	switch a.Type {
+	case "bearer":
+		req.Header.Set("Authorization", "Bearer "+os.Getenv(a.TokenEnv))
+	case "basic":
+		req.SetBasicAuth(os.Getenv(a.UsernameEnv), os.Getenv(a.PasswordEnv))
+	case "api_key":
+		if a.In == "query" {
+			query := req.URL.Query()
+			query.Set(a.Name, os.Getenv(a.ValueEnv))
+			req.URL.RawQuery = query.Encode()
+		} else {
+			req.Header.Set(a.Name, os.Getenv(a.ValueEnv))
+		}
+	}
+}
+
+// redact scrubs the service's credentials from text echoed back to clients
+func (a openAPIAuth) redact(text string) string {
+	for _, name := range []string{a.TokenEnv, a.PasswordEnv, a.ValueEnv} {
+		if value := os.Getenv(name); name != "" && len(value) >= 4 {
+			text = strings.ReplaceAll(text, value, redactedPlaceholder)
+		}
+	}
+	return redactSecrets(text)
+}
+
+// openAPIDocument is the part of an OpenAPI 3 document tools are built from,
+// after local $refs have been inlined
+type openAPIDocument struct {
+	OpenAPI string                     `json:"openapi"`
+	Servers []openAPIServer            `json:"servers"`
+	Paths   map[string]openAPIPathItem `json:"paths"`
+}
+
+type openAPIServer struct {
+	URL       string `json:"url"`
+	Variables map[string]struct {
+		Default string `json:"default"`
+	} `json:"variables"`
+}
+
+type openAPIPathItem struct {
+	Parameters []openAPIParameter `json:"parameters"`
+	Get        *openAPIOperation  `json:"get"`
+	Put        *openAPIOperation  `json:"put"`
+	Post       *openAPIOperation  `json:"post"`
+	Delete     *openAPIOperation  `json:"delete"`
+	Patch      *openAPIOperation  `json:"patch"`
+	Head       *openAPIOperation  `json:"head"`
+	Options    *openAPIOperation  `json:"options"`
+}
+
+func (p openAPIPathItem) operation(method string) *openAPIOperation {
+	return map[string]*openAPIOperation{
+		"get": p.Get, "put": p.Put, "post": p.Post, "delete": p.Delete,
+		"patch": p.Patch, "head": p.Head, "options": p.Options,
+	}[method]
+}
+
+type openAPIOperation struct {
+	OperationID string             `json:"operationId"`
+	Summary     string             `json:"summary"`
+	Description string             `json:"description"`
+	Parameters  []openAPIParameter `json:"parameters"`
+	RequestBody *struct {
+		Description string                      `json:"description"`
+		Required    bool                        `json:"required"`
+		Content     map[string]openAPIMediaType `json:"content"`
+	} `json:"requestBody"`
+}
+
+type openAPIMediaType struct {
+	Schema interface{} `json:"schema"`
+}
+
+type openAPIParameter struct {
+	Name        string      `json:"name"`
+	In          string      `json:"in"`
+	Description string      `json:"description"`
+	Required    bool        `json:"required"`
+	Schema      interface{} `json:"schema"`
+}
+
+// openAPITool is one operation of a service, registered as a tool
+type openAPITool struct {
+	service     *openAPIService
+	name        string
+	description string
+	method      string
+	path        string
+	baseURL     string
+	params      []openAPIParameter
+	bodyArg     string // argument holding the request body, if the operation takes one
+	bodyType    string
+	schema      *jsonschema.Schema
+}
+
+// fetchSpec reads a spec from a file or a URL. The service's headers and
+// credentials go along only when specCredentials allows it.
+func (s *openAPIService) fetchSpec() ([]byte, error) {
+	if !isURL(s.Spec) {
+		return os.ReadFile(s.Spec)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), openAPISpecTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.Spec, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.specCredentials() {
+		for name, value := range s.Headers {
+			req.Header.Set(name, value)
+		}
+		s.Auth.apply(req)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, redactError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching spec: %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 16*maxOpenAPIResponse))
+}
+
+// specCredentials reports whether the spec URL may see the service's headers
+// and credentials: when it is on base_url's scheme and host, or when spec_auth
+// says so. A spec hosted elsewhere (a CDN, a docs site) gets neither.
+func (s *openAPIService) specCredentials() bool {
+	if s.SpecAuth {
+		return true
+	}
+	if s.BaseURL == "" {
+		return false
+	}
+	spec, err := url.Parse(s.Spec)
+	if err != nil {
+		return false
+	}
+	base, err := url.Parse(s.BaseURL)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(spec.Scheme, base.Scheme) && strings.EqualFold(spec.Host, base.Host)
+}
+
+// tools reads the service's spec and builds a tool for every operation, or for
+// the ones listed in operations. An operation whose schema can't be built is
+// logged and skipped.
+func (s *openAPIService) tools() ([]*openAPITool, error) {
+	data, err := s.fetchSpec()
+	if err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing spec: %v", err)
+	}
+	raw = normalizeYAML(raw)
+	resolved, err := inlineRefs(raw, raw, nil)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(resolved)
+	if err != nil {
+		return nil, err
+	}
+	var doc openAPIDocument
+	if err := json.Unmarshal(encoded, &doc); err != nil {
+		return nil, fmt.Errorf("parsing spec: %v", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("only OpenAPI 3 documents are supported")
+	}
+	baseURL, err := s.baseURL(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := map[string]bool{}
+	for _, id := range s.Operations {
+		wanted[id] = true
+	}
+	var paths []string
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var tools []*openAPITool
+	for _, path := range paths {
+		item := doc.Paths[path]
+		for _, method := range openAPIMethods {
+			op := item.operation(method)
+			if op == nil || (len(wanted) > 0 && !wanted[op.OperationID]) {
+				continue
+			}
+			tool, err := s.newTool(baseURL, method, path, item.Parameters, op)
+			if err != nil {
+				log.Printf("openapi: %s %s %s: %v", s.Name, strings.ToUpper(method), path, err)
+				continue
+			}
+			tools = append(tools, tool)
+		}
+	}
+	return tools, nil
+}
+
+// baseURL is base_url when set, otherwise the spec's first server with its
+// variables at their defaults, resolved against the spec URL when relative
+func (s *openAPIService) baseURL(doc openAPIDocument) (string, error) {
+	if s.BaseURL != "" {
+		return strings.TrimRight(s.BaseURL, "/"), nil
+	}
+	if len(doc.Servers) == 0 {
+		return "", fmt.Errorf("spec lists no servers; set base_url")
+	}
+	server := doc.Servers[0]
+	base := server.URL
+	for name, variable := range server.Variables {
+		base = strings.ReplaceAll(base, "{"+name+"}", variable.Default)
+	}
+	if !isURL(base) {
+		if !isURL(s.Spec) {
+			return "", fmt.Errorf("server URL %q is relative; set base_url", base)
+		}
+		specURL, err := url.Parse(s.Spec)
+		if err != nil {
+			return "", err
+		}
+		ref, err := url.Parse(base)
+		if err != nil {
+			return "", err
+		}
+		base = specURL.ResolveReference(ref).String()
+	}
+	return strings.TrimRight(base, "/"), nil
+}
+
+// newTool builds the tool for one operation. Parameters become arguments of the
+// same name; a request body becomes a "body" argument.
+func (s *openAPIService) newTool(baseURL, method, path string, shared []openAPIParameter, op *openAPIOperation) (*openAPITool, error) {
+	id := op.OperationID
+	if id == "" {
+		id = method + "_" + path
+	}
+	name := strings.Trim(invalidToolNameChars.ReplaceAllString(s.Name+"_"+id, "_"), "_")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+
+	tool := &openAPITool{
+		service: s,
+		name:    name,
+		method:  strings.ToUpper(method),
+		path:    path,
+		baseURL: baseURL,
+		params:  mergeParameters(shared, op.Parameters),
+	}
+	tool.description = strings.TrimSpace(op.Summary + "\n\n" + op.Description)
+	if tool.description == "" {
+		tool.description = tool.method + " " + path
+	} else {
+		tool.description += "\n\n" + tool.method + " " + path
+	}
+
+	properties := map[string]interface{}{}
+	var required []string
+	for _, p := range tool.params {
+		if p.In == "cookie" {
+			continue
+		}
+		prop := map[string]interface{}{}
+		if schema, ok := p.Schema.(map[string]interface{}); ok {
+			for k, v := range schema {
+				prop[k] = v
+			}
+		}
+		if p.Description != "" {
+			prop["description"] = p.Description
+		}
+		properties[p.Name] = prop
+		if p.Required || p.In == "path" {
+			required = append(required, p.Name)
+		}
+	}
+
+	if body := op.RequestBody; body != nil && len(body.Content) > 0 {
+		tool.bodyArg = "body"
+		if _, taken := properties["body"]; taken {
+			tool.bodyArg = "request_body"
+		}
+		tool.bodyType = requestBodyType(body.Content)
+		prop := map[string]interface{}{"type": "string"}
+		if isJSONType(tool.bodyType) {
+			prop = map[string]interface{}{}
+			if schema, ok := body.Content[tool.bodyType].Schema.(map[string]interface{}); ok {
+				for k, v := range schema {
+					prop[k] = v
+				}
+			}
+		}
+		if body.Description != "" {
+			prop["description"] = body.Description
+		}
+		properties[tool.bodyArg] = prop
+		if body.Required {
+			required = append(required, tool.bodyArg)
+		}
+	}
+
+	schema, err := parseInputSchema(map[string]interface{}{"type": "object", "properties": properties, "required": required})
+	if err != nil {
+		return nil, err
+	}
+	tool.schema = schema
+	return tool, nil
+}
+
+// mergeParameters lets an operation's parameters override the path item's ones
+// with the same name and location
+func mergeParameters(shared, own []openAPIParameter) []openAPIParameter {
+	merged := append([]openAPIParameter(nil), own...)
+	for _, p := range shared {
+		overridden := false
+		for _, o := range own {
+			if o.Name == p.Name && o.In == p.In {
+				overridden = true
+			}
+		}
+		if !overridden {
+			merged = append(merged, p)
+		}
+	}
+	return merged
+}
+
+// requestBodyType picks the content type a body is sent as, preferring JSON
+func requestBodyType(content map[string]openAPIMediaType) string {
+	var types []string
+	for t := range content {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		if t == "application/json" {
+			return t
+		}
+	}
+	for _, t := range types {
+		if isJSONType(t) {
+			return t
+		}
+	}
+	return types[0]
+}
+
+func isJSONType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// normalizeYAML turns the map[interface{}]interface{} yaml.v3 produces for
+// mappings with non-string keys (response codes) into JSON-friendly maps
+func normalizeYAML(node interface{}) interface{} {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			v[k] = normalizeYAML(child)
+		}
+		return v
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, child := range v {
+			out[fmt.Sprint(k)] = normalizeYAML(child)
+		}
+		return out
+	case []interface{}:
+		for i, child := range v {
+			v[i] = normalizeYAML(child)
+		}
+		return v
+	}
+	return node
+}
+
+// inlineRefs replaces local $refs ("#/components/schemas/Pet") with what they
+// point to. A schema that refers to itself is cut off with an empty schema at
+// the point it recurses.
+func inlineRefs(node, root interface{}, seen []string) (interface{}, error) {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		if ref, ok := v["$ref"].(string); ok {
+			for _, s := range seen {
+				if s == ref {
+					return map[string]interface{}{}, nil
+				}
+			}
+			target, err := lookupRef(root, ref)
+			if err != nil {
+				return nil, err
+			}
+			return inlineRefs(target, root, append(seen[:len(seen):len(seen)], ref))
+		}
+		out := make(map[string]interface{}, len(v))
+		for k, child := range v {
+			resolved, err := inlineRefs(child, root, seen)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = resolved
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, child := range v {
+			resolved, err := inlineRefs(child, root, seen)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = resolved
+		}
+		return out, nil
+	}
+	return node, nil
+}
+
+// lookupRef follows a local JSON pointer reference
+func lookupRef(root interface{}, ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %q: only references within the document are followed", ref)
+	}
+	node := root
+	for _, part := range strings.Split(ref[2:], "/") {
+		part = strings.NewReplacer("~1", "/", "~0", "~").Replace(part)
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("$ref %q not found", ref)
+		}
+		if node, ok = object[part]; !ok {
+			return nil, fmt.Errorf("$ref %q not found", ref)
+		}
+	}
+	return node, nil
+}
+
+// call performs the operation's HTTP request with the tool arguments and returns
+// the response body, pretty-printed when it is JSON
+func (t *openAPITool) call(ctx context.Context, args map[string]interface{}) (string, error) {
+	path := t.path
+	query := url.Values{}
+	header := http.Header{}
+	for _, p := range t.params {
+		value, ok := args[p.Name]
+		if !ok || value == nil {
+			continue
+		}
+		switch p.In {
+		case "path":
+			path = strings.ReplaceAll(path, "{"+p.Name+"}", url.PathEscape(parameterString(value)))
+		case "query":
+			if list, ok := value.([]interface{}); ok {
+				for _, item := range list {
+					query.Add(p.Name, parameterString(item))
+				}
+			} else {
+				query.Add(p.Name, parameterString(value))
+			}
+		case "header":
+			header.Set(p.Name, parameterString(value))
+		}
+	}
+	target := t.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var body io.Reader
+	if value, ok := args[t.bodyArg]; ok && t.bodyArg != "" && value != nil {
+		if isJSONType(t.bodyType) {
+			data, err := json.Marshal(value)
+			if err != nil {
+				return "", err
+			}
+			body = bytes.NewReader(data)
+		} else {
+			body = strings.NewReader(parameterString(value))
+		}
+		header.Set("Content-Type", t.bodyType)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.service.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, t.method, target, body)
+	if err != nil {
+		return "", err
+	}
+	for name, value := range t.service.Headers {
+		req.Header.Set(name, value)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
+	t.service.Auth.apply(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("%s %s timed out after %s", t.method, t.path, t.service.timeout)
+		}
+		return "", fmt.Errorf("%s %s: %s", t.method, t.path, t.service.Auth.redact(err.Error()))
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxOpenAPIResponse+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxOpenAPIResponse {
+		return "", fmt.Errorf("%s %s returned more than %d bytes", t.method, t.path, maxOpenAPIResponse)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", errors.New(t.service.Auth.redact(upstreamError(t.service.Name, resp.StatusCode, data).Error()))
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return resp.Status, nil
+	}
+	var indented bytes.Buffer
+	if json.Indent(&indented, data, "", "  ") == nil {
+		return t.service.Auth.redact(indented.String()), nil
+	}
+	return t.service.Auth.redact(string(data)), nil
+}
+
+// parameterString formats an argument for a path, query or header parameter
+func parameterString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// registerOpenAPIServices registers a tool for every operation of the services
+// in the file named by OPENAPI_SERVICES. A service that fails to load, and an
+// operation whose name is already taken, are logged and skipped.
+func registerOpenAPIServices(tools *toolRegistry, path string) error {
+	if path == "" {
+		return nil
+	}
+	config, err := loadOpenAPIServices(path)
+	if err != nil {
+		return err
+	}
+	for _, s := range config.Services {
+		if err := s.check(); err != nil {
+			log.Printf("openapi: %s: %v", s.Name, err)
+			continue
+		}
+		operations, err := s.tools()
+		if err != nil {
+			log.Printf("openapi: %s: %v", s.Name, err)
+			continue
+		}
+		registered := 0
+		for _, op := range operations {
+			op := op
+			err := registerDynamic(tools, op.name, op.description, op.schema, func(ctx context.Context, args map[string]interface{}) (*mcp_golang.ToolResponse, error) {
+				text, err := op.call(ctx, args)
+				if err != nil {
+					return nil, redactError(err)
+				}
+				return newTextResponse(text), nil
+			})
+			if err != nil {
+				log.Printf("openapi: %s: %v", s.Name, err)
+				continue
+			}
+			registered++
+		}
+		log.Printf("openapi: registered %d tools from %s", registered, s.Spec)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	mcp_golang "github.com/metoro-io/mcp-golang"
+)
+
+const petstoreSpec = `openapi: 3.0.3
+info: {title: Pets, version: "1"}
+servers:
+  - url: /api
+paths:
+  /pets/{id}:
+    parameters:
+      - {name: id, in: path, required: true, schema: {type: integer}}
+    get:
+      operationId: getPet
+      summary: Get a pet
+      parameters:
+        - {name: fields, in: query, schema: {type: array, items: {type: string}}}
+        - {name: X-Trace, in: header, schema: {type: string}}
+      responses:
+        200: {description: The pet}
+  /pets:
+    post:
+      summary: Add a pet
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema: {$ref: "#/components/schemas/Pet"}
+      responses:
+        "201": {description: Added}
+components:
+  schemas:
+    Pet:
+      type: object
+      required: [name]
+      properties:
+        name: {type: string}
+        parent: {$ref: "#/components/schemas/Pet"}
+`
+
+// Test the operations of a spec fetched from a URL become tools that perform the HTTP calls
+func TestOpenAPITools(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/openapi.yaml" {
+			io.WriteString(w, petstoreSpec)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+r.URL.RequestURI()+" "+r.Header.Get("Authorization")+" "+r.Header.Get("X-Trace")+" "+string(body))
+		switch {
+		case r.URL.Path == "/api/pets/7":
+			w.Write([]byte(`{"id":7,"name":"Rex"}`))
+		case r.URL.Path == "/api/pets/8":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"no pet 8 for token pets-token-123"}`))
+		default:
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "services.yaml")
+	os.WriteFile(config, []byte("services:\n  - name: pets\n    spec: "+srv.URL+"/openapi.yaml\n    auth: {type: bearer, token_env: PETS_TOKEN}\n  - name: broken\n    spec: ./missing.yaml\n"), 0644)
+	t.Setenv("PETS_TOKEN", "pets-token-123")
+
+	server := &fakeToolServer{handlers: map[string]any{}}
+	if err := registerOpenAPIServices(newToolRegistry(server, defaultMiddlewares()...), config); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(server.handlers) != 2 {
+		t.Fatalf("Expected 2 tools, got %d", len(server.handlers))
+	}
+	call := func(name string, args string) (string, error) {
+		handler, ok := server.handlers[name].(func(context.Context, dynamicArguments) (*mcp_golang.ToolResponse, error))
+		if !ok {
+			t.Fatalf("Expected tool %s to be registered, got %T", name, server.handlers[name])
+		}
+		var decoded dynamicArguments
+		json.Unmarshal([]byte(args), &decoded)
+		response, err := handler(context.Background(), decoded)
+		if err != nil {
+			return "", err
+		}
+		return response.Content[0].TextContent.Text, nil
+	}
+
+	text, err := call("pets_getPet", `{"id": 7, "fields": ["name", "age"], "X-Trace": "abc"}`)
+	if err != nil || !strings.Contains(text, `"name": "Rex"`) {
+		t.Errorf("Expected the pet back, got '%s', %v", text, err)
+	}
+	if requests[0] != "GET /api/pets/7?fields=name&fields=age Bearer pets-token-123 abc " {
+		t.Errorf("Expected the path, query, header and auth to be sent, got '%s'", requests[0])
+	}
+
+	if _, err := call("pets_post_pets", `{"body": {"name": "Tom"}}`); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if requests[1] != `POST /api/pets Bearer pets-token-123  {"name":"Tom"}` {
+		t.Errorf("Expected the body to be sent as JSON, got '%s'", requests[1])
+	}
+
+	if _, err := call("pets_post_pets", `{}`); err == nil || !strings.Contains(err.Error(), "missing required argument body") {
+		t.Errorf("Expected the required body to be enforced, got %v", err)
+	}
+	_, err = call("pets_getPet", `{"id": 8}`)
+	if err == nil || !strings.Contains(err.Error(), "pets API error 404: no pet 8") || strings.Contains(err.Error(), "pets-token-123") {
+		t.Errorf("Expected a redacted upstream error, got %v", err)
+	}
+}
+
+// Test a spec hosted away from base_url never receives the service's credentials
+func TestOpenAPISpecCredentials(t *testing.T) {
+	var authorized []string
+	spec := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorized = append(authorized, r.Header.Get("Authorization")+r.Header.Get("X-Team"))
+		io.WriteString(w, petstoreSpec)
+	}))
+	defer spec.Close()
+	t.Setenv("PETS_TOKEN", "pets-token-123")
+
+	service := &openAPIService{
+		Name:    "pets",
+		Spec:    spec.URL + "/openapi.yaml",
+		BaseURL: "https://pets.example.com/api",
+		Headers: map[string]string{"X-Team": "platform"},
+		Auth:    openAPIAuth{Type: "bearer", TokenEnv: "PETS_TOKEN"},
+	}
+	if _, err := service.tools(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if authorized[0] != "" {
+		t.Errorf("Expected no credentials or headers on the spec request, got '%s'", authorized[0])
+	}
+
+	service.SpecAuth = true
+	if _, err := service.tools(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if authorized[1] != "Bearer pets-token-123platform" {
+		t.Errorf("Expected spec_auth to send the credentials and headers, got '%s'", authorized[1])
+	}
+
+	service.SpecAuth, service.BaseURL = false, spec.URL+"/api"
+	if !service.specCredentials() {
+		t.Errorf("Expected a spec on base_url's host to get the credentials")
+	}
+}
+
+// Test local $refs are inlined and self-references are cut off
+func TestInlineRefs(t *testing.T) {
+	root := map[string]interface{}{
+		"components": map[string]interface{}{"schemas": map[string]interface{}{
+			"Node": map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+				"next": map[string]interface{}{"$ref": "#/components/schemas/Node"},
+			}},
+		}},
+		"schema": map[string]interface{}{"$ref": "#/components/schemas/Node"},
+	}
+	resolved, err := inlineRefs(root, root, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data, _ := json.Marshal(resolved.(map[string]interface{})["schema"])
+	if string(data) != `{"properties":{"next":{}},"type":"object"}` {
+		t.Errorf("Expected the recursion to be cut off, got %s", data)
+	}
+
+	if _, err := inlineRefs(map[string]interface{}{"$ref": "other.yaml#/Pet"}, root, nil); err == nil {
+		t.Errorf("Expected an external $ref to be rejected")
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PII kinds
+const (
+	piiEmail = "EMAIL"
+	piiCNPJ  = "CNPJ"
+	piiCPF   = "CPF"
+	piiCard  = "CARD"
+	piiPhone = "PHONE"
+	piiCEP   = "CEP"
+)
+
+// piiDetector finds one kind of personal data; valid, when set, rejects matches
+// whose check digits are wrong
+type piiDetector struct {
+	Kind    string
+	Pattern *regexp.Regexp
+	Valid   func(match string) bool
+}
+
+// piiDetectors run in order, so identifiers with check digits are taken before
+// the looser phone and CEP patterns can claim their digits
+var piiDetectors = []piiDetector{
+	{piiEmail, regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), nil},
+	{piiCNPJ, regexp.MustCompile(`\b\d{2}\.?\d{3}\.?\d{3}/?\d{4}-?\d{2}\b`), validCNPJ},
+	{piiCPF, regexp.MustCompile(`\b\d{3}\.?\d{3}\.?\d{3}-?\d{2}\b`), validCPF},
+	{piiCard, regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), validLuhn},
+	{piiPhone, regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{2,3}\)|\b\d{2,3})[ .-]?\d{4,5}[ .-]?\d{4}\b`), nil},
+	{piiCEP, regexp.MustCompile(`\b\d{5}-\d{3}\b`), nil},
+}
+
+// replacePII passes every piece of personal data found in text to replace and
+// substitutes what it returns
+func replacePII(text string, detectors []piiDetector, replace func(kind, value string) string) string {
+	for _, d := range detectors {
+		text = d.Pattern.ReplaceAllStringFunc(text, func(match string) string {
+			if d.Valid != nil && !d.Valid(match) {
+				return match
+			}
+			return replace(d.Kind, match)
+		})
+	}
+	return text
+}
+
+// scrubPII masks personal data with its kind, e.g. [EMAIL] or [CPF]
+func scrubPII(text string) string {
+	return replacePII(text, piiDetectors, func(kind, _ string) string { return "[" + kind + "]" })
+}
+
+// piiKindPattern is what custom kinds may be called, so their placeholders can be found again
+var piiKindPattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]{0,31}$`)
+
+// piiPlaceholderPattern finds placeholders in an answer, tolerating the spaces
+// models sometimes add inside the brackets
+var piiPlaceholderPattern = regexp.MustCompile(`⟦\s*([A-Z][A-Z0-9_]*_\d+)\s*⟧`)
+
+// piiRedactionEnabled reports whether prompts are masked before they are sent;
+// PII_REDACTION=on turns it on
+func piiRedactionEnabled() bool {
+	return strings.EqualFold(os.Getenv("PII_REDACTION"), "on")
+}
+
+// loadPIIDetectors returns the custom patterns from the YAML file named by
+// PII_PATTERNS, sorted by kind, followed by the built-in detectors:
+//
+//	patterns:
+//	  EMPLOYEE_ID: 'EMP-\d{6}'
+func loadPIIDetectors() ([]piiDetector, error) {
+	path := os.Getenv("PII_PATTERNS")
+	if path == "" {
+		return piiDetectors, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading PII patterns: %v", err)
+	}
+	var file struct {
+		Patterns map[string]string `yaml:"patterns"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing PII patterns %s: %v", path, err)
+	}
+
+	var kinds []string
+	for kind := range file.Patterns {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	var detectors []piiDetector
+	for _, kind := range kinds {
+		if !piiKindPattern.MatchString(kind) {
+			return nil, fmt.Errorf("PII patterns %s: kind %q must be upper case letters, digits and '_'", path, kind)
+		}
+		pattern, err := regexp.Compile(file.Patterns[kind])
+		if err != nil {
+			return nil, fmt.Errorf("PII patterns %s: %s: %v", path, kind, err)
+		}
+		detectors = append(detectors, piiDetector{Kind: kind, Pattern: pattern})
+	}
+	return append(detectors, piiDetectors...), nil
+}
+
+// piiVault masks personal data in the prompts of one request with numbered
+// placeholders such as ⟦CPF_1⟧ and puts the originals back in the answer. The
+// same value always gets the same placeholder.
+type piiVault struct {
+	detectors    []piiDetector
+	originals    map[string]string // placeholder -> value
+	placeholders map[string]string // value -> placeholder
+	counts       map[string]int
+}
+
+func newPIIVault() (*piiVault, error) {
+	detectors, err := loadPIIDetectors()
+	if err != nil {
+		return nil, err
+	}
+	return &piiVault{detectors: detectors, originals: map[string]string{}, placeholders: map[string]string{}, counts: map[string]int{}}, nil
+}
+
+// mask replaces personal data in text with placeholders
+func (v *piiVault) mask(text string) string {
+	return replacePII(text, v.detectors, func(kind, value string) string {
+		if placeholder, ok := v.placeholders[value]; ok {
+			return placeholder
+		}
+		v.counts[kind]++
+		placeholder := fmt.Sprintf("⟦%s_%d⟧", kind, v.counts[kind])
+		v.placeholders[value] = placeholder
+		v.originals[placeholder] = value
+		return placeholder
+	})
+}
+
+// maskRequest returns a copy of req with every message masked
+func (v *piiVault) maskRequest(req completionRequest) completionRequest {
+	messages := make([]Message, len(req.Messages))
+	for i, m := range req.Messages {
+		m.Content = v.mask(m.Content)
+		messages[i] = m
+	}
+	req.Messages = messages
+	return req
+}
+
+// restore puts the original values back in place of the placeholders
+func (v *piiVault) restore(text string) string {
+	return piiPlaceholderPattern.ReplaceAllStringFunc(text, func(match string) string {
+		key := "⟦" + piiPlaceholderPattern.FindStringSubmatch(match)[1] + "⟧"
+		if original, ok := v.originals[key]; ok {
+			return original
+		}
+		return match
+	})
+}
+
+// digitsOf keeps only the digits of s
+func digitsOf(s string) []int {
+	var digits []int
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			digits = append(digits, int(r-'0'))
+		}
+	}
+	return digits
+}
+
+// allSame reports whether every digit is the same, as in 111.111.111-11, which
+// passes the check-digit test but is never a real document
+func allSame(digits []int) bool {
+	for _, d := range digits {
+		if d != digits[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkDigit is the mod-11 check digit used by CPF and CNPJ
+func checkDigit(digits []int, weights []int) int {
+	sum := 0
+	for i, w := range weights {
+		sum += digits[i] * w
+	}
+	if r := sum % 11; r >= 2 {
+		return 11 - r
+	}
+	return 0
+}
+
+func validCPF(s string) bool {
+	d := digitsOf(s)
+	if len(d) != 11 || allSame(d) {
+		return false
+	}
+	return checkDigit(d, []int{10, 9, 8, 7, 6, 5, 4, 3, 2}) == d[9] &&
+		checkDigit(d, []int{11, 10, 9, 8, 7, 6, 5, 4, 3, 2}) == d[10]
+}
+
+func validCNPJ(s string) bool {
+	d := digitsOf(s)
+	if len(d) != 14 || allSame(d) {
+		return false
+	}
+	return checkDigit(d, []int{5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2}) == d[12] &&
+		checkDigit(d, []int{6, 5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2}) == d[13]
+}
+
+// validLuhn checks a card number's Luhn checksum
+func validLuhn(s string) bool {
+	d := digitsOf(s)
+	if len(d) < 13 || len(d) > 19 || allSame(d) || strings.Count(s, " ")+strings.Count(s, "-") > 4 {
+		return false
+	}
+	sum := 0
+	for i := range d {
+		n := d[len(d)-1-i]
+		if i%2 == 1 {
+			n *= 2
+			if n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test personal data is masked by kind and numbers with bad check digits are left alone
+func TestScrubPII(t *testing.T) {
+	cases := map[string]string{
+		"Write to maria.silva@example.com.br":            "Write to [EMAIL]",
+		"CPF 529.982.247-25 and 52998224725":             "CPF [CPF] and [CPF]",
+		"CPF 529.982.247-26 is invalid":                  "CPF 529.982.247-26 is invalid",
+		"CNPJ 11.222.333/0001-81":                        "CNPJ [CNPJ]",
+		"card 4111 1111 1111 1111":                       "card [CARD]",
+		"call +55 (11) 98765-4321 or (21) 3456-7890":     "call [PHONE] or [PHONE]",
+		"CEP 01310-100, São Paulo":                       "CEP [CEP], São Paulo",
+		"order 12345 shipped in 2026, 3 items for R$ 40": "order 12345 shipped in 2026, 3 items for R$ 40",
+	}
+	for input, want := range cases {
+		if got := scrubPII(input); got != want {
+			t.Errorf("Expected '%s' for '%s', got '%s'", want, input, got)
+		}
+	}
+}
+
+// Test prompts are masked with reversible placeholders before they reach the provider
+func TestAskRedactsPII(t *testing.T) {
+	t.Setenv("CLAUDE_API_KEY", "test-claude-key")
+	t.Setenv("PII_REDACTION", "on")
+	patterns := filepath.Join(t.TempDir(), "pii.yaml")
+	os.WriteFile(patterns, []byte("patterns:\n  CONTRACT: 'CT-\\d{5}'\n"), 0644)
+	t.Setenv("PII_PATTERNS", patterns)
+
+	var sent string
+	useProviderURL(t, &claudeMessagesURL, func(w http.ResponseWriter, r *http.Request) {
+		var req ClaudeRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		sent = req.Messages[0].Content
+		w.Write([]byte(`{"content":[{"type":"text","text":"Done: ⟦ CPF_1 ⟧ moved to ⟦CEP_1⟧ under ⟦CONTRACT_1⟧, ⟦EMAIL_9⟧ unknown"}],"stop_reason":"end_turn"}`))
+	})
+
+	question := "Customer 529.982.247-25 (CPF 529.982.247-25) moved to CEP 01310-100, contract CT-12345"
+	result, err := ask(context.Background(), "claude", completionRequest{Messages: userMessage(question)}, continueOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if sent != "Customer ⟦CPF_1⟧ (CPF ⟦CPF_1⟧) moved to CEP ⟦CEP_1⟧, contract ⟦CONTRACT_1⟧" {
+		t.Errorf("Expected the prompt to be masked, sent '%s'", sent)
+	}
+	if result.Text != "Done: 529.982.247-25 moved to 01310-100 under CT-12345, ⟦EMAIL_9⟧ unknown" || result.PIIRedacted != 3 {
+		t.Errorf("Expected the originals to be restored, got '%s' (%d redacted)", result.Text, result.PIIRedacted)
+	}
+
+	os.WriteFile(patterns, []byte("patterns:\n  bad kind: 'x'\n"), 0644)
+	if _, err := ask(context.Background(), "claude", completionRequest{Messages: userMessage(question)}, continueOptions{}); err == nil {
+		t.Errorf("Expected a bad pattern file to stop the call")
+	}
+}
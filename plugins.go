@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/invopop/jsonschema"
+	mcp_golang "github.com/metoro-io/mcp-golang"
+	"gopkg.in/yaml.v3"
+)
+
+// Plugin limits; PLUGIN_TIMEOUT overrides the default timeout
+const (
+	defaultPluginTimeout  = 30 * time.Second
+	pluginManifestTimeout = 5 * time.Second
+	maxPluginOutput       = 1 << 20
+)
+
+// pluginBaseEnv is all a plugin inherits from the server's environment unless
+// its manifest lists more; API keys are never passed on by default
+var pluginBaseEnv = []string{"PATH", "HOME", "LANG", "LC_ALL", "TZ", "TMPDIR"}
+
+var toolNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,63}$`)
+
+// pluginManifest declares a tool backed by an executable. It is either a
+// .yaml/.yml/.json file next to the executable, or what an executable without
+// a manifest prints when run with --manifest.
+//
+//	name: word_count
+//	description: Count the words in a text
+//	command: ./word_count.py
+//	timeout: 10s
+//	env: [WORDS_LOCALE]
+//	input_schema:
+//	  type: object
+//	  properties:
+//	    text: {type: string, description: Text to count}
+//	  required: [text]
+type pluginManifest struct {
+	Name        string      `json:"name" yaml:"name"`
+	Description string      `json:"description" yaml:"description"`
+	Command     string      `json:"command" yaml:"command"`
+	Args        []string    `json:"args" yaml:"args"`
+	Timeout     string      `json:"timeout" yaml:"timeout"`
+	Env         []string    `json:"env" yaml:"env"`
+	InputSchema interface{} `json:"input_schema" yaml:"input_schema"`
+
+	path    string // manifest or self-describing executable
+	timeout time.Duration
+	schema  *jsonschema.Schema
+}
+
+// pluginsDir returns the plugins directory from PLUGINS_DIR. Plugins run local
+// executables, so they are opt-in: without PLUGINS_DIR no directory is scanned.
+func pluginsDir() string {
+	return os.Getenv("PLUGINS_DIR")
+}
+
+// loadPlugins reads every plugin in dir. Manifests are read first; executables
+// a manifest points to are not asked for their own. A plugin that fails to load
+// is logged and skipped. A missing directory means no plugins.
+func loadPlugins(dir string) ([]*pluginManifest, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests, executables []string
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(absDir, entry.Name())
+		switch filepath.Ext(entry.Name()) {
+		case ".yaml", ".yml", ".json":
+			manifests = append(manifests, path)
+		default:
+			if info, err := entry.Info(); err == nil && info.Mode()&0111 != 0 {
+				executables = append(executables, path)
+			}
+		}
+	}
+	sort.Strings(manifests)
+	sort.Strings(executables)
+
+	var plugins []*pluginManifest
+	described := map[string]bool{}
+	for _, path := range manifests {
+		m, err := readPluginManifest(path)
+		if err != nil {
+			log.Printf("plugins: %s: %v", path, err)
+			continue
+		}
+		described[m.Command] = true
+		plugins = append(plugins, m)
+	}
+	for _, path := range executables {
+		if described[path] {
+			continue
+		}
+		m, err := describePlugin(path)
+		if err != nil {
+			log.Printf("plugins: %s: %v", path, err)
+			continue
+		}
+		plugins = append(plugins, m)
+	}
+	return plugins, nil
+}
+
+// readPluginManifest parses a manifest file; its command is relative to the file
+func readPluginManifest(path string) (*pluginManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &pluginManifest{path: path}
+	if err := yaml.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("parsing manifest: %v", err)
+	}
+	if m.Command == "" {
+		return nil, fmt.Errorf("manifest has no command")
+	}
+	if !filepath.IsAbs(m.Command) {
+		m.Command = filepath.Join(filepath.Dir(path), m.Command)
+	}
+	return m, m.check()
+}
+
+// describePlugin runs an executable with --manifest and parses what it prints
+func describePlugin(path string) (*pluginManifest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pluginManifestTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, path, "--manifest")
+	cmd.Dir = filepath.Dir(path)
+	cmd.Env = pluginEnv(nil)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("running --manifest: %v", err)
+	}
+	m := &pluginManifest{path: path}
+	if err := json.Unmarshal(out, m); err != nil {
+		return nil, fmt.Errorf("--manifest did not print a JSON manifest: %v", err)
+	}
+	m.Command = path
+	return m, m.check()
+}
+
+// check validates a manifest and resolves its timeout
+func (m *pluginManifest) check() error {
+	if !toolNamePattern.MatchString(m.Name) {
+		return fmt.Errorf("invalid tool name %q", m.Name)
+	}
+	if m.Description == "" {
+		return fmt.Errorf("tool %s has no description", m.Name)
+	}
+	if info, err := os.Stat(m.Command); err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+		return fmt.Errorf("command %s is not an executable file", m.Command)
+	}
+	m.timeout = durationFromEnv("PLUGIN_TIMEOUT", defaultPluginTimeout)
+	if m.Timeout != "" {
+		d, err := time.ParseDuration(m.Timeout)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid timeout %q", m.Timeout)
+		}
+		m.timeout = d
+	}
+	schema, err := parseInputSchema(m.InputSchema)
+	if err != nil {
+		return err
+	}
+	m.schema = schema
+	return nil
+}
+
+// pluginEnv is the environment a plugin runs with: the base variables plus the
+// ones its manifest asks for, when the server has them set
+func pluginEnv(extra []string) []string {
+	var env []string
+	for _, name := range append(append([]string(nil), pluginBaseEnv...), extra...) {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// run invokes the plugin with the arguments as JSON on stdin and reads its JSON
+// result from stdout. An object with an "error" string fails the call, one with
+// a "text" string is returned as is, and anything else is returned as JSON.
+func (m *pluginManifest) run(ctx context.Context, args map[string]interface{}) (string, error) {
+	input, err := json.Marshal(args)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, m.Command, m.Args...)
+	cmd.Dir = filepath.Dir(m.Command)
+	cmd.Env = pluginEnv(m.Env)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.WaitDelay = time.Second
+	var stdout, stderr limitedBuffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("plugin %s timed out after %s", m.Name, m.timeout)
+		}
+		return "", fmt.Errorf("plugin %s failed: %v: %s", m.Name, err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.truncated {
+		return "", fmt.Errorf("plugin %s wrote more than %d bytes", m.Name, maxPluginOutput)
+	}
+
+	var result interface{}
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		return "", fmt.Errorf("plugin %s did not return JSON: %v", m.Name, err)
+	}
+	if object, ok := result.(map[string]interface{}); ok {
+		if message, ok := object["error"].(string); ok && message != "" {
+			return "", fmt.Errorf("plugin %s: %s", m.Name, message)
+		}
+		if text, ok := object["text"].(string); ok && len(object) == 1 {
+			return text, nil
+		}
+	}
+	out, err := json.MarshalIndent(result, "", "  ")
+	return string(out), err
+}
+
+// limitedBuffer keeps the first maxPluginOutput bytes written to it
+type limitedBuffer struct {
+	bytes.Buffer
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := maxPluginOutput - b.Len(); len(p) > room {
+		b.truncated = true
+		b.Buffer.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// registerPlugins registers a tool for every plugin in the plugins directory.
+// A plugin whose name is already taken is skipped.
+func registerPlugins(tools *toolRegistry, dir string) error {
+	if dir == "" {
+		return nil
+	}
+	plugins, err := loadPlugins(dir)
+	if err != nil {
+		return err
+	}
+	for _, m := range plugins {
+		m := m
+		err := registerDynamic(tools, m.Name, m.Description, m.schema, func(ctx context.Context, args map[string]interface{}) (*mcp_golang.ToolResponse, error) {
+			text, err := m.run(ctx, args)
+			if err != nil {
+				return nil, redactError(err)
+			}
+			return newTextResponse(text), nil
+		})
+		if err != nil {
+			log.Printf("plugins: %s: %v", m.path, err)
+			continue
+		}
+		log.Printf("plugins: registered %s from %s", m.Name, m.path)
+	}
+	return nil
+}
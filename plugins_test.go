@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	mcp_golang "github.com/metoro-io/mcp-golang"
+)
+
+// writePlugin writes an executable shell script into dir
+func writePlugin(t *testing.T, dir, name, script string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+// Test plugins are read from manifests and from executables describing themselves, skipping broken ones
+func TestLoadPlugins(t *testing.T) {
+	dir := t.TempDir()
+	writePlugin(t, dir, "echo.sh", "cat\n")
+	os.WriteFile(filepath.Join(dir, "echo.yaml"), []byte(`name: echo
+description: Echo the arguments
+command: ./echo.sh
+timeout: 2s
+input_schema:
+  type: object
+  properties:
+    text: {type: string}
+  required: [text]
+`), 0644)
+	writePlugin(t, dir, "hello", `echo '{"name":"hello","description":"Say hello"}'`+"\n")
+	os.WriteFile(filepath.Join(dir, "broken.yaml"), []byte("name: bad name\ndescription: x\ncommand: ./echo.sh\n"), 0644)
+
+	plugins, err := loadPlugins(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var names []string
+	for _, p := range plugins {
+		names = append(names, p.Name)
+	}
+	if strings.Join(names, ",") != "echo,hello" {
+		t.Fatalf("Expected plugins echo and hello, got %v", names)
+	}
+	if plugins[0].timeout.String() != "2s" || len(plugins[0].schema.Required) != 1 {
+		t.Errorf("Expected the manifest timeout and schema, got %s and %v", plugins[0].timeout, plugins[0].schema.Required)
+	}
+	if plugins[1].schema.Type != "object" {
+		t.Errorf("Expected a plugin without a schema to take an empty object, got %q", plugins[1].schema.Type)
+	}
+
+	if plugins, err := loadPlugins(filepath.Join(dir, "missing")); err != nil || len(plugins) != 0 {
+		t.Errorf("Expected no plugins from a missing directory, got %v, %v", plugins, err)
+	}
+}
+
+// Test a plugin tool gets its arguments on stdin, a restricted environment and a timeout
+func TestPluginTools(t *testing.T) {
+	dir := t.TempDir()
+	writePlugin(t, dir, "env", `printf '{"text":"%s|%s"}' "$CLAUDE_API_KEY" "$PLUGIN_GREETING"`+"\n")
+	os.WriteFile(filepath.Join(dir, "env.yaml"), []byte("name: env\ndescription: Show the environment\ncommand: ./env\nenv: [PLUGIN_GREETING]\n"), 0644)
+	writePlugin(t, dir, "upper", `if [ "$1" = "--manifest" ]; then
+  echo '{"name":"upper","description":"Upper-case","input_schema":{"properties":{"text":{"type":"string"}},"required":["text"]}}'
+  exit 0
+fi
+tr a-z A-Z
+`)
+	writePlugin(t, dir, "fail", `if [ "$1" = "--manifest" ]; then echo '{"name":"fail","description":"Fail"}'; exit 0; fi
+echo '{"error":"no luck"}'
+`)
+	writePlugin(t, dir, "slow", `if [ "$1" = "--manifest" ]; then echo '{"name":"slow","description":"Sleep","timeout":"100ms"}'; exit 0; fi
+sleep 5
+`)
+	t.Setenv("CLAUDE_API_KEY", "secret")
+	t.Setenv("PLUGIN_GREETING", "hi")
+
+	server := &fakeToolServer{handlers: map[string]any{}}
+	if err := registerPlugins(newToolRegistry(server, defaultMiddlewares()...), dir); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	call := func(name string, args dynamicArguments) (string, error) {
+		handler, ok := server.handlers[name].(func(context.Context, dynamicArguments) (*mcp_golang.ToolResponse, error))
+		if !ok {
+			t.Fatalf("Expected plugin %s to be registered, got %T", name, server.handlers[name])
+		}
+		response, err := handler(context.Background(), args)
+		if err != nil {
+			return "", err
+		}
+		return response.Content[0].TextContent.Text, nil
+	}
+
+	if text, err := call("env", nil); err != nil || text != "|hi" {
+		t.Errorf("Expected only the listed variables to be passed on, got '%s', %v", text, err)
+	}
+	if text, err := call("upper", dynamicArguments{"text": "abc"}); err != nil || !strings.Contains(text, `"TEXT": "ABC"`) {
+		t.Errorf("Expected the arguments back as JSON, got '%s', %v", text, err)
+	}
+	if _, err := call("upper", dynamicArguments{}); err == nil || err.Error() != "upper: missing required argument text" {
+		t.Errorf("Expected a missing argument to be rejected, got %v", err)
+	}
+	if _, err := call("upper", dynamicArguments{"text": 3.0}); err == nil || !strings.Contains(err.Error(), "must be of type string") {
+		t.Errorf("Expected an argument of the wrong type to be rejected, got %v", err)
+	}
+	if _, err := call("fail", nil); err == nil || err.Error() != "plugin fail: no luck" {
+		t.Errorf("Expected the plugin's error, got %v", err)
+	}
+	if _, err := call("slow", nil); err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Errorf("Expected the plugin to time out, got %v", err)
+	}
+}
+
+// Test no directory is scanned for plugins unless PLUGINS_DIR is set
+func TestPluginsOptIn(t *testing.T) {
+	t.Setenv("PLUGINS_DIR", "")
+	if dir := pluginsDir(); dir != "" {
+		t.Errorf("Expected plugins to be off by default, got '%s'", dir)
+	}
+
+	server := &fakeToolServer{handlers: map[string]any{}}
+	if err := registerPlugins(newToolRegistry(server, defaultMiddlewares()...), pluginsDir()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(server.handlers) != 0 {
+		t.Errorf("Expected no plugins without PLUGINS_DIR, got %d tools", len(server.handlers))
+	}
+}
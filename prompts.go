@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	mcp_golang "github.com/metoro-io/mcp-golang"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultPromptsDir holds the prompt templates; PROMPTS_DIR overrides it
+const defaultPromptsDir = "prompts"
+
+// defaultPromptsReloadInterval is how often the prompts directory is checked for
+// changes; PROMPTS_RELOAD_INTERVAL overrides it
+const defaultPromptsReloadInterval = 2 * time.Second
+
+// Argument types a prompt template can declare. MCP passes every prompt argument
+// as a string; the declared type is validated and converted before rendering.
+const (
+	promptArgString  = "string"
+	promptArgNumber  = "number"
+	promptArgInteger = "integer"
+	promptArgBoolean = "boolean"
+	promptArgEnum    = "enum"
+)
+
+var promptNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+var promptArgNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// promptArgument is one argument declared in a template's front matter
+type promptArgument struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Type        string   `yaml:"type"`
+	Required    bool     `yaml:"required"`
+	Enum        []string `yaml:"enum"`
+	Default     string   `yaml:"default"`
+}
+
+// promptTemplate is a prompt loaded from a template file: YAML front matter
+// between "---" lines, followed by a text/template body rendered into a single
+// user message.
+//
+//	---
+//	description: Explain what a piece of code does
+//	arguments:
+//	  - name: code
+//	    required: true
+//	  - name: level
+//	    type: enum
+//	    enum: [beginner, expert]
+//	    default: beginner
+//	---
+//	Explain this code to a {{.level}}:
+//	{{.code}}
+type promptTemplate struct {
+	Name        string           `yaml:"name"`
+	Description string           `yaml:"description"`
+	Arguments   []promptArgument `yaml:"arguments"`
+
+	path     string
+	body     *template.Template
+	argsType reflect.Type
+}
+
+// parsePromptTemplate parses a template file; the prompt name defaults to the
+// file name without its extension
+func parsePromptTemplate(path string, data []byte) (*promptTemplate, error) {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	if !strings.HasPrefix(text, "---\n") {
+		return nil, fmt.Errorf("missing front matter: the file must start with a --- line")
+	}
+	header, body, ok := strings.Cut(text[len("---\n"):], "\n---\n")
+	if !ok {
+		return nil, fmt.Errorf("front matter is not closed with a --- line")
+	}
+
+	p := &promptTemplate{path: path}
+	if err := yaml.Unmarshal([]byte(header), p); err != nil {
+		return nil, fmt.Errorf("invalid front matter: %v", err)
+	}
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if !promptNamePattern.MatchString(p.Name) {
+		return nil, fmt.Errorf("invalid prompt name %q", p.Name)
+	}
+
+	seen := map[string]bool{}
+	var fields []reflect.StructField
+	for i := range p.Arguments {
+		arg := &p.Arguments[i]
+		if !promptArgNamePattern.MatchString(arg.Name) {
+			return nil, fmt.Errorf("invalid argument name %q", arg.Name)
+		}
+		if seen[strings.ToLower(arg.Name)] {
+			return nil, fmt.Errorf("argument %q is declared twice", arg.Name)
+		}
+		seen[strings.ToLower(arg.Name)] = true
+
+		if arg.Type == "" {
+			arg.Type = promptArgString
+		}
+		switch arg.Type {
+		case promptArgString, promptArgNumber, promptArgInteger, promptArgBoolean:
+		case promptArgEnum:
+			if len(arg.Enum) == 0 {
+				return nil, fmt.Errorf("argument %q is an enum without values", arg.Name)
+			}
+		default:
+			return nil, fmt.Errorf("argument %q has unknown type %q", arg.Name, arg.Type)
+		}
+		if arg.Default != "" {
+			if _, err := arg.convert(arg.Default); err != nil {
+				return nil, fmt.Errorf("argument %q has an invalid default: %v", arg.Name, err)
+			}
+		}
+
+		fields = append(fields, arg.structField())
+	}
+	p.argsType = reflect.StructOf(fields)
+
+	tmpl, err := template.New(p.Name).Option("missingkey=zero").Parse(strings.TrimLeft(body, "\n"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %v", err)
+	}
+	p.body = tmpl
+
+	return p, nil
+}
+
+// structField describes the argument to mcp-golang, which builds the advertised
+// prompt arguments from a struct: the field name is what clients see, the json
+// tag lets them send the name as written in the template.
+func (a promptArgument) structField() reflect.StructField {
+	description := a.Description
+	switch a.Type {
+	case promptArgEnum:
+		description = strings.TrimSpace(description + " (one of: " + strings.Join(a.Enum, " | ") + ")")
+	case promptArgString:
+	default:
+		description = strings.TrimSpace(description + " (" + a.Type + ")")
+	}
+	if a.Default != "" {
+		description += " (default: " + a.Default + ")"
+	}
+	// mcp-golang splits the jsonschema tag on commas, and quotes would end the tag
+	description = strings.NewReplacer(",", ";", `"`, "'", "\\", "/").Replace(description)
+	tag := "description=" + description
+	if a.Required {
+		tag = "required," + tag
+	}
+
+	return reflect.StructField{
+		Name: strings.ToUpper(a.Name[:1]) + a.Name[1:],
+		Type: reflect.TypeOf(""),
+		Tag:  reflect.StructTag(fmt.Sprintf(`json:"%s" jsonschema:"%s"`, a.Name, tag)),
+	}
+}
+
+// convert validates a raw argument value against the declared type
+func (a promptArgument) convert(raw string) (any, error) {
+	switch a.Type {
+	case promptArgNumber:
+		return strconv.ParseFloat(raw, 64)
+	case promptArgInteger:
+		return strconv.Atoi(raw)
+	case promptArgBoolean:
+		return strconv.ParseBool(raw)
+	case promptArgEnum:
+		for _, value := range a.Enum {
+			if raw == value {
+				return raw, nil
+			}
+		}
+		return nil, fmt.Errorf("%q is not one of %s", raw, strings.Join(a.Enum, ", "))
+	default:
+		return raw, nil
+	}
+}
+
+// render validates the arguments and executes the template body
+func (p *promptTemplate) render(values map[string]string) (string, error) {
+	data := map[string]any{}
+	for _, arg := range p.Arguments {
+		raw := strings.TrimSpace(values[arg.Name])
+		if raw == "" {
+			raw = arg.Default
+		}
+		if raw == "" {
+			if arg.Required {
+				return "", fmt.Errorf("argument %q is required", arg.Name)
+			}
+			data[arg.Name] = nil
+			continue
+		}
+
+		value, err := arg.convert(raw)
+		if err != nil {
+			return "", fmt.Errorf("argument %q: %v", arg.Name, err)
+		}
+		data[arg.Name] = value
+	}
+
+	var out bytes.Buffer
+	if err := p.body.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("rendering prompt %s: %v", p.Name, err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// handler builds a prompt handler whose argument struct matches the declared
+// arguments, so mcp-golang advertises them in prompts/list
+func (p *promptTemplate) handler() any {
+	fnType := reflect.FuncOf(
+		[]reflect.Type{p.argsType},
+		[]reflect.Type{reflect.TypeOf(&mcp_golang.PromptResponse{}), reflect.TypeOf((*error)(nil)).Elem()},
+		false,
+	)
+	return reflect.MakeFunc(fnType, func(in []reflect.Value) []reflect.Value {
+		values := map[string]string{}
+		for i, arg := range p.Arguments {
+			values[arg.Name] = in[0].Field(i).String()
+		}
+
+		text, err := p.render(values)
+		if err != nil {
+			return []reflect.Value{reflect.Zero(fnType.Out(0)), reflect.ValueOf(&err).Elem()}
+		}
+		response := mcp_golang.NewPromptResponse(p.Description, mcp_golang.NewPromptMessage(mcp_golang.NewTextContent(text), mcp_golang.RoleUser))
+		return []reflect.Value{reflect.ValueOf(response), reflect.Zero(fnType.Out(1))}
+	}).Interface()
+}
+
+// promptRegistry is the part of *mcp_golang.Server the prompt library needs
+type promptRegistry interface {
+	RegisterPrompt(name string, description string, handler any) error
+	DeregisterPrompt(name string) error
+}
+
+// promptLibrary keeps the registered prompts in sync with the templates directory
+type promptLibrary struct {
+	mu        sync.Mutex
+	dir       string
+	registry  promptRegistry
+	loaded    map[string]*promptTemplate
+	signature string
+}
+
+func newPromptLibrary(dir string, registry promptRegistry) *promptLibrary {
+	return &promptLibrary{dir: dir, registry: registry, loaded: map[string]*promptTemplate{}}
+}
+
+// promptsDir returns the templates directory from PROMPTS_DIR or the default
+func promptsDir() string {
+	return defaultString(os.Getenv("PROMPTS_DIR"), defaultPromptsDir)
+}
+
+// promptFiles lists the template files (*.md and *.tmpl) in the directory
+func (l *promptLibrary) promptFiles() ([]string, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if !entry.IsDir() && (ext == ".md" || ext == ".tmpl") {
+			files = append(files, filepath.Join(l.dir, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// dirSignature summarises names, sizes and modification times so changes can
+// be detected without re-parsing every file
+func (l *promptLibrary) dirSignature(files []string) string {
+	var b strings.Builder
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			fmt.Fprintf(&b, "%s:%d:%d;", file, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return b.String()
+}
+
+// reload parses the templates directory and registers, replaces or removes
+// prompts to match it. A file that fails to parse is logged and its previously
+// loaded version, if any, is kept. A missing directory means no prompts.
+func (l *promptLibrary) reload() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	files, err := l.promptFiles()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	signature := l.dirSignature(files)
+	if signature == l.signature {
+		return nil
+	}
+	l.signature = signature
+
+	next := map[string]*promptTemplate{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err == nil {
+			var p *promptTemplate
+			if p, err = parsePromptTemplate(file, data); err == nil {
+				if existing, dup := next[p.Name]; dup {
+					log.Printf("prompts: %s: prompt %q is already defined in %s, skipping", file, p.Name, existing.path)
+					continue
+				}
+				next[p.Name] = p
+				continue
+			}
+		}
+
+		log.Printf("prompts: %s: %v", file, err)
+		for name, previous := range l.loaded {
+			if previous.path == file {
+				if _, taken := next[name]; !taken {
+					next[name] = previous
+				}
+			}
+		}
+	}
+
+	for name := range l.loaded {
+		if _, ok := next[name]; !ok {
+			if err := l.registry.DeregisterPrompt(name); err != nil {
+				return err
+			}
+		}
+	}
+	for name, p := range next {
+		if l.loaded[name] == p {
+			continue
+		}
+		if err := l.registry.RegisterPrompt(name, p.Description, serverLifecycle.track(p.handler())); err != nil {
+			return fmt.Errorf("registering prompt %s: %v", name, err)
+		}
+	}
+	l.loaded = next
+	return nil
+}
+
+// watch reloads the templates whenever the directory changes, until ctx is done
+func (l *promptLibrary) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.reload(); err != nil {
+				log.Printf("prompts: reload failed: %v", err)
+			}
+		}
+	}
+}
+
+// names returns the loaded prompt names in order
+func (l *promptLibrary) names() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var names []string
+	for name := range l.loaded {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
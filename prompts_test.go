@@ -0,0 +1,156 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	mcp_golang "github.com/metoro-io/mcp-golang"
+)
+
+// fakePromptRegistry records registrations instead of talking to a client
+type fakePromptRegistry struct {
+	prompts map[string]any
+}
+
+func (r *fakePromptRegistry) RegisterPrompt(name string, description string, handler any) error {
+	r.prompts[name] = handler
+	return nil
+}
+
+func (r *fakePromptRegistry) DeregisterPrompt(name string) error {
+	delete(r.prompts, name)
+	return nil
+}
+
+const testPromptTemplate = `---
+description: Review a diff
+arguments:
+  - name: diff
+    required: true
+  - name: max_comments
+    type: integer
+    default: "3"
+  - name: tone
+    type: enum
+    enum: [friendly, strict]
+---
+Review this diff with at most {{.max_comments}} comments{{with .tone}} in a {{.}} tone{{end}}:
+{{.diff}}
+`
+
+// Test typed arguments are converted, defaulted and validated
+func TestPromptTemplateRender(t *testing.T) {
+	p, err := parsePromptTemplate("review.md", []byte(testPromptTemplate))
+	if err != nil {
+		t.Fatalf("Unexpected parse error: %v", err)
+	}
+	if p.Name != "review" {
+		t.Errorf("Expected the name to come from the file, got '%s'", p.Name)
+	}
+
+	text, err := p.render(map[string]string{"diff": "+x", "tone": "strict"})
+	if err != nil {
+		t.Fatalf("Unexpected render error: %v", err)
+	}
+	if text != "Review this diff with at most 3 comments in a strict tone:\n+x" {
+		t.Errorf("Unexpected rendered prompt '%s'", text)
+	}
+
+	if _, err := p.render(map[string]string{}); err == nil || !strings.Contains(err.Error(), "required") {
+		t.Errorf("Expected a missing required argument error, got %v", err)
+	}
+	if _, err := p.render(map[string]string{"diff": "+x", "max_comments": "lots"}); err == nil {
+		t.Errorf("Expected an error for a non-integer argument")
+	}
+	if _, err := p.render(map[string]string{"diff": "+x", "tone": "rude"}); err == nil {
+		t.Errorf("Expected an error for a value outside the enum")
+	}
+}
+
+// Test the handler advertises the declared arguments and renders a user message
+func TestPromptTemplateHandler(t *testing.T) {
+	p, err := parsePromptTemplate("review.md", []byte(testPromptTemplate))
+	if err != nil {
+		t.Fatalf("Unexpected parse error: %v", err)
+	}
+
+	handler := reflect.ValueOf(p.handler())
+	argsType := handler.Type().In(0)
+	if argsType.NumField() != 3 || argsType.Field(0).Name != "Diff" || !strings.Contains(argsType.Field(0).Tag.Get("jsonschema"), "required") {
+		t.Errorf("Unexpected argument struct %v", argsType)
+	}
+
+	args := reflect.New(argsType).Elem()
+	args.Field(0).SetString("+y")
+	out := handler.Call([]reflect.Value{args})
+	if !out[1].IsNil() {
+		t.Fatalf("Unexpected handler error: %v", out[1].Interface())
+	}
+	response := out[0].Interface().(*mcp_golang.PromptResponse)
+	if len(response.Messages) != 1 || !strings.HasSuffix(response.Messages[0].Content.TextContent.Text, "+y") {
+		t.Errorf("Unexpected prompt response %+v", response)
+	}
+}
+
+// Test malformed templates are rejected
+func TestParsePromptTemplateErrors(t *testing.T) {
+	cases := map[string]string{
+		"no front matter": "Hello",
+		"unknown type":    "---\narguments:\n  - name: x\n    type: date\n---\n{{.x}}",
+		"empty enum":      "---\narguments:\n  - name: x\n    type: enum\n---\n{{.x}}",
+		"bad template":    "---\ndescription: d\n---\n{{.x",
+	}
+	for name, data := range cases {
+		if _, err := parsePromptTemplate("p.md", []byte(data)); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
+
+// Test reload registers new prompts, keeps the last good version of a broken file and drops deleted ones
+func TestPromptLibraryReload(t *testing.T) {
+	dir := t.TempDir()
+	registry := &fakePromptRegistry{prompts: map[string]any{}}
+	library := newPromptLibrary(dir, registry)
+
+	path := filepath.Join(dir, "review.md")
+	os.WriteFile(path, []byte(testPromptTemplate), 0644)
+	if err := library.reload(); err != nil {
+		t.Fatalf("Unexpected reload error: %v", err)
+	}
+	if _, ok := registry.prompts["review"]; !ok {
+		t.Fatalf("Expected the review prompt to be registered")
+	}
+
+	os.WriteFile(path, []byte("broken"), 0644)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+	library.reload()
+	if _, ok := registry.prompts["review"]; !ok {
+		t.Errorf("Expected the last good version to stay registered")
+	}
+
+	os.Remove(path)
+	library.reload()
+	if len(registry.prompts) != 0 {
+		t.Errorf("Expected deleted prompts to be deregistered, got %v", registry.prompts)
+	}
+}
+
+// Test the prompts shipped in the repository parse
+func TestShippedPrompts(t *testing.T) {
+	registry := &fakePromptRegistry{prompts: map[string]any{}}
+	library := newPromptLibrary(defaultPromptsDir, registry)
+	if err := library.reload(); err != nil {
+		t.Fatalf("Unexpected reload error: %v", err)
+	}
+
+	for _, name := range []string{"compare_providers", "explain_code", "summarize_document"} {
+		if _, ok := registry.prompts[name]; !ok {
+			t.Errorf("Expected shipped prompt %s to load", name)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+const redactedPlaceholder = "[REDACTED]"
+
+// maxUpstreamErrorLen caps how much of an upstream error body is echoed back to clients
+const maxUpstreamErrorLen = 500
+
+// secretEnvVars lists the environment variables whose values must never leave the
+// server, along with their key pool lists (CLAUDE_API_KEYS)
+var secretEnvVars = []string{
+	"CLAUDE_API_KEY",
+	"OPENAI_API_KEY",
+	"GEMINI_API_KEY",
+	"MISTRAL_API_KEY",
+	"HUGGINGFACEHUB_API_TOKEN",
+}
+
+// secretPatterns matches key-shaped tokens even when we don't know the exact value.
+// Patterns with a capture group keep the group (e.g. "Bearer ") and redact the rest.
+// The sk- patterns only match at a token boundary and need a long unbroken run of
+// key characters, so words like "desk-organization-tips" are left alone.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(^|[^A-Za-z0-9_\-])sk-ant-[A-Za-z0-9_\-]{16,}`),
+	regexp.MustCompile(`(^|[^A-Za-z0-9_\-])sk-(?:proj-|svcacct-|admin-)?[A-Za-z0-9]{20,}[A-Za-z0-9_\-]*`),
+	regexp.MustCompile(`AIza[0-9A-Za-z_\-]{30,}`),
+	regexp.MustCompile(`hf_[A-Za-z0-9]{20,}`),
+	regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._\-]{16,}`),
+	regexp.MustCompile(`(?i)([?&](?:key|api_key|apikey|access_token|token)=)[^&\s"']+`),
+	regexp.MustCompile(`(?i)((?:x-api-key|x-goog-api-key)["']?\s*[:=]\s*["']?)[A-Za-z0-9._\-]{8,}`),
+}
+
+// secretValues returns the configured secrets worth scrubbing. Very short values
+// are skipped so that placeholders like "x" don't shred unrelated text.
+func secretValues() []string {
+	var values []string
+	for _, name := range secretEnvVars {
+		for _, v := range configuredKeys(name) {
+			if len(v) >= 8 {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+// redactSecrets scrubs known secrets and key-shaped tokens from s
+func redactSecrets(s string) string {
+	for _, secret := range secretValues() {
+		s = strings.ReplaceAll(s, secret, redactedPlaceholder)
+	}
+	for _, re := range secretPatterns {
+		if re.NumSubexp() > 0 {
+			s = re.ReplaceAllString(s, "${1}"+redactedPlaceholder)
+		} else {
+			s = re.ReplaceAllString(s, redactedPlaceholder)
+		}
+	}
+	return s
+}
+
+// redactedError carries a scrubbed message while keeping the original error
+// reachable through errors.Is/As for code that runs inside the server
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// redactError returns err with any secrets removed from its message
+func redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*redactedError); ok {
+		return err
+	}
+	return &redactedError{msg: redactSecrets(err.Error()), err: err}
+}
+
+// upstreamError builds the error returned when a provider answers with a non-200
+// status. It prefers the provider's own error message over the raw body, and never
+// echoes more than maxUpstreamErrorLen characters.
+func upstreamError(provider string, status int, body []byte) error {
+	msg := upstreamErrorMessage(body)
+	if runes := []rune(msg); len(runes) > maxUpstreamErrorLen {
+		msg = string(runes[:maxUpstreamErrorLen]) + "..."
+	}
+	return redactError(&apiError{Provider: provider, StatusCode: status, Message: msg})
+}
+
+// apiError is a non-200 answer from a provider API
+type apiError struct {
+	Provider   string
+	StatusCode int
+	Message    string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s API error %d: %s", e.Provider, e.StatusCode, e.Message)
+}
+
+// upstreamErrorMessage extracts the human readable message from the usual
+// {"error": {"message": ...}} or {"error": "..."} shapes, falling back to the body
+func upstreamErrorMessage(body []byte) string {
+	var parsed struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+		Detail  string          `json:"detail"`
+	}
+	if json.Unmarshal(body, &parsed) == nil {
+		var nested struct {
+			Message string `json:"message"`
+		}
+		var flat string
+		switch {
+		case json.Unmarshal(parsed.Error, &nested) == nil && nested.Message != "":
+			return nested.Message
+		case json.Unmarshal(parsed.Error, &flat) == nil && flat != "":
+			return flat
+		case parsed.Message != "":
+			return parsed.Message
+		case parsed.Detail != "":
+			return parsed.Detail
+		}
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// redactingWriter scrubs secrets from everything written through it; main wires
+// it under the standard logger so log lines can't leak keys to stderr
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (rw *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, redactSecrets(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
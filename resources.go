@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	mcp_golang "github.com/metoro-io/mcp-golang"
+)
+
+// Resource URIs and templates exposed by the server
+const (
+	usageTodayURI          = "usage://today"
+	effectiveConfigURI     = "config://effective"
+	providerMetricsURI     = "metrics://providers"
+	historySessionsURI     = "history://sessions"
+	historySessionTemplate = "history://sessions/{id}"
+	cepCacheTemplate       = "cache://cep/{cep}"
+)
+
+// resourceRegistry is the part of *mcp_golang.Server the resources need
+type resourceRegistry interface {
+	RegisterResource(uri string, name string, description string, mimeType string, handler any) error
+	DeregisterResource(uri string) error
+	RegisterResourceTemplate(uriTemplate string, name string, description string, mimeType string) error
+}
+
+// onCepCached is called after a zip code lookup is stored in the cache
+var onCepCached func(cep string)
+
+// registerResources exposes history, usage, configuration and the zip code cache
+// as read-only resources. mcp-golang only reads resources by exact URI, so each
+// history session and cached zip code is registered as it appears, which also
+// sends resources/list_changed to the client.
+func registerResources(server resourceRegistry) error {
+	static := []struct {
+		uri, name, description string
+		handler                func() (*mcp_golang.ResourceResponse, error)
+	}{
+		{usageTodayURI, "Usage today", "Requests, errors and tokens per provider for the current UTC day, plus per-key usage since the server started", func() (*mcp_golang.ResourceResponse, error) {
+			return jsonResource(usageTodayURI, map[string]interface{}{
+				"date":      time.Now().UTC().Format("2006-01-02"),
+				"providers": usageStats.today(),
+				"keys":      keyPools.usage(),
+			})
+		}},
+		{effectiveConfigURI, "Effective configuration", "Settings in effect after defaults, .env and environment; API keys are reported as set or not, never their values", func() (*mcp_golang.ResourceResponse, error) {
+			return jsonResource(effectiveConfigURI, effectiveConfig())
+		}},
+		{providerMetricsURI, "Provider metrics", "Circuit breaker state, recent latency percentiles and today's usage per provider", func() (*mcp_golang.ResourceResponse, error) {
+			return jsonResource(providerMetricsURI, providerMetrics())
+		}},
+		{historySessionsURI, "History sessions", "Every recorded history session, including earlier runs, with its exchange count", func() (*mcp_golang.ResourceResponse, error) {
+			return jsonResource(historySessionsURI, historySessionIndex())
+		}},
+	}
+	for _, r := range static {
+		if err := server.RegisterResource(r.uri, r.name, r.description, "application/json", serverLifecycle.track(r.handler)); err != nil {
+			return err
+		}
+	}
+
+	if err := server.RegisterResourceTemplate(historySessionTemplate, "History session", "Questions and answers recorded under one session_id", "application/json"); err != nil {
+		return err
+	}
+	if err := server.RegisterResourceTemplate(cepCacheTemplate, "Cached zip code", "A cached zip code lookup", "application/json"); err != nil {
+		return err
+	}
+
+	for _, id := range conversationHistory.sessionIDs() {
+		if err := registerSessionResource(server, id); err != nil {
+			return err
+		}
+	}
+	conversationHistory.onNewSession = func(id string) {
+		if err := registerSessionResource(server, id); err != nil {
+			log.Printf("resources: registering session %s: %v", id, err)
+		}
+	}
+
+	cepResources := &cepResourceSet{server: server, registered: map[string]bool{}}
+	for _, cep := range cachedCeps() {
+		if err := cepResources.add(cep); err != nil {
+			return err
+		}
+	}
+	onCepCached = func(cep string) {
+		if err := cepResources.add(cep); err != nil {
+			log.Printf("resources: registering zip code %s: %v", cep, err)
+		}
+	}
+
+	return nil
+}
+
+func registerSessionResource(server resourceRegistry, id string) error {
+	uri := historySessionsURI + "/" + id
+	return server.RegisterResource(uri, "History session "+id, "Questions and answers recorded under session "+id, "application/json", serverLifecycle.track(func() (*mcp_golang.ResourceResponse, error) {
+		session, ok := conversationHistory.session(id)
+		if !ok {
+			return nil, fmt.Errorf("unknown history session %q", id)
+		}
+		return jsonResource(uri, session)
+	}))
+}
+
+// cepResourceSet registers each cached zip code once and drops it once the cache expires
+type cepResourceSet struct {
+	mu         sync.Mutex
+	server     resourceRegistry
+	registered map[string]bool
+}
+
+func (s *cepResourceSet) add(cep string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.registered[cep] {
+		return nil
+	}
+
+	uri := "cache://cep/" + cep
+	err := s.server.RegisterResource(uri, "Zip code "+cep, "Cached lookup for zip code "+cep, "application/json", serverLifecycle.track(func() (*mcp_golang.ResourceResponse, error) {
+		cached := getFromCache(cep)
+		if cached == "" {
+			s.mu.Lock()
+			delete(s.registered, cep)
+			s.mu.Unlock()
+			s.server.DeregisterResource(uri)
+			return nil, fmt.Errorf("zip code %s is no longer cached", cep)
+		}
+		return mcp_golang.NewResourceResponse(mcp_golang.NewTextEmbeddedResource(uri, cached, "application/json")), nil
+	}))
+	if err != nil {
+		return err
+	}
+	s.registered[cep] = true
+	return nil
+}
+
+// cachedCeps lists the zip codes with a cache file that hasn't expired yet
+func cachedCeps() []string {
+	files, _ := filepath.Glob(getCacheFilename("*"))
+	var ceps []string
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil || info.IsDir() || time.Since(info.ModTime()) > cacheTime*time.Second {
+			continue
+		}
+		ceps = append(ceps, strings.TrimPrefix(filepath.Base(file), "cep"))
+	}
+	return ceps
+}
+
+// historySessionIndex summarises every session for history://sessions
+func historySessionIndex() []map[string]interface{} {
+	index := []map[string]interface{}{}
+	for _, id := range conversationHistory.sessionIDs() {
+		session, ok := conversationHistory.session(id)
+		if !ok {
+			continue
+		}
+		index = append(index, map[string]interface{}{
+			"id":      id,
+			"uri":     historySessionsURI + "/" + id,
+			"started": session.Started,
+			"updated": session.Updated,
+			"entries": len(session.Entries),
+		})
+	}
+	return index
+}
+
+// effectiveConfig reports the settings in effect, without secret values
+func effectiveConfig() map[string]interface{} {
+	keys := map[string]interface{}{}
+	for _, name := range providerNames() {
+		p := providers[name]
+		keys[name] = map[string]interface{}{"key_env": p.KeyEnv, "key_set": hasAPIKey(p.KeyEnv), "keys": len(configuredKeys(p.KeyEnv))}
+	}
+
+	return map[string]interface{}{
+		"providers": keys,
+		"settings": map[string]interface{}{
+			"BREAKER_COOLDOWN":        durationFromEnv("BREAKER_COOLDOWN", defaultBreakerCooldown).String(),
+			"BREAKER_ERROR_RATE":      floatFromEnv("BREAKER_ERROR_RATE", defaultBreakerErrorRate),
+			"BREAKER_FAILURES":        intFromEnv("BREAKER_FAILURES", defaultBreakerFailures),
+			"BREAKER_WINDOW":          intFromEnv("BREAKER_WINDOW", defaultBreakerWindow),
+			"CONTEXT_PREFLIGHT":       defaultString(os.Getenv("CONTEXT_PREFLIGHT"), "on"),
+			"HEDGE_DELAY":             durationFromEnv("HEDGE_DELAY", defaultHedgeDelay).String(),
+			"HEDGE_PERCENTILE":        floatFromEnv("HEDGE_PERCENTILE", defaultHedgePercentile),
+			"HISTORY_PATH":            defaultString(historyPath(), "off"),
+			"JOB_CONCURRENCY":         max(intFromEnv("JOB_CONCURRENCY", defaultJobConcurrency), 1),
+			"JOB_RETENTION":           durationFromEnv("JOB_RETENTION", defaultJobRetention).String(),
+			"KEY_BENCH_DURATION":      durationFromEnv("KEY_BENCH_DURATION", defaultKeyBenchDuration).String(),
+			"KEY_ROTATION":            defaultString(os.Getenv("KEY_ROTATION"), keyRotationRoundRobin),
+			"MCP_DRAIN_TIMEOUT":       durationFromEnv("MCP_DRAIN_TIMEOUT", defaultDrainTimeout).String(),
+			"MCP_STATE_DIR":           stateDir(),
+			"MODELS_CACHE_TTL":        modelsCacheTTL().String(),
+			"OPENAPI_SERVICES":        os.Getenv("OPENAPI_SERVICES"),
+			"PII_PATTERNS":            os.Getenv("PII_PATTERNS"),
+			"PII_REDACTION":           defaultString(os.Getenv("PII_REDACTION"), "off"),
+			"PLUGINS_DIR":             defaultString(pluginsDir(), "off"),
+			"PLUGIN_TIMEOUT":          durationFromEnv("PLUGIN_TIMEOUT", defaultPluginTimeout).String(),
+			"TOOLS_DISABLED":          os.Getenv("TOOLS_DISABLED"),
+			"TOOLS_ENABLED":           os.Getenv("TOOLS_ENABLED"),
+			"PROVIDER_STATUS_TTL":     durationFromEnv("PROVIDER_STATUS_TTL", defaultStatusCacheTTL).String(),
+			"PROVIDER_STATUS_TIMEOUT": durationFromEnv("PROVIDER_STATUS_TIMEOUT", defaultStatusTimeout).String(),
+			"PROMPTS_DIR":             promptsDir(),
+			"PROMPTS_RELOAD_INTERVAL": durationFromEnv("PROMPTS_RELOAD_INTERVAL", defaultPromptsReloadInterval).String(),
+			"GEMINI_SAFETY_SETTINGS":  os.Getenv("GEMINI_SAFETY_SETTINGS"),
+			"ROUTING_RULES":           os.Getenv("ROUTING_RULES"),
+			"zipcode_cache_ttl":       (cacheTime * time.Second).String(),
+		},
+		"history_session": conversationHistory.defaultSession,
+	}
+}
+
+// providerMetrics reports each provider's breaker, latency and usage
+func providerMetrics() map[string]interface{} {
+	today := usageStats.today()
+	metrics := map[string]interface{}{}
+	for _, name := range providerNames() {
+		latency := map[string]int64{}
+		for _, p := range []float64{50, 95, 99} {
+			if d, ok := providerLatency.percentile(name, p); ok {
+				latency[fmt.Sprintf("p%.0f", p)] = d.Milliseconds()
+			}
+		}
+		metrics[name] = map[string]interface{}{
+			"breaker":     providerBreakers.state(name),
+			"latency_ms":  latency,
+			"usage_today": today[name],
+		}
+	}
+	return map[string]interface{}{"providers": metrics}
+}
+
+// jsonResource renders v as an indented JSON resource, scrubbing anything that looks like a secret
+func jsonResource(uri string, v interface{}) (*mcp_golang.ResourceResponse, error) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return mcp_golang.NewResourceResponse(mcp_golang.NewTextEmbeddedResource(uri, redactSecrets(string(out)), "application/json")), nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	mcp_golang "github.com/metoro-io/mcp-golang"
+)
+
+// fakeResourceRegistry keeps registered resource handlers so tests can read them
+type fakeResourceRegistry struct {
+	resources map[string]any
+	templates []string
+}
+
+func (r *fakeResourceRegistry) RegisterResource(uri string, name string, description string, mimeType string, handler any) error {
+	r.resources[uri] = handler
+	return nil
+}
+
+func (r *fakeResourceRegistry) DeregisterResource(uri string) error {
+	delete(r.resources, uri)
+	return nil
+}
+
+func (r *fakeResourceRegistry) RegisterResourceTemplate(uriTemplate string, name string, description string, mimeType string) error {
+	r.templates = append(r.templates, uriTemplate)
+	return nil
+}
+
+// read calls a registered resource handler and returns its text
+func (r *fakeResourceRegistry) read(t *testing.T, uri string) string {
+	handler, ok := r.resources[uri]
+	if !ok {
+		t.Fatalf("Resource %s is not registered", uri)
+	}
+	out := reflect.ValueOf(handler).Call(nil)
+	if !out[1].IsNil() {
+		t.Fatalf("Unexpected error reading %s: %v", uri, out[1].Interface())
+	}
+	return out[0].Interface().(*mcp_golang.ResourceResponse).Contents[0].TextResourceContents.Text
+}
+
+// Test static resources are registered and new history sessions appear as resources
+func TestRegisterResources(t *testing.T) {
+	t.Setenv("CLAUDE_API_KEY", "sk-ant-REDACTED")
+	history := useHistory(t)
+	t.Cleanup(func() { onCepCached = nil })
+
+	registry := &fakeResourceRegistry{resources: map[string]any{}}
+	if err := registerResources(registry); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, uri := range []string{usageTodayURI, effectiveConfigURI, historySessionsURI} {
+		if _, ok := registry.resources[uri]; !ok {
+			t.Errorf("Expected %s to be registered", uri)
+		}
+	}
+
+	config := registry.read(t, effectiveConfigURI)
+	if strings.Contains(config, "resource-test-secret") || !strings.Contains(config, `"key_set": true`) {
+		t.Errorf("Expected key presence without the key itself, got %s", config)
+	}
+
+	history.record(HistoryEntry{SessionID: "review", Provider: "claude", Question: "q", Answer: "a", Timestamp: time.Now()})
+	session := registry.read(t, "history://sessions/review")
+	if !strings.Contains(session, `"answer": "a"`) {
+		t.Errorf("Expected the session entries, got %s", session)
+	}
+	if index := registry.read(t, historySessionsURI); !strings.Contains(index, "history://sessions/review") {
+		t.Errorf("Expected the session in the index, got %s", index)
+	}
+}
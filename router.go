@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Question categories ask_best routes on
+const (
+	categoryLongContext = "long_context"
+	categoryCode        = "code"
+	categoryMath        = "math"
+	categoryCreative    = "creative"
+	categoryPortuguese  = "portuguese"
+	categoryGeneral     = "general"
+)
+
+// routeCategories lists the categories in the order they are checked
+var routeCategories = []string{categoryLongContext, categoryCode, categoryMath, categoryCreative, categoryPortuguese, categoryGeneral}
+
+// longContextTokens is the estimated size from which a question counts as long-context
+const longContextTokens = 8000
+
+// neutralLatencyScore is used for providers with no observed latency yet
+const neutralLatencyScore = 0.5
+
+// routeClaudeModel is the Claude model ask_best sends code, creative and
+// long-context questions to
+const routeClaudeModel = "claude-sonnet-4-5"
+
+var (
+	codeSyntaxPattern    = regexp.MustCompile(`:=|=>|\(\);|\{\s*\}|#include|</?[a-z]+>`)
+	codeOperatorPattern  = regexp.MustCompile("`[^`\n]*(?:->|==|!=)[^`\n]*`|[A-Za-z_][A-Za-z0-9_]*->[A-Za-z_]|[A-Za-z_][A-Za-z0-9_.]*\\s*(?:==|!=)\\s*[A-Za-z_][A-Za-z0-9_]*")
+	codeKeywordPattern   = wordsPattern("func", "def", "struct", "goroutine", "golang", "python", "javascript", "typescript", "java", "rust", "sql", "regex", "compile", "compiler", "stack trace", "exception", "segfault", "refactor", "unit test", "dockerfile", "kubernetes", "código", "função", "compilar")
+	mathExprPattern      = regexp.MustCompile(`\d+(?:\.\d+)?\s*[+*/^=<>]\s*\(?\d+|\d+\s+-\s+\d+`)
+	mathKeywordPattern   = wordsPattern("integral", "derivative", "equation", "theorem", "proof", "prove", "probability", "matrix", "logarithm", "sqrt", "calculate", "solve for", "equação", "derivada", "teorema", "probabilidade", "matriz", "calcule", "quanto é")
+	creativeWordsPattern = wordsPattern("poem", "story", "haiku", "lyrics", "song", "slogan", "tagline", "fiction", "limerick", "screenplay", "fairy tale", "poema", "poesia", "história", "conto", "letra de música", "crônica", "roteiro")
+)
+
+// wordsPattern matches any of the words case-insensitively, as whole words even
+// when they start or end with accented letters
+func wordsPattern(words ...string) *regexp.Regexp {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = regexp.QuoteMeta(w)
+	}
+	return regexp.MustCompile(`(?i)(?:^|[^\p{L}\p{N}_])(` + strings.Join(quoted, "|") + `)(?:$|[^\p{L}\p{N}_])`)
+}
+
+type AskBestArguments struct {
+	Question     string `json:"question" jsonschema:"required,description=The question to answer"`
+	Category     string `json:"category" jsonschema:"description=Skip classification and route as long_context\\, code\\, math\\, creative\\, portuguese or general"`
+	MaxTokens    int    `json:"max_tokens" jsonschema:"description=Maximum tokens in the answer (default: the provider's default)"`
+	AutoContinue bool   `json:"auto_continue" jsonschema:"description=Automatically continue the answer if it is cut off by the token limit"`
+	DryRun       bool   `json:"dry_run" jsonschema:"description=Only report the route that would be taken\\, without asking"`
+	SessionID    string `json:"session_id" jsonschema:"description=History session to record this exchange under (default: this server run's session)"`
+}
+
+// routeCandidate is one provider and model a category can be routed to
+type routeCandidate struct {
+	Provider string  `yaml:"provider"`
+	Model    string  `yaml:"model"`
+	Cost     float64 `yaml:"cost"` // USD per million tokens, blended input and output
+}
+
+// routingWeights sets how much each factor counts in a candidate's score
+type routingWeights struct {
+	Preference float64 `yaml:"preference"` // the candidate's position in its route
+	Cost       float64 `yaml:"cost"`
+	Latency    float64 `yaml:"latency"`
+	Health     float64 `yaml:"health"`
+}
+
+// routingRules maps each category to its candidates, most preferred first
+type routingRules struct {
+	Weights routingWeights              `yaml:"weights"`
+	Routes  map[string][]routeCandidate `yaml:"routes"`
+}
+
+// defaultRoutingRules are used for categories the ROUTING_RULES file doesn't set
+func defaultRoutingRules() routingRules {
+	sonnet := routeCandidate{Provider: "claude", Model: routeClaudeModel, Cost: 3}
+	gpt4o := routeCandidate{Provider: "openai", Model: "gpt-4o", Cost: 2.5}
+	flash := routeCandidate{Provider: "gemini", Model: defaultGeminiModel, Cost: 0.3}
+
+	return routingRules{
+		Weights: routingWeights{Preference: 1, Cost: 1, Latency: 1, Health: 2},
+		Routes: map[string][]routeCandidate{
+			categoryLongContext: {flash, sonnet},
+			categoryCode:        {sonnet, gpt4o, {Provider: "mistral", Model: "codestral-latest", Cost: 0.3}},
+			categoryMath:        {gpt4o, flash, sonnet},
+			categoryCreative:    {sonnet, gpt4o, {Provider: "mistral", Model: "mistral-large-latest", Cost: 2}},
+			categoryPortuguese:  {flash, sonnet, {Provider: "openai", Model: "gpt-4o-mini", Cost: 0.15}},
+			categoryGeneral: {
+				{Provider: "claude", Model: defaultClaudeModel, Cost: 0.25},
+				{Provider: "openai", Model: "gpt-4o-mini", Cost: 0.15},
+				flash,
+				{Provider: "mistral", Model: defaultMistralModel, Cost: 0.25},
+			},
+		},
+	}
+}
+
+// loadRoutingRules reads the YAML file named by ROUTING_RULES over the defaults.
+// Weights it sets replace the default ones; categories it lists replace that
+// category's route and the others keep theirs.
+func loadRoutingRules() (routingRules, error) {
+	rules := defaultRoutingRules()
+	path := os.Getenv("ROUTING_RULES")
+	if path == "" {
+		return rules, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return rules, fmt.Errorf("reading routing rules: %v", err)
+	}
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return rules, fmt.Errorf("parsing routing rules %s: %v", path, err)
+	}
+
+	for category, candidates := range rules.Routes {
+		if !validCategory(category) {
+			return rules, fmt.Errorf("routing rules %s: unknown category %q", path, category)
+		}
+		if len(candidates) == 0 {
+			return rules, fmt.Errorf("routing rules %s: category %q has no candidates", path, category)
+		}
+		for _, c := range candidates {
+			if _, ok := providers[c.Provider]; !ok {
+				return rules, fmt.Errorf("routing rules %s: unknown provider %q in %s", path, c.Provider, category)
+			}
+		}
+	}
+	return rules, nil
+}
+
+func validCategory(category string) bool {
+	for _, c := range routeCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyQuestion picks the question's category and says why. Categories are
+// checked in routeCategories order, so a long Portuguese question about code is
+// long_context and a short one is code.
+func classifyQuestion(question string) (string, string) {
+	if tokens := estimateTokens(question); tokens >= longContextTokens {
+		return categoryLongContext, fmt.Sprintf("about %d tokens, over the %d-token long-context threshold", tokens, longContextTokens)
+	}
+
+	if fencedCodePattern.MatchString(question) {
+		return categoryCode, "contains a code block"
+	}
+	if syntax := codeSyntaxPattern.FindString(question); syntax != "" {
+		return categoryCode, fmt.Sprintf("contains code syntax (%s)", syntax)
+	}
+	if operator := codeOperatorPattern.FindString(question); operator != "" {
+		return categoryCode, fmt.Sprintf("contains code syntax (%s)", operator)
+	}
+	// a story about java island is creative: bare keywords only mean code when
+	// nothing asks for creative writing
+	creative := uniqueMatches(creativeWordsPattern, question)
+	if keywords := uniqueMatches(codeKeywordPattern, question); len(keywords) > 0 && len(creative) == 0 {
+		return categoryCode, "mentions " + strings.Join(keywords, ", ")
+	}
+
+	if expr := mathExprPattern.FindString(question); expr != "" {
+		return categoryMath, fmt.Sprintf("contains an arithmetic expression (%s)", expr)
+	}
+	if words := uniqueMatches(mathKeywordPattern, question); len(words) > 0 {
+		return categoryMath, "mentions " + strings.Join(words, ", ")
+	}
+
+	if len(creative) > 0 {
+		return categoryCreative, "asks for " + strings.Join(creative, ", ")
+	}
+
+	if detectLanguage(question) == "pt" {
+		return categoryPortuguese, "written in Portuguese"
+	}
+	return categoryGeneral, "no specialised category matched"
+}
+
+// uniqueMatches returns the distinct lowercase words a wordsPattern found in text
+func uniqueMatches(re *regexp.Regexp, text string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, match := range re.FindAllStringSubmatch(text, -1) {
+		m := strings.ToLower(match[1])
+		if !seen[m] {
+			seen[m] = true
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// RouteScore is how one candidate fared
+type RouteScore struct {
+	Provider  string
+	Model     string
+	Score     float64
+	Cost      float64
+	LatencyMS int64 // median of recent calls; 0 when none were observed
+	Health    float64
+	Skipped   string // why the candidate was ruled out, if it was
+}
+
+func (s RouteScore) label() string {
+	return s.Provider + "/" + s.Model
+}
+
+// routeDecision is the category picked for a question and its scored candidates, best first
+type routeDecision struct {
+	Category   string
+	Reason     string
+	Candidates []RouteScore
+	Fallbacks  []string
+	Chosen     *RouteScore
+}
+
+func (d *routeDecision) String() string {
+	var b strings.Builder
+	if d.Chosen != nil {
+		fmt.Fprintf(&b, "route: %s -> %s (%s)", d.Category, d.Chosen.Provider, d.Chosen.Model)
+	} else {
+		fmt.Fprintf(&b, "route: %s", d.Category)
+	}
+	fmt.Fprintf(&b, "\nwhy: classified as %s (%s)", d.Category, d.Reason)
+	if c := d.Chosen; c != nil {
+		latency := "no latency observed yet"
+		if c.LatencyMS > 0 {
+			latency = fmt.Sprintf("median latency %dms", c.LatencyMS)
+		}
+		fmt.Fprintf(&b, "; %s scored %.2f: cost $%.2f/M tokens, %s, health %.0f%%", c.Provider, c.Score, c.Cost, latency, c.Health*100)
+	}
+
+	var candidates []string
+	for _, c := range d.Candidates {
+		if c.Skipped != "" {
+			candidates = append(candidates, fmt.Sprintf("%s skipped (%s)", c.label(), c.Skipped))
+		} else {
+			candidates = append(candidates, fmt.Sprintf("%s %.2f", c.label(), c.Score))
+		}
+	}
+	b.WriteString("\ncandidates: " + strings.Join(candidates, "; "))
+	for _, f := range d.Fallbacks {
+		b.WriteString("\nfallback: " + f)
+	}
+	return b.String()
+}
+
+// route classifies the question and scores the category's candidates by their
+// position in the route, cost, observed latency and current health. Candidates
+// without a key, with a failing status check or too small a context window are skipped.
+func route(question, category string, maxTokens int) (*routeDecision, error) {
+	rules, err := loadRoutingRules()
+	if err != nil {
+		return nil, err
+	}
+
+	decision := &routeDecision{}
+	if category = strings.ToLower(strings.TrimSpace(category)); category != "" {
+		if !validCategory(category) {
+			return nil, fmt.Errorf("unknown category %q: use %s", category, strings.Join(routeCategories, ", "))
+		}
+		decision.Category, decision.Reason = category, "requested"
+	} else {
+		decision.Category, decision.Reason = classifyQuestion(question)
+	}
+
+	candidates := rules.Routes[decision.Category]
+	maxCost, maxLatency := 0.0, int64(0)
+	scores := make([]RouteScore, len(candidates))
+	for i, c := range candidates {
+		p := providers[c.Provider]
+		model := c.Model
+		if model == "" && p.Model != nil {
+			model = p.Model(completionRequest{})
+		}
+		s := RouteScore{Provider: c.Provider, Model: model, Cost: c.Cost, Health: providerHealth(c.Provider)}
+		if latency, ok := providerLatency.percentile(c.Provider, 50); ok {
+			s.LatencyMS = latency.Milliseconds()
+		}
+		s.Skipped = skipReason(c.Provider, model, question, defaultInt(maxTokens, p.DefaultMaxTokens))
+
+		if c.Cost > maxCost {
+			maxCost = c.Cost
+		}
+		if s.LatencyMS > maxLatency {
+			maxLatency = s.LatencyMS
+		}
+		scores[i] = s
+	}
+
+	w := rules.Weights
+	total := w.Preference + w.Cost + w.Latency + w.Health
+	for i := range scores {
+		s := &scores[i]
+		preference := 1 - float64(i)/float64(len(scores))
+		cost := 1.0
+		if maxCost > 0 {
+			cost = 1 - s.Cost/maxCost
+		}
+		latency := neutralLatencyScore
+		if s.LatencyMS > 0 && maxLatency > 0 {
+			latency = 1 - float64(s.LatencyMS)/float64(maxLatency)
+		}
+		if total > 0 {
+			s.Score = (w.Preference*preference + w.Cost*cost + w.Latency*latency + w.Health*s.Health) / total
+		}
+	}
+
+	sort.SliceStable(scores, func(i, j int) bool {
+		if (scores[i].Skipped == "") != (scores[j].Skipped == "") {
+			return scores[i].Skipped == ""
+		}
+		return scores[i].Score > scores[j].Score
+	})
+	decision.Candidates = scores
+	return decision, nil
+}
+
+// providerHealth is the share of today's calls to the provider that succeeded,
+// or 1 when there have been too few to judge
+func providerHealth(name string) float64 {
+	totals := usageStats.day(time.Now().UTC().Format("2006-01-02"))[name]
+	if totals.Requests < 3 {
+		return 1
+	}
+	return 1 - float64(totals.Errors)/float64(totals.Requests)
+}
+
+// skipReason says why a candidate can't take the question, or "" when it can
+func skipReason(name, model, question string, reserved int) string {
+	if !hasAPIKey(providers[name].KeyEnv) {
+		return "no API key"
+	}
+	if providerBreakers.state(name).State == breakerOpen {
+		return "circuit breaker open"
+	}
+	if !providerAvailable(name) {
+		entry, _ := providerStatusCache.get(name)
+		return "status " + entry.Status
+	}
+	if window := contextWindow(name, model); window > 0 && estimateMessageTokens(userMessage(question))+reserved > window {
+		return fmt.Sprintf("question doesn't fit the %d-token context window", window)
+	}
+	return ""
+}
+
+// askBest routes the question and asks the best candidate, falling back to the
+// next one when a call fails
+func askBest(ctx context.Context, args AskBestArguments) (*completion, *routeDecision, error) {
+	if strings.TrimSpace(args.Question) == "" {
+		return nil, nil, fmt.Errorf("question is required")
+	}
+	if err := validateSessionID(args.SessionID); err != nil {
+		return nil, nil, err
+	}
+	decision, err := route(args.Question, args.Category, args.MaxTokens)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if args.DryRun {
+		for i := range decision.Candidates {
+			if decision.Candidates[i].Skipped == "" {
+				decision.Chosen = &decision.Candidates[i]
+				break
+			}
+		}
+		return nil, decision, nil
+	}
+
+	for i := range decision.Candidates {
+		c := &decision.Candidates[i]
+		if c.Skipped != "" {
+			continue
+		}
+		req := completionRequest{Model: c.Model, Messages: userMessage(args.Question), MaxTokens: args.MaxTokens}
+		result, err := askAndRecord(ctx, args.SessionID, c.Provider, req, continueOptions{Auto: args.AutoContinue})
+		if err == nil {
+			decision.Chosen = c
+			return result, decision, nil
+		}
+		if ctx.Err() != nil {
+			return nil, decision, ctx.Err()
+		}
+		decision.Fallbacks = append(decision.Fallbacks, fmt.Sprintf("%s failed: %v", c.label(), redactError(err)))
+	}
+
+	if len(decision.Fallbacks) > 0 {
+		return nil, decision, fmt.Errorf("every %s candidate failed:\n%s", decision.Category, strings.Join(decision.Fallbacks, "\n"))
+	}
+	return nil, decision, fmt.Errorf("no provider available for %s questions\n%s", decision.Category, decision.String())
+}
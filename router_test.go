@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useRoutingState gives the test fresh status, usage and latency data
+func useRoutingState(t *testing.T) {
+	status, usage, latency := providerStatusCache, usageStats, providerLatency
+	providerStatusCache = &statusCache{entries: map[string]ProviderStatus{}}
+	usageStats = newUsageTracker(filepath.Join(t.TempDir(), "usage.json"))
+	providerLatency = newLatencyTracker()
+	t.Cleanup(func() { providerStatusCache, usageStats, providerLatency = status, usage, latency })
+
+	for _, name := range providerNames() {
+		t.Setenv(providers[name].KeyEnv, "")
+	}
+}
+
+// Test questions are classified into the expected categories
+func TestClassifyQuestion(t *testing.T) {
+	cases := map[string]string{
+		"Why does `x := <-ch` block forever in my goroutine?":       categoryCode,
+		"How do I write a regex for Brazilian phone numbers?":       categoryCode,
+		"What is 17 * 23 + 4?":                                      categoryMath,
+		"Quanto é a derivada de x ao quadrado?":                     categoryMath,
+		"Write a haiku about the sea":                               categoryCreative,
+		"Write a story about java island":                           categoryCreative,
+		"What does p->next point to after the loop?":                categoryCode,
+		"Does `a == b` compare the pointers or the values?":         categoryCode,
+		"Plan a Lisbon -> Porto trip with a stop in Coimbra":        categoryGeneral,
+		"Rate the hotel from 1 to 5, where 5 == excellent":          categoryGeneral,
+		"Qual é o endereço do CEP 01310-100 e como chego lá?":       categoryPortuguese,
+		"What is the capital of Australia?":                         categoryGeneral,
+		strings.Repeat("A long report paragraph goes here. ", 3000): categoryLongContext,
+	}
+	for question, expected := range cases {
+		if got, reason := classifyQuestion(question); got != expected || reason == "" {
+			t.Errorf("Expected '%s' for %.40q, got '%s' (%s)", expected, question, got, reason)
+		}
+	}
+}
+
+// Test candidates without a key are skipped and faster providers score higher
+func TestRouteScoresCandidates(t *testing.T) {
+	useRoutingState(t)
+	t.Setenv("CLAUDE_API_KEY", "test-claude-key")
+	t.Setenv("OPENAI_API_KEY", "test-openai-key")
+
+	for i := 0; i < 5; i++ {
+		providerLatency.observe("claude", 4*time.Second)
+		providerLatency.observe("openai", 500*time.Millisecond)
+	}
+
+	decision, err := route("Fix this: `func main() {}`", "", 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if decision.Category != categoryCode {
+		t.Fatalf("Expected the code route, got %s", decision.Category)
+	}
+	best, last := decision.Candidates[0], decision.Candidates[len(decision.Candidates)-1]
+	if best.Provider != "openai" || best.LatencyMS != 500 {
+		t.Errorf("Expected the faster, cheaper openai candidate first, got %+v", decision.Candidates)
+	}
+	if last.Provider != "mistral" || last.Skipped != "no API key" {
+		t.Errorf("Expected mistral to be skipped for its missing key, got %+v", last)
+	}
+
+	rules := filepath.Join(t.TempDir(), "routes.yaml")
+	os.WriteFile(rules, []byte("weights:\n  latency: 0\n  cost: 0\nroutes:\n  code:\n    - provider: claude\n      model: claude-custom\n"), 0644)
+	t.Setenv("ROUTING_RULES", rules)
+
+	decision, err = route("Fix this: `func main() {}`", "", 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(decision.Candidates) != 1 || decision.Candidates[0].Model != "claude-custom" {
+		t.Errorf("Expected the code route from the rules file, got %+v", decision.Candidates)
+	}
+	if general, _ := route("What time is it?", "", 0); len(general.Candidates) != 4 {
+		t.Errorf("Expected categories missing from the file to keep their default route, got %+v", general.Candidates)
+	}
+
+	os.WriteFile(rules, []byte("routes:\n  poetry:\n    - provider: claude\n"), 0644)
+	if _, err := route("hi", "", 0); err == nil {
+		t.Errorf("Expected an error for an unknown category in the rules file")
+	}
+}
+
+// Test ask_best falls back to the next candidate and reports the route taken
+func TestAskBestFallback(t *testing.T) {
+	useRoutingState(t)
+	useHistory(t)
+	t.Setenv("CLAUDE_API_KEY", "test-claude-key")
+	t.Setenv("OPENAI_API_KEY", "test-openai-key")
+
+	useProviderURL(t, &claudeMessagesURL, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":{"message":"overloaded"}}`))
+	})
+	useProviderURL(t, &openAIChatURL, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[{"message":{"content":"Canberra"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":1}}`))
+	})
+	providerLatency.observe("claude", 100*time.Millisecond)
+	providerLatency.observe("openai", 3*time.Second)
+
+	result, decision, err := askBest(context.Background(), AskBestArguments{Question: "What is the capital of Australia?"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Text != "Canberra" || decision.Chosen.Provider != "openai" {
+		t.Errorf("Expected the openai fallback to answer, got '%s' from %+v", result.Text, decision.Chosen)
+	}
+	report := decision.String()
+	if !strings.Contains(report, "route: general -> openai") || !strings.Contains(report, "fallback: claude/") {
+		t.Errorf("Expected the route and the fallback in the report, got '%s'", report)
+	}
+
+	dry, decision, err := askBest(context.Background(), AskBestArguments{Question: "Write a poem", DryRun: true})
+	if err != nil || dry != nil || decision.Category != categoryCreative || decision.Chosen == nil {
+		t.Errorf("Expected a dry run to only report the creative route, got %v %+v (%v)", dry, decision, err)
+	}
+}
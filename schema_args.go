@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/invopop/jsonschema"
+	mcp_golang "github.com/metoro-io/mcp-golang"
+)
+
+// dynamicArguments are the arguments of a tool declared at runtime (plugins,
+// OpenAPI operations), passed on as the decoded JSON the client sent
+type dynamicArguments map[string]interface{}
+
+// mcp-golang reflects a tool's input schema from its argument type when the tool
+// is registered. Every runtime-declared tool shares dynamicArguments, so its
+// JSONSchema method returns the schema of the tool being registered, which
+// registerDynamic sets for the duration of the registration.
+var (
+	dynamicSchemaMu sync.Mutex
+	dynamicSchema   *jsonschema.Schema
+)
+
+// JSONSchema implements the invopop/jsonschema custom schema hook
+func (dynamicArguments) JSONSchema() *jsonschema.Schema {
+	if dynamicSchema == nil {
+		return &jsonschema.Schema{Type: "object", Properties: jsonschema.NewProperties()}
+	}
+	return dynamicSchema
+}
+
+// parseInputSchema turns a decoded JSON or YAML input schema into a JSON Schema.
+// No schema means a tool without arguments; otherwise it must describe an object.
+func parseInputSchema(raw interface{}) (*jsonschema.Schema, error) {
+	schema := &jsonschema.Schema{}
+	if raw != nil {
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return nil, fmt.Errorf("input schema: %v", err)
+		}
+		if err := json.Unmarshal(data, schema); err != nil {
+			return nil, fmt.Errorf("input schema: %v", err)
+		}
+	}
+	if schema.Type == "" {
+		schema.Type = "object"
+	}
+	if schema.Type != "object" {
+		return nil, fmt.Errorf("input schema must be an object, got %q", schema.Type)
+	}
+	if schema.Properties == nil {
+		schema.Properties = jsonschema.NewProperties()
+	}
+	return schema, nil
+}
+
+// checkArguments rejects arguments that miss a required property or whose
+// top-level values don't have the type the schema declares
+func checkArguments(schema *jsonschema.Schema, args map[string]interface{}) error {
+	var missing []string
+	for _, name := range schema.Required {
+		if value, ok := args[name]; !ok || value == nil {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required argument %s", strings.Join(missing, ", "))
+	}
+
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prop, ok := schema.Properties.Get(name)
+		if !ok || prop == nil || prop.Type == "" || args[name] == nil {
+			continue
+		}
+		if !hasJSONType(args[name], prop.Type) {
+			return fmt.Errorf("argument %s must be of type %s", name, prop.Type)
+		}
+	}
+	return nil
+}
+
+// hasJSONType reports whether a decoded JSON value is of a JSON Schema type
+func hasJSONType(value interface{}, typ string) bool {
+	switch v := value.(type) {
+	case string:
+		return typ == "string"
+	case bool:
+		return typ == "boolean"
+	case float64:
+		return typ == "number" || (typ == "integer" && v == math.Trunc(v))
+	case []interface{}:
+		return typ == "array"
+	case map[string]interface{}:
+		return typ == "object"
+	}
+	return false
+}
+
+// registerDynamic registers a tool whose arguments are described by a schema
+// known only at runtime. The arguments are checked against the schema before fn
+// gets them.
+func registerDynamic(tools *toolRegistry, name, description string, schema *jsonschema.Schema, fn func(ctx context.Context, args map[string]interface{}) (*mcp_golang.ToolResponse, error)) error {
+	dynamicSchemaMu.Lock()
+	defer dynamicSchemaMu.Unlock()
+	dynamicSchema = schema
+	defer func() { dynamicSchema = nil }()
+
+	return tools.register(name, description, func(ctx context.Context, args dynamicArguments) (*mcp_golang.ToolResponse, error) {
+		if args == nil {
+			args = dynamicArguments{}
+		}
+		if err := checkArguments(schema, args); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return fn(ctx, args)
+	})
+}
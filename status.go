@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// huggingFaceWhoAmIURL is a variable so tests can point it at a mock server
+var huggingFaceWhoAmIURL = "https://huggingface.co/api/whoami-v2"
+
+const (
+	defaultStatusTimeout  = 5 * time.Second
+	defaultStatusCacheTTL = time.Minute
+)
+
+// Provider status values reported by provider_status
+const (
+	statusOK            = "ok"
+	statusMissingKey    = "missing_key"
+	statusInvalidKey    = "invalid_key"
+	statusQuotaExceeded = "quota_exceeded"
+	statusUnreachable   = "unreachable"
+)
+
+type ProviderStatusArguments struct {
+	Provider string `json:"provider" jsonschema:"description=Only check this provider: claude\\, openai\\, gemini\\, mistral or huggingface (default: all)"`
+	Refresh  bool   `json:"refresh" jsonschema:"description=Ignore cached results and check again"`
+}
+
+// ProviderStatus is the result of a live credential check against one provider
+type ProviderStatus struct {
+	Provider  string    `json:"provider"`
+	Status    string    `json:"status"`
+	LatencyMS int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+	Cached    bool      `json:"cached"`
+
+	// Breaker is the provider's circuit breaker as of this answer, never cached
+	Breaker BreakerState `json:"breaker"`
+}
+
+// statusCache keeps the last check per provider for PROVIDER_STATUS_TTL
+type statusCache struct {
+	mu      sync.Mutex
+	entries map[string]ProviderStatus
+}
+
+var providerStatusCache = &statusCache{entries: map[string]ProviderStatus{}}
+
+func (c *statusCache) get(name string) (ProviderStatus, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[name]
+	if !ok || time.Since(entry.CheckedAt) > durationFromEnv("PROVIDER_STATUS_TTL", defaultStatusCacheTTL) {
+		return ProviderStatus{}, false
+	}
+	return entry, true
+}
+
+func (c *statusCache) put(entry ProviderStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[entry.Provider] = entry
+}
+
+// providerStatuses checks the requested provider, or all of them in parallel
+func providerStatuses(only string, refresh bool) ([]ProviderStatus, error) {
+	names := providerNames()
+	if only != "" {
+		if _, ok := providers[only]; !ok {
+			return nil, fmt.Errorf("unknown provider %q", only)
+		}
+		names = []string{only}
+	}
+
+	results := make([]ProviderStatus, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			results[i] = checkProvider(name, refresh)
+		}(i, name)
+	}
+	wg.Wait()
+
+	for i := range results {
+		results[i].Breaker = providerBreakers.state(results[i].Provider)
+	}
+	return results, nil
+}
+
+// checkProvider runs the provider's cheap authenticated call with a short timeout
+func checkProvider(name string, refresh bool) ProviderStatus {
+	if !refresh {
+		if entry, ok := providerStatusCache.get(name); ok {
+			entry.Cached = true
+			return entry
+		}
+	}
+
+	p := providers[name]
+	result := ProviderStatus{Provider: name, CheckedAt: time.Now().UTC()}
+	if !hasAPIKey(p.KeyEnv) {
+		result.Status = statusMissingKey
+		result.Error = p.KeyEnv + " not found in environment"
+		providerStatusCache.put(result)
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), durationFromEnv("PROVIDER_STATUS_TIMEOUT", defaultStatusTimeout))
+	defer cancel()
+
+	start := time.Now()
+	err := p.Ping(ctx)
+	result.LatencyMS = time.Since(start).Milliseconds()
+	result.Status = classifyProviderError(err)
+	if err != nil {
+		result.Error = redactError(err).Error()
+	}
+
+	providerStatusCache.put(result)
+	return result
+}
+
+// providerAvailable reports whether a provider is worth sending work to: it has a
+// key, its circuit breaker isn't open and its last known check didn't find the key
+// invalid, exhausted or unreachable. Providers that haven't been checked yet are
+// assumed to be available.
+func providerAvailable(name string) bool {
+	p, ok := providers[name]
+	if !ok || !hasAPIKey(p.KeyEnv) || providerBreakers.state(name).State == breakerOpen {
+		return false
+	}
+	entry, ok := providerStatusCache.get(name)
+	return !ok || entry.Status == statusOK
+}
+
+// classifyProviderError maps a check failure to a provider status
+func classifyProviderError(err error) string {
+	if err == nil {
+		return statusOK
+	}
+
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		return statusUnreachable
+	}
+
+	switch {
+	case apiErr.StatusCode == 401 || apiErr.StatusCode == 403:
+		return statusInvalidKey
+	case apiErr.StatusCode == 402 || apiErr.StatusCode == 429:
+		return statusQuotaExceeded
+	case apiErr.StatusCode == 400 && strings.Contains(strings.ToLower(apiErr.Message), "api key"):
+		// Gemini answers 400 "API key not valid" instead of 401
+		return statusInvalidKey
+	default:
+		return statusUnreachable
+	}
+}
+
+// durationFromEnv parses a Go duration such as "5s" from the environment
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(name)); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
+
+func pingClaude(ctx context.Context) error {
+	headers := map[string]string{"x-api-key": providerKey(ctx, "CLAUDE_API_KEY"), "anthropic-version": "2023-06-01"}
+	var out struct{}
+	return getProviderJSON(ctx, "Claude", claudeModelsURL+"?limit=1", headers, &out)
+}
+
+func pingOpenAI(ctx context.Context) error {
+	var out struct{}
+	return getProviderJSON(ctx, "OpenAI", openAIModelsURL, map[string]string{"Authorization": "Bearer " + providerKey(ctx, "OPENAI_API_KEY")}, &out)
+}
+
+func pingGemini(ctx context.Context) error {
+	var out struct{}
+	return getProviderJSON(ctx, "Gemini", strings.TrimSuffix(geminiBaseURL, "/")+"?pageSize=1", map[string]string{"x-goog-api-key": providerKey(ctx, "GEMINI_API_KEY")}, &out)
+}
+
+func pingMistral(ctx context.Context) error {
+	var out struct{}
+	return getProviderJSON(ctx, "Mistral", mistralModelsURL, map[string]string{"Authorization": "Bearer " + providerKey(ctx, "MISTRAL_API_KEY")}, &out)
+}
+
+func pingHuggingFace(ctx context.Context) error {
+	var out struct{}
+	return getProviderJSON(ctx, "Hugging Face", huggingFaceWhoAmIURL, map[string]string{"Authorization": "Bearer " + providerKey(ctx, "HUGGINGFACEHUB_API_TOKEN")}, &out)
+}
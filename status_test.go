@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Test check failures map to the documented statuses
+func TestClassifyProviderError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{nil, statusOK},
+		{upstreamError("Claude", 401, []byte(`{"error":{"message":"invalid x-api-key"}}`)), statusInvalidKey},
+		{upstreamError("Gemini", 400, []byte(`{"error":{"message":"API key not valid. Please pass a valid API key."}}`)), statusInvalidKey},
+		{upstreamError("OpenAI", 429, []byte(`{"error":{"message":"You exceeded your current quota"}}`)), statusQuotaExceeded},
+		{upstreamError("Mistral", 503, []byte(`unavailable`)), statusUnreachable},
+		{errors.New("dial tcp: lookup api.mistral.ai: no such host"), statusUnreachable},
+	}
+
+	for _, c := range cases {
+		if got := classifyProviderError(c.err); got != c.want {
+			t.Errorf("classifyProviderError(%v): expected '%s', got '%s'", c.err, c.want, got)
+		}
+	}
+}
+
+// Test a live check, its cache and the missing key case
+func TestProviderStatuses(t *testing.T) {
+	providerStatusCache = &statusCache{entries: map[string]ProviderStatus{}}
+	t.Setenv("OPENAI_API_KEY", "test-openai-key")
+	t.Setenv("MISTRAL_API_KEY", "")
+
+	calls := 0
+	useProviderURL(t, &openAIModelsURL, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get("Authorization") != "Bearer test-openai-key" {
+			t.Error("Expected the key to be sent")
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error":{"message":"Rate limit reached"}}`))
+	})
+
+	result, err := providerStatuses("openai", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result[0].Status != statusQuotaExceeded || result[0].Cached {
+		t.Errorf("Expected a fresh quota_exceeded status, got %+v", result[0])
+	}
+	if providerAvailable("openai") {
+		t.Error("Expected an exhausted provider to be unavailable")
+	}
+
+	cached, _ := providerStatuses("openai", false)
+	if !cached[0].Cached || calls != 1 {
+		t.Errorf("Expected a cached status after 1 call, got cached=%t calls=%d", cached[0].Cached, calls)
+	}
+
+	missing, _ := providerStatuses("mistral", false)
+	if missing[0].Status != statusMissingKey {
+		t.Errorf("Expected missing_key, got '%s'", missing[0].Status)
+	}
+}
+
+// Test a provider that doesn't answer within the timeout is unreachable
+func TestProviderStatusTimeout(t *testing.T) {
+	providerStatusCache = &statusCache{entries: map[string]ProviderStatus{}}
+	t.Setenv("PROVIDER_STATUS_TIMEOUT", "50ms")
+	t.Setenv("CLAUDE_API_KEY", "test-claude-key")
+
+	useProviderURL(t, &claudeModelsURL, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+
+	result, _ := providerStatuses("claude", true)
+	if result[0].Status != statusUnreachable {
+		t.Errorf("Expected unreachable, got %+v", result[0])
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Defaults and limits for the summarize tool. Token counts are estimated, see estimateTokens.
+const (
+	defaultSummarizeProvider    = "claude"
+	defaultSummaryChunkTokens   = 3000
+	defaultSummaryOverlapTokens = 150
+	minSummaryChunkTokens       = 200
+	defaultSummarizeConcurrency = 4
+	maxSummarizeFileBytes       = 20 << 20
+	maxSummaryReduceLevels      = 8
+	summaryPartialMaxTokens     = 600
+	summaryFinalMaxTokens       = 1000
+	approxCharsPerToken         = 4
+)
+
+// Summary styles
+const (
+	summaryStyleBullets  = "bullets"
+	summaryStyleAbstract = "abstract"
+	summaryStyleTLDR     = "tldr"
+)
+
+// Instructions for the map and reduce steps
+const (
+	summaryPartialInstruction = "Summarize this part of a longer document. Keep every key fact, name, number and conclusion; drop repetition. Answer with the summary only."
+	summaryCombineInstruction = "These are summaries of consecutive parts of one document. Merge them into a single summary that keeps every key fact, name, number and conclusion. Answer with the summary only."
+)
+
+var summaryStyles = map[string]string{
+	summaryStyleBullets:  "as a concise bulleted list of the key points",
+	summaryStyleAbstract: "as a single-paragraph abstract written in the third person",
+	summaryStyleTLDR:     "as a TL;DR of one or two sentences",
+}
+
+type SummarizeArguments struct {
+	Text          string `json:"text" jsonschema:"description=Text to summarize; use either text or file_path"`
+	FilePath      string `json:"file_path" jsonschema:"description=Path of a text file to summarize"`
+	Style         string `json:"style" jsonschema:"description=bullets\\, abstract or tldr (default: bullets)"`
+	Provider      string `json:"provider" jsonschema:"description=Provider to use: claude\\, openai\\, gemini\\, mistral or huggingface (default: claude)"`
+	Model         string `json:"model" jsonschema:"description=Model to use (default: the provider's default model)"`
+	ChunkTokens   int    `json:"chunk_tokens" jsonschema:"description=Approximate tokens per chunk (default: 3000)"`
+	OverlapTokens int    `json:"overlap_tokens" jsonschema:"description=Approximate tokens repeated between consecutive chunks (default: 150)"`
+	Concurrency   int    `json:"concurrency" jsonschema:"description=How many chunks are summarized at once (default: 4\\, max: 16)"`
+}
+
+// summary is the result of a map-reduce summarization
+type summary struct {
+	Text         string
+	Chunks       int
+	ReduceLevels int
+	InputTokens  int
+	OutputTokens int
+}
+
+func (s *summary) String() string {
+	return fmt.Sprintf("%s\n\nchunks: %d\nreduce_levels: %d\ninput_tokens: %d\noutput_tokens: %d", s.Text, s.Chunks, s.ReduceLevels, s.InputTokens, s.OutputTokens)
+}
+
+// summarizeInput returns the text to summarize from either argument
+func summarizeInput(args SummarizeArguments) (string, error) {
+	if (args.Text == "") == (args.FilePath == "") {
+		return "", fmt.Errorf("pass either text or file_path")
+	}
+	if args.Text != "" {
+		return args.Text, nil
+	}
+
+	info, err := os.Stat(args.FilePath)
+	if err != nil {
+		return "", err
+	}
+	if info.Size() > maxSummarizeFileBytes {
+		return "", fmt.Errorf("%s is %d bytes; the limit is %d", args.FilePath, info.Size(), maxSummarizeFileBytes)
+	}
+	data, err := os.ReadFile(args.FilePath)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(data) {
+		return "", fmt.Errorf("%s is not UTF-8 text", args.FilePath)
+	}
+	return string(data), nil
+}
+
+// summarizeDocument splits text into token-budgeted chunks, summarizes them in
+// parallel and merges the partial summaries level by level until one remains,
+// which is rewritten in the requested style
+func summarizeDocument(ctx context.Context, args SummarizeArguments) (*summary, error) {
+	text, err := summarizeInput(args)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("nothing to summarize")
+	}
+
+	style := strings.ToLower(defaultString(strings.TrimSpace(args.Style), summaryStyleBullets))
+	styleInstruction, ok := summaryStyles[style]
+	if !ok {
+		return nil, fmt.Errorf("unknown style %q: use bullets, abstract or tldr", args.Style)
+	}
+	name := strings.ToLower(defaultString(strings.TrimSpace(args.Provider), defaultSummarizeProvider))
+	if _, ok := providers[name]; !ok {
+		return nil, fmt.Errorf("unknown provider %q", args.Provider)
+	}
+
+	chunkTokens := defaultInt(args.ChunkTokens, defaultSummaryChunkTokens)
+	if chunkTokens < minSummaryChunkTokens {
+		chunkTokens = minSummaryChunkTokens
+	}
+	overlapTokens := args.OverlapTokens
+	if overlapTokens <= 0 {
+		overlapTokens = defaultSummaryOverlapTokens
+	}
+	if overlapTokens > chunkTokens/4 {
+		overlapTokens = chunkTokens / 4
+	}
+	concurrency := defaultInt(args.Concurrency, defaultSummarizeConcurrency)
+	if concurrency > maxBatchConcurrency {
+		concurrency = maxBatchConcurrency
+	}
+
+	s := &summary{}
+	parts := splitIntoChunks(text, chunkTokens, overlapTokens)
+	s.Chunks = len(parts)
+
+	// Map: summarize every chunk; a short document goes straight to the final pass
+	if len(parts) > 1 {
+		parts, err = summarizeParts(ctx, s, name, args.Model, concurrency, parts, func(i, n int) string {
+			return fmt.Sprintf("%s\n\nPart %d of %d:\n\n", summaryPartialInstruction, i+1, n)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Reduce: merge groups of partial summaries that fit in a chunk until one is left
+	for len(parts) > 1 {
+		if s.ReduceLevels == maxSummaryReduceLevels {
+			return nil, fmt.Errorf("summaries did not converge after %d merge levels; try a larger chunk_tokens", maxSummaryReduceLevels)
+		}
+		s.ReduceLevels++
+
+		groups := groupForReduce(parts, chunkTokens)
+		if len(groups) == len(parts) {
+			// Every summary fills a chunk on its own; merge them pairwise to make progress
+			groups = groupPairs(parts)
+		}
+		parts, err = summarizeParts(ctx, s, name, args.Model, concurrency, groups, func(i, n int) string {
+			return summaryCombineInstruction + "\n\n"
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	prompt := fmt.Sprintf("Summarize the following %s. Answer with the summary only.\n\n%s", styleInstruction, parts[0])
+	result, err := ask(ctx, name, completionRequest{Model: args.Model, Messages: userMessage(prompt), MaxTokens: summaryFinalMaxTokens}, continueOptions{Auto: true})
+	if err != nil {
+		return nil, fmt.Errorf("final summary: %w", err)
+	}
+	s.InputTokens += result.InputTokens
+	s.OutputTokens += result.OutputTokens
+	s.Text = strings.TrimSpace(result.Text)
+	return s, nil
+}
+
+// summarizeParts asks for a summary of every part in parallel, keeping order
+func summarizeParts(ctx context.Context, s *summary, name, model string, concurrency int, parts []string, instruction func(i, n int) string) ([]string, error) {
+	out := make([]string, len(parts))
+	errs := make([]error, len(parts))
+	slots := make(chan struct{}, concurrency)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i, part := range parts {
+		wg.Add(1)
+		go func(i int, part string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			req := completionRequest{Model: model, Messages: userMessage(instruction(i, len(parts)) + part), MaxTokens: summaryPartialMaxTokens}
+			result, err := ask(ctx, name, req, continueOptions{})
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			out[i] = strings.TrimSpace(result.Text)
+
+			mu.Lock()
+			s.InputTokens += result.InputTokens
+			s.OutputTokens += result.OutputTokens
+			mu.Unlock()
+		}(i, part)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("summarizing part %d of %d: %w", i+1, len(parts), err)
+		}
+	}
+	return out, nil
+}
+
+// splitIntoChunks cuts text into chunks of about chunkTokens, preferring
+// paragraph, then sentence, then word boundaries. Each chunk after the first
+// starts with the last overlapTokens of the previous one so facts that straddle
+// a boundary are seen whole.
+func splitIntoChunks(text string, chunkTokens, overlapTokens int) []string {
+	maxChars := chunkTokens * approxCharsPerToken
+	overlapChars := overlapTokens * approxCharsPerToken
+
+	var pieces []string
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		if paragraph = strings.TrimSpace(paragraph); paragraph != "" {
+			pieces = append(pieces, splitPiece(paragraph, maxChars-overlapChars)...)
+		}
+	}
+
+	var chunks []string
+	var current strings.Builder
+	for _, piece := range pieces {
+		if current.Len() > 0 && utf8.RuneCountInString(current.String())+utf8.RuneCountInString(piece)+2 > maxChars {
+			chunk := current.String()
+			chunks = append(chunks, chunk)
+			current.Reset()
+			if tail := overlapTail(chunk, overlapChars); tail != "" {
+				current.WriteString(tail)
+				current.WriteString("\n\n")
+			}
+		}
+		if current.Len() > 0 && !strings.HasSuffix(current.String(), "\n\n") {
+			current.WriteString("\n\n")
+		}
+		current.WriteString(piece)
+	}
+	if current.Len() > 0 {
+		chunks = append(chunks, current.String())
+	}
+	return chunks
+}
+
+// splitPiece breaks a paragraph longer than maxChars at sentence ends, or at
+// spaces when a single sentence is still too long
+func splitPiece(paragraph string, maxChars int) []string {
+	if utf8.RuneCountInString(paragraph) <= maxChars {
+		return []string{paragraph}
+	}
+
+	var out []string
+	runes := []rune(paragraph)
+	for len(runes) > maxChars {
+		cut := -1
+		for i := maxChars; i > maxChars/2; i-- {
+			if (runes[i-1] == '.' || runes[i-1] == '!' || runes[i-1] == '?') && runes[i] == ' ' {
+				cut = i
+				break
+			}
+		}
+		if cut < 0 {
+			for i := maxChars; i > maxChars/2; i-- {
+				if runes[i] == ' ' {
+					cut = i
+					break
+				}
+			}
+		}
+		if cut < 0 {
+			cut = maxChars
+		}
+		out = append(out, strings.TrimSpace(string(runes[:cut])))
+		runes = []rune(strings.TrimSpace(string(runes[cut:])))
+	}
+	if len(runes) > 0 {
+		out = append(out, string(runes))
+	}
+	return out
+}
+
+// overlapTail returns about the last overlapChars of chunk, starting at a word
+func overlapTail(chunk string, overlapChars int) string {
+	runes := []rune(chunk)
+	if overlapChars <= 0 || len(runes) <= overlapChars {
+		return ""
+	}
+	tail := string(runes[len(runes)-overlapChars:])
+	if i := strings.IndexAny(tail, " \n"); i >= 0 {
+		tail = tail[i+1:]
+	}
+	return strings.TrimSpace(tail)
+}
+
+// groupForReduce joins consecutive summaries into groups that fit in one chunk
+func groupForReduce(parts []string, chunkTokens int) []string {
+	var groups []string
+	var current []string
+	size := 0
+	for _, part := range parts {
+		tokens := estimateTokens(part)
+		if len(current) > 0 && size+tokens > chunkTokens {
+			groups = append(groups, strings.Join(current, "\n\n---\n\n"))
+			current, size = nil, 0
+		}
+		current = append(current, part)
+		size += tokens
+	}
+	if len(current) > 0 {
+		groups = append(groups, strings.Join(current, "\n\n---\n\n"))
+	}
+	return groups
+}
+
+// groupPairs joins summaries two by two
+func groupPairs(parts []string) []string {
+	var groups []string
+	for i := 0; i < len(parts); i += 2 {
+		if i+1 < len(parts) {
+			groups = append(groups, parts[i]+"\n\n---\n\n"+parts[i+1])
+		} else {
+			groups = append(groups, parts[i])
+		}
+	}
+	return groups
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Test chunks respect the budget and repeat the end of the previous chunk
+func TestSplitIntoChunks(t *testing.T) {
+	var paragraphs []string
+	for i := 0; i < 40; i++ {
+		paragraphs = append(paragraphs, strings.Repeat("word ", 30)+"end.")
+	}
+	text := strings.Join(paragraphs, "\n\n")
+
+	chunks := splitIntoChunks(text, 200, 20)
+	if len(chunks) < 4 {
+		t.Fatalf("Expected several chunks, got %d", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if estimateTokens(chunk) > 200 {
+			t.Errorf("Chunk %d has %d estimated tokens, over the 200 budget", i, estimateTokens(chunk))
+		}
+		if i > 0 {
+			tail := overlapTail(chunks[i-1], 20*approxCharsPerToken)
+			if tail == "" || !strings.HasPrefix(chunk, tail) {
+				t.Errorf("Expected chunk %d to start with the end of chunk %d", i, i-1)
+			}
+		}
+	}
+
+	if got := splitIntoChunks("short text", 200, 20); len(got) != 1 || got[0] != "short text" {
+		t.Errorf("Expected a short text to stay in one chunk, got %v", got)
+	}
+}
+
+// Test a long document is mapped, reduced and rewritten in the requested style
+func TestSummarizeDocument(t *testing.T) {
+	t.Setenv("CLAUDE_API_KEY", "test-claude-key")
+
+	var mu sync.Mutex
+	var prompts []string
+	useProviderURL(t, &claudeMessagesURL, func(w http.ResponseWriter, r *http.Request) {
+		var req ClaudeRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		prompt := req.Messages[0].Content
+		mu.Lock()
+		prompts = append(prompts, prompt)
+		mu.Unlock()
+
+		answer := strings.Repeat("fact ", 60)
+		if strings.Contains(prompt, "TL;DR") {
+			answer = "Short version."
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"content":     []map[string]string{{"type": "text", "text": answer}},
+			"stop_reason": "end_turn",
+			"usage":       map[string]int{"input_tokens": 10, "output_tokens": 5},
+		})
+	})
+
+	text := strings.Repeat(strings.Repeat("lorem ipsum ", 40)+"\n\n", 30)
+	result, err := summarizeDocument(context.Background(), SummarizeArguments{Text: text, Style: "tldr", ChunkTokens: 250})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if result.Text != "Short version." {
+		t.Errorf("Expected the final styled summary, got '%s'", result.Text)
+	}
+	if result.Chunks < 2 || result.ReduceLevels < 1 {
+		t.Errorf("Expected several chunks and at least one merge level, got %+v", result)
+	}
+	if result.OutputTokens != 5*len(prompts) {
+		t.Errorf("Expected token usage from every call, got %d for %d calls", result.OutputTokens, len(prompts))
+	}
+	if !strings.Contains(result.String(), "chunks: ") {
+		t.Errorf("Expected chunk statistics in the output, got '%s'", result.String())
+	}
+}
+
+// Test invalid summarize arguments
+func TestSummarizeDocumentValidation(t *testing.T) {
+	cases := map[string]SummarizeArguments{
+		"no input":      {},
+		"both inputs":   {Text: "a", FilePath: "b"},
+		"unknown style": {Text: "a", Style: "haiku"},
+		"missing file":  {FilePath: "/does/not/exist.txt"},
+	}
+	for name, args := range cases {
+		if _, err := summarizeDocument(context.Background(), args); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// claudeCountTokensURL is a variable so tests can point it at a mock server.
+// Gemini counts tokens at geminiBaseURL.
+var claudeCountTokensURL = "https://api.anthropic.com/v1/messages/count_tokens"
+
+// Token counting methods reported by count_tokens
+const (
+	tokenMethodAPI      = "api"
+	tokenMethodEstimate = "estimate"
+)
+
+// perMessageTokens approximates the role and formatting tokens each chat message adds
+const perMessageTokens = 4
+
+// knownContextWindows are context windows by model id prefix, used when the
+// provider's model listing hasn't been fetched or doesn't report one. The
+// longest matching prefix wins.
+var knownContextWindows = map[string]int{
+	"claude-":              200000,
+	"gpt-3.5-turbo":        16385,
+	"gpt-4":                8192,
+	"gpt-4-32k":            32768,
+	"gpt-4-0125-preview":   128000,
+	"gpt-4-1106-preview":   128000,
+	"gpt-4-vision-preview": 128000,
+	"gpt-4-turbo":          128000,
+	"gpt-4o":               128000,
+	"gpt-4.1":              1047576,
+	"gpt-5":                400000,
+	"o1":                   200000,
+	"o1-mini":              128000,
+	"o1-preview":           128000,
+	"o3":                   200000,
+	"o4-mini":              200000,
+	"gemini-1.5-pro":       2097152,
+	"gemini-":              1048576,
+	"mistral-tiny":         32000,
+	"mistral-small":        32000,
+	"mistral-medium":       128000,
+	"mistral-large":        128000,
+	"open-mistral-nemo":    128000,
+	"ministral-":           128000,
+	"codestral":            256000,
+}
+
+type CountTokensArguments struct {
+	Text     string `json:"text" jsonschema:"required,description=Text to count tokens for"`
+	Provider string `json:"provider" jsonschema:"description=Only count for this provider: claude\\, openai\\, gemini\\, mistral or huggingface (default: all)"`
+	Model    string `json:"model" jsonschema:"description=Model to count for (default: the provider's default model)"`
+}
+
+// TokenCount is the count_tokens result for one provider
+type TokenCount struct {
+	Provider      string `json:"provider"`
+	Model         string `json:"model"`
+	Tokens        int    `json:"tokens"`
+	Method        string `json:"method"`
+	ContextWindow int    `json:"context_window,omitempty"`
+	Fits          *bool  `json:"fits,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// contextWindowError is returned before a request is sent when the prompt plus
+// the tokens reserved for the answer can't fit in the model's context window
+type contextWindowError struct {
+	Provider       string
+	Model          string
+	InputTokens    int
+	ReservedTokens int
+	ContextWindow  int
+}
+
+func (e *contextWindowError) Error() string {
+	return fmt.Sprintf("request too large for %s: the prompt is about %d tokens and %d are reserved for the answer, but the context window is %d tokens; shorten the input or use the summarize tool",
+		e.Model, e.InputTokens, e.ReservedTokens, e.ContextWindow)
+}
+
+// estimateTokens approximates how a BPE tokenizer splits text: runs of letters
+// cost a token per four characters, digits a token per three, and punctuation,
+// symbols, line breaks and CJK characters a token each. It errs on the high side
+// for English and is close for Portuguese, which is what a preflight check wants.
+func estimateTokens(text string) int {
+	tokens, letters, digits := 0, 0, 0
+	flush := func() {
+		tokens += (letters+3)/4 + (digits+2)/3
+		letters, digits = 0, 0
+	}
+
+	newline := false
+	for _, r := range text {
+		switch {
+		case r == '\n':
+			flush()
+			if !newline {
+				tokens++
+			}
+			newline = true
+			continue
+		case unicode.IsSpace(r):
+			flush()
+		case unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r):
+			flush()
+			tokens++
+		case unicode.IsLetter(r) || unicode.IsMark(r):
+			if digits > 0 {
+				flush()
+			}
+			letters++
+		case unicode.IsDigit(r):
+			if letters > 0 {
+				flush()
+			}
+			digits++
+		default:
+			flush()
+			tokens++
+		}
+		newline = false
+	}
+	flush()
+	return tokens
+}
+
+// estimateMessageTokens estimates the prompt size of a conversation
+func estimateMessageTokens(messages []Message) int {
+	tokens := 3
+	for _, m := range messages {
+		tokens += perMessageTokens + estimateTokens(m.Content)
+	}
+	return tokens
+}
+
+// contextWindow returns the model's context window from the cached model listing
+// or the built-in table; 0 means unknown
+func contextWindow(name, model string) int {
+	if entry, ok := modelListCache.get(name); ok {
+		for _, m := range entry.Models {
+			if m.ID == model && m.ContextWindow > 0 {
+				return m.ContextWindow
+			}
+		}
+	}
+
+	best, window := "", 0
+	for prefix, size := range knownContextWindows {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best, window = prefix, size
+		}
+	}
+	return window
+}
+
+// preflight fails fast when a request can't fit in the model's context window,
+// instead of waiting for the provider's 400. CONTEXT_PREFLIGHT=off disables it.
+func preflight(name string, p *provider, req completionRequest) error {
+	if strings.EqualFold(os.Getenv("CONTEXT_PREFLIGHT"), "off") || p.Model == nil {
+		return nil
+	}
+	model := p.Model(req)
+	window := contextWindow(name, model)
+	if window == 0 {
+		return nil
+	}
+
+	input := estimateMessageTokens(req.Messages)
+	reserved := defaultInt(req.MaxTokens, p.DefaultMaxTokens)
+	if input+reserved > window {
+		return &contextWindowError{Provider: name, Model: model, InputTokens: input, ReservedTokens: reserved, ContextWindow: window}
+	}
+	return nil
+}
+
+// countTokens counts text for the requested provider, or all of them in
+// parallel, using the provider's counting endpoint when it has one and a key is
+// configured, and the local estimate otherwise
+func countTokens(ctx context.Context, args CountTokensArguments) ([]TokenCount, error) {
+	names := providerNames()
+	if only := strings.ToLower(strings.TrimSpace(args.Provider)); only != "" {
+		if _, ok := providers[only]; !ok {
+			return nil, fmt.Errorf("unknown provider %q", args.Provider)
+		}
+		names = []string{only}
+	}
+
+	messages := userMessage(args.Text)
+	results := make([]TokenCount, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			results[i] = countProviderTokens(ctx, name, args.Model, messages)
+		}(i, name)
+	}
+	wg.Wait()
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Provider < results[j].Provider })
+	return results, nil
+}
+
+func countProviderTokens(ctx context.Context, name, model string, messages []Message) TokenCount {
+	p := providers[name]
+	req := completionRequest{Model: model, Messages: messages}
+	if p.Model != nil {
+		model = p.Model(req)
+	}
+	result := TokenCount{Provider: name, Model: model, Method: tokenMethodEstimate, Tokens: estimateMessageTokens(messages)}
+
+	if p.CountTokens != nil && hasAPIKey(p.KeyEnv) {
+		tokens, err := countRemoteTokens(ctx, p, model, messages)
+		if err == nil {
+			result.Tokens, result.Method = tokens, tokenMethodAPI
+		} else {
+			result.Error = "counting endpoint failed, using the estimate: " + redactError(err).Error()
+		}
+	}
+
+	if window := contextWindow(name, model); window > 0 {
+		fits := result.Tokens+p.DefaultMaxTokens <= window
+		result.ContextWindow, result.Fits = window, &fits
+	}
+	return result
+}
+
+// countRemoteTokens calls the provider's counting endpoint, masking personal
+// data first when PII_REDACTION is on, as ask does
+func countRemoteTokens(ctx context.Context, p *provider, model string, messages []Message) (int, error) {
+	if piiRedactionEnabled() {
+		vault, err := newPIIVault()
+		if err != nil {
+			return 0, err
+		}
+		messages = vault.maskRequest(completionRequest{Messages: messages}).Messages
+	}
+	return p.CountTokens(ctx, model, messages)
+}
+
+func countClaudeTokens(ctx context.Context, model string, messages []Message) (int, error) {
+	headers := map[string]string{"x-api-key": providerKey(ctx, "CLAUDE_API_KEY"), "anthropic-version": "2023-06-01"}
+	body := map[string]interface{}{"model": model, "messages": messages}
+
+	var out struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	if err := postProviderJSON(ctx, "Claude", claudeCountTokensURL, headers, body, &out); err != nil {
+		return 0, err
+	}
+	return out.InputTokens, nil
+}
+
+func countGeminiTokens(ctx context.Context, model string, messages []Message) (int, error) {
+	headers := map[string]string{"x-goog-api-key": providerKey(ctx, "GEMINI_API_KEY")}
+	body := map[string]interface{}{"contents": geminiContents(messages)}
+
+	var out struct {
+		TotalTokens int `json:"totalTokens"`
+	}
+	endpoint, err := geminiModelURL(model, "countTokens")
+	if err != nil {
+		return 0, err
+	}
+	if err := postProviderJSON(ctx, "Gemini", endpoint, headers, body, &out); err != nil {
+		return 0, err
+	}
+	return out.TotalTokens, nil
+}
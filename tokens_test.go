@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Test the estimate stays in a sensible range for prose, numbers and CJK text
+func TestEstimateTokens(t *testing.T) {
+	cases := []struct {
+		text     string
+		min, max int
+	}{
+		{"", 0, 0},
+		{"hello", 1, 2},
+		{"The quick brown fox jumps over the lazy dog.", 9, 14},
+		{"1234567890", 3, 4},
+		{"你好世界", 4, 4},
+	}
+	for _, c := range cases {
+		if got := estimateTokens(c.text); got < c.min || got > c.max {
+			t.Errorf("Expected %d-%d tokens for %q, got %d", c.min, c.max, c.text, got)
+		}
+	}
+}
+
+// Test the built-in table picks the most specific entry for dated and preview models
+func TestKnownContextWindows(t *testing.T) {
+	cases := map[string]int{
+		"gpt-4":              8192,
+		"gpt-4-0613":         8192,
+		"gpt-4-32k-0613":     32768,
+		"gpt-4-0125-preview": 128000,
+		"gpt-4-1106-preview": 128000,
+		"gpt-4-turbo":        128000,
+		"o1":                 200000,
+		"o1-2024-12-17":      200000,
+		"o1-mini":            128000,
+		"o1-preview":         128000,
+		"unknown-model":      0,
+	}
+	for model, want := range cases {
+		if got := contextWindow("", model); got != want {
+			t.Errorf("Expected a %d token window for %s, got %d", want, model, got)
+		}
+	}
+}
+
+// Test a request larger than the context window fails before reaching the provider
+func TestPreflightRejectsOversizedRequest(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "test-openai-key")
+	called := false
+	useProviderURL(t, &openAIChatURL, func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.Write([]byte(`{"choices":[{"message":{"content":"ok"},"finish_reason":"stop"}]}`))
+	})
+
+	huge := strings.Repeat("lorem ipsum dolor sit amet ", 20000)
+	_, err := ask(context.Background(), "openai", completionRequest{Model: "gpt-4", Messages: userMessage(huge)}, continueOptions{})
+
+	var windowErr *contextWindowError
+	if !errors.As(err, &windowErr) {
+		t.Fatalf("Expected a context window error, got %v", err)
+	}
+	if windowErr.ContextWindow != 8192 || windowErr.ReservedTokens != defaultMaxTokens {
+		t.Errorf("Unexpected error details %+v", windowErr)
+	}
+	if called {
+		t.Errorf("Expected no upstream call for an oversized request")
+	}
+
+	t.Setenv("CONTEXT_PREFLIGHT", "off")
+	if _, err := ask(context.Background(), "openai", completionRequest{Model: "gpt-4", Messages: userMessage(huge)}, continueOptions{}); err != nil || !called {
+		t.Errorf("Expected CONTEXT_PREFLIGHT=off to send the request, got %v", err)
+	}
+}
+
+// Test count_tokens uses Claude's counting endpoint and falls back to the estimate when it fails
+func TestCountTokensClaude(t *testing.T) {
+	t.Setenv("CLAUDE_API_KEY", "test-claude-key")
+	fail := false
+	useProviderURL(t, &claudeCountTokensURL, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Model    string    `json:"model"`
+			Messages []Message `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.Method != "POST" || body.Model != defaultClaudeModel || body.Messages[0].Content != "count me" {
+			t.Errorf("Unexpected count request %s %+v", r.Method, body)
+		}
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"input_tokens": 42}`))
+	})
+
+	results, err := countTokens(context.Background(), CountTokensArguments{Text: "count me", Provider: "claude"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(results) != 1 || results[0].Tokens != 42 || results[0].Method != tokenMethodAPI {
+		t.Errorf("Expected 42 tokens from the API, got %+v", results)
+	}
+	if results[0].ContextWindow != 200000 || results[0].Fits == nil || !*results[0].Fits {
+		t.Errorf("Expected the prompt to fit the 200000 token window, got %+v", results[0])
+	}
+
+	fail = true
+	results, _ = countTokens(context.Background(), CountTokensArguments{Text: "count me", Provider: "claude"})
+	if results[0].Method != tokenMethodEstimate || results[0].Tokens == 0 || results[0].Error == "" {
+		t.Errorf("Expected a fallback to the estimate, got %+v", results[0])
+	}
+
+	if _, err := countTokens(context.Background(), CountTokensArguments{Text: "x", Provider: "nope"}); err == nil {
+		t.Errorf("Expected an error for an unknown provider")
+	}
+}
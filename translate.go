@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Defaults and limits for the translate tool
+const (
+	defaultTranslateProvider = "claude"
+	translateMaxTokens       = 4096
+	minTranslationFidelity   = 0.5
+	minDetectionHits         = 2
+)
+
+// languageNames maps the language codes translate understands to the names
+// used in prompts. Other languages can still be named in full.
+var languageNames = map[string]string{
+	"pt":    "Portuguese",
+	"pt-br": "Brazilian Portuguese",
+	"pt-pt": "European Portuguese",
+	"en":    "English",
+	"en-us": "American English",
+	"en-gb": "British English",
+	"es":    "Spanish",
+	"fr":    "French",
+	"de":    "German",
+	"it":    "Italian",
+	"nl":    "Dutch",
+	"ru":    "Russian",
+	"ja":    "Japanese",
+	"zh":    "Chinese",
+	"ko":    "Korean",
+}
+
+// languageAliases are native and alternative spellings of the language names
+var languageAliases = map[string]string{
+	"português": "pt",
+	"portugues": "pt",
+	"inglês":    "en",
+	"ingles":    "en",
+	"español":   "es",
+	"espanhol":  "es",
+	"français":  "fr",
+	"deutsch":   "de",
+	"italiano":  "it",
+}
+
+// languageStopwords are frequent short words used to detect the language of a text
+var languageStopwords = map[string][]string{
+	"pt": {"não", "você", "é", "de", "e", "o", "eu", "tudo", "bem", "são", "uma", "um", "com", "para", "os", "as", "do", "da", "dos", "das", "em", "no", "na", "que", "está", "muito", "também", "mas", "ao", "pelo", "pela", "isso", "seu", "sua", "nós", "foi", "tem", "mais", "ou", "quando", "onde", "qual", "como"},
+	"en": {"the", "and", "is", "are", "of", "to", "in", "that", "it", "for", "with", "this", "was", "you", "not", "be", "on", "have", "what", "which", "from", "or"},
+	"es": {"el", "la", "los", "las", "del", "y", "es", "está", "para", "con", "una", "un", "que", "en", "por", "pero", "muy", "también", "no", "usted", "eso", "más", "cuando", "donde", "fue", "tiene"},
+	"fr": {"le", "la", "les", "des", "et", "est", "un", "une", "du", "que", "pour", "dans", "pas", "ce", "qui", "avec", "sur", "je", "vous", "il", "au", "sont"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "zu", "mit", "den", "von", "für", "auf", "ich", "sie", "es", "auch", "wir", "sind"},
+	"it": {"il", "lo", "gli", "della", "di", "che", "è", "non", "per", "una", "sono", "con", "del", "questo", "anche", "ma", "come", "nel"},
+}
+
+// languageMarkers are letter sequences that only one of the detected languages uses
+var languageMarkers = map[string][]string{
+	"pt": {"ção", "ções", "ã", "õ"},
+	"es": {"ñ", "¿", "¡"},
+	"de": {"ß", "ä", "ö", "ü"},
+	"fr": {"è", "œ"},
+}
+
+// Segments the model must not translate: fenced code blocks, inline code and URLs
+var (
+	fencedCodePattern = regexp.MustCompile("(?ms)^[ \t]*(?:```|~~~).*?^[ \t]*(?:```|~~~)[ \t]*$")
+	inlineCodePattern = regexp.MustCompile("`[^`\n]+`")
+	urlPattern        = regexp.MustCompile(`https?://[^\s)>\]]+`)
+	placeholderRegexp = regexp.MustCompile(`⟦\s*(\d+)\s*⟧`)
+	wordPattern       = regexp.MustCompile(`[\p{L}\p{N}]+`)
+)
+
+type TranslateArguments struct {
+	Text           string            `json:"text" jsonschema:"required,description=Text to translate; markdown and code blocks are preserved"`
+	TargetLanguage string            `json:"target_language" jsonschema:"required,description=Language to translate to\\, as a code (pt\\, pt-BR\\, en...) or a name"`
+	SourceLanguage string            `json:"source_language" jsonschema:"description=Language of the text (default: detected)"`
+	Provider       string            `json:"provider" jsonschema:"description=Provider to translate with: claude\\, openai\\, gemini\\, mistral or huggingface (default: claude)"`
+	Model          string            `json:"model" jsonschema:"description=Model to use (default: the provider's default model)"`
+	Glossary       map[string]string `json:"glossary" jsonschema:"description=Terms that must be kept or mapped: each term maps to its translation\\, or to an empty string to keep it as is"`
+	Verify         bool              `json:"verify" jsonschema:"description=Back-translate the result with a second provider and score its fidelity"`
+	VerifyProvider string            `json:"verify_provider" jsonschema:"description=Provider for the back-translation; must differ from provider (default: the first other available provider)"`
+}
+
+// translation is the translate tool's result
+type translation struct {
+	Text           string
+	SourceLanguage string
+	Detected       bool
+	TargetLanguage string
+	Provider       string
+	Model          string
+	InputTokens    int
+	OutputTokens   int
+
+	// Set in verification mode
+	VerifyProvider  string
+	BackTranslation string
+	Fidelity        float64
+}
+
+func (t *translation) String() string {
+	var b strings.Builder
+	b.WriteString(t.Text)
+	source := defaultString(t.SourceLanguage, "unknown")
+	if t.Detected {
+		source += " (detected)"
+	}
+	fmt.Fprintf(&b, "\n\nsource_language: %s\ntarget_language: %s\nprovider: %s", source, t.TargetLanguage, t.Provider)
+	if t.Model != "" {
+		fmt.Fprintf(&b, "\nmodel: %s", t.Model)
+	}
+	fmt.Fprintf(&b, "\ninput_tokens: %d\noutput_tokens: %d", t.InputTokens, t.OutputTokens)
+	if t.VerifyProvider != "" {
+		fmt.Fprintf(&b, "\nverify_provider: %s\nfidelity: %.2f", t.VerifyProvider, t.Fidelity)
+		if t.Fidelity < minTranslationFidelity {
+			b.WriteString(" (low: review the translation)")
+		}
+		b.WriteString("\nback_translation: " + t.BackTranslation)
+	}
+	return b.String()
+}
+
+// normalizeLanguage turns a language code or name into a lowercase code when it
+// knows the language, and returns other names unchanged
+func normalizeLanguage(language string) string {
+	language = strings.TrimSpace(language)
+	lower := strings.ReplaceAll(strings.ToLower(language), "_", "-")
+	if _, ok := languageNames[lower]; ok {
+		return lower
+	}
+	if code, ok := languageAliases[lower]; ok {
+		return code
+	}
+	for code, name := range languageNames {
+		if strings.EqualFold(name, language) {
+			return code
+		}
+	}
+	return language
+}
+
+// languageName is the name of a language code for prompts
+func languageName(language string) string {
+	if name, ok := languageNames[language]; ok {
+		return name
+	}
+	return language
+}
+
+// sameLanguage reports whether two languages share a base code, so pt and pt-br match
+func sameLanguage(a, b string) bool {
+	base := func(s string) string { return strings.SplitN(strings.ToLower(s), "-", 2)[0] }
+	return a != "" && b != "" && base(a) == base(b)
+}
+
+// detectLanguage guesses the language of text from its stopwords and accented
+// letters; it returns "" when no language is a clear winner
+func detectLanguage(text string) string {
+	text = strings.ToLower(text)
+	scores := map[string]int{}
+	for _, word := range wordPattern.FindAllString(text, -1) {
+		for code, words := range languageStopwords {
+			for _, w := range words {
+				if w == word {
+					scores[code]++
+				}
+			}
+		}
+	}
+	for code, markers := range languageMarkers {
+		for _, m := range markers {
+			scores[code] += 2 * strings.Count(text, m)
+		}
+	}
+
+	best, bestScore, runnerUp := "", 0, 0
+	for code, score := range scores {
+		switch {
+		case score > bestScore || (score == bestScore && code < best):
+			best, bestScore, runnerUp = code, score, bestScore
+		case score > runnerUp:
+			runnerUp = score
+		}
+	}
+	if bestScore < minDetectionHits || bestScore == runnerUp {
+		return ""
+	}
+	return best
+}
+
+// protector swaps segments that must survive translation untouched for numbered
+// placeholders, and puts them back afterwards
+type protector struct {
+	segments []string
+}
+
+func (p *protector) placeholder(segment string) string {
+	p.segments = append(p.segments, segment)
+	return fmt.Sprintf("⟦%d⟧", len(p.segments)-1)
+}
+
+func (p *protector) protect(text string, re *regexp.Regexp) string {
+	return re.ReplaceAllStringFunc(text, p.placeholder)
+}
+
+// protectGlossary replaces whole-word, case-insensitive glossary matches with
+// placeholders that restore to the mapped term, or to the original text when the
+// term maps to an empty string. Longer terms win over terms they contain.
+func (p *protector) protectGlossary(text string, glossary map[string]string) string {
+	if len(glossary) == 0 {
+		return text
+	}
+	terms := make([]string, 0, len(glossary))
+	targets := map[string]string{}
+	for term, target := range glossary {
+		if term = strings.TrimSpace(term); term != "" {
+			terms = append(terms, regexp.QuoteMeta(term))
+			targets[strings.ToLower(term)] = strings.TrimSpace(target)
+		}
+	}
+	if len(terms) == 0 {
+		return text
+	}
+	sort.Slice(terms, func(i, j int) bool { return len(terms[i]) > len(terms[j]) })
+	re := regexp.MustCompile("(?i)" + strings.Join(terms, "|"))
+
+	var out strings.Builder
+	last := 0
+	for _, loc := range re.FindAllStringIndex(text, -1) {
+		if !wordBoundary(text, loc[0], loc[1]) {
+			continue
+		}
+		match := text[loc[0]:loc[1]]
+		out.WriteString(text[last:loc[0]])
+		out.WriteString(p.placeholder(defaultString(targets[strings.ToLower(match)], match)))
+		last = loc[1]
+	}
+	out.WriteString(text[last:])
+	return out.String()
+}
+
+// wordBoundary reports whether text[start:end] is not part of a longer word
+func wordBoundary(text string, start, end int) bool {
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) || r == '_' }
+	if before, _ := utf8.DecodeLastRuneInString(text[:start]); start > 0 && isWord(before) {
+		return false
+	}
+	if after, _ := utf8.DecodeRuneInString(text[end:]); end < len(text) && isWord(after) {
+		return false
+	}
+	return true
+}
+
+// restore puts the protected segments back, failing if the model dropped any
+func (p *protector) restore(text string) (string, error) {
+	seen := make([]bool, len(p.segments))
+	text = placeholderRegexp.ReplaceAllStringFunc(text, func(match string) string {
+		i, _ := strconv.Atoi(placeholderRegexp.FindStringSubmatch(match)[1])
+		if i >= len(p.segments) {
+			return match
+		}
+		seen[i] = true
+		return p.segments[i]
+	})
+
+	missing := 0
+	for _, ok := range seen {
+		if !ok {
+			missing++
+		}
+	}
+	if missing > 0 {
+		return "", fmt.Errorf("the translation lost %d of %d protected segments (code, URLs or glossary terms); try again or use another provider", missing, len(p.segments))
+	}
+	return text, nil
+}
+
+// translateText sends one protected translation request and restores the result
+func translateText(ctx context.Context, name, model, text, source, target string, glossary map[string]string) (string, *completion, error) {
+	p := &protector{}
+	protected := p.protect(text, fencedCodePattern)
+	protected = p.protect(protected, inlineCodePattern)
+	protected = p.protect(protected, urlPattern)
+	protected = p.protectGlossary(protected, glossary)
+
+	from := "from its original language"
+	if source != "" {
+		from = "from " + languageName(source)
+	}
+	prompt := fmt.Sprintf("Translate the text below %s to %s. Keep the markdown formatting exactly as it is: headings, lists, emphasis, links and tables. "+
+		"Tokens like ⟦0⟧ stand for code, URLs or fixed terms: copy each one unchanged to the matching place. Answer with the translation only.\n\nText:\n\n%s",
+		from, languageName(target), protected)
+
+	maxTokens := int(math.Min(float64(2*estimateTokens(protected)+100), translateMaxTokens))
+	result, err := ask(ctx, name, completionRequest{Model: model, Messages: userMessage(prompt), MaxTokens: maxTokens}, continueOptions{Auto: true})
+	if err != nil {
+		return "", nil, err
+	}
+	restored, err := p.restore(strings.TrimSpace(result.Text))
+	if err != nil {
+		return "", nil, err
+	}
+	return restored, result, nil
+}
+
+// translate detects the source language, translates with the chosen provider and,
+// in verification mode, back-translates with a second provider to score fidelity
+func translate(ctx context.Context, args TranslateArguments) (*translation, error) {
+	if strings.TrimSpace(args.Text) == "" {
+		return nil, fmt.Errorf("nothing to translate")
+	}
+	target := normalizeLanguage(args.TargetLanguage)
+	if target == "" {
+		return nil, fmt.Errorf("target_language is required")
+	}
+	name := strings.ToLower(defaultString(strings.TrimSpace(args.Provider), defaultTranslateProvider))
+	if _, ok := providers[name]; !ok {
+		return nil, fmt.Errorf("unknown provider %q", args.Provider)
+	}
+
+	t := &translation{TargetLanguage: target, Provider: name, SourceLanguage: normalizeLanguage(args.SourceLanguage)}
+	if t.SourceLanguage == "" {
+		t.SourceLanguage = detectLanguage(args.Text)
+		t.Detected = t.SourceLanguage != ""
+	}
+
+	verifier := ""
+	if args.Verify {
+		if t.SourceLanguage == "" {
+			return nil, fmt.Errorf("the source language could not be detected; pass source_language to verify")
+		}
+		var err error
+		if verifier, err = verifyProvider(args.VerifyProvider, name); err != nil {
+			return nil, err
+		}
+	}
+
+	if sameLanguage(t.SourceLanguage, target) {
+		t.Text = args.Text
+		return t, nil
+	}
+
+	text, result, err := translateText(ctx, name, args.Model, args.Text, t.SourceLanguage, target, args.Glossary)
+	if err != nil {
+		return nil, err
+	}
+	t.Text, t.Model = text, result.Model
+	t.InputTokens, t.OutputTokens = result.InputTokens, result.OutputTokens
+
+	if verifier != "" {
+		back, result, err := translateText(ctx, verifier, "", t.Text, target, t.SourceLanguage, nil)
+		if err != nil {
+			return nil, fmt.Errorf("back-translation with %s failed: %w", verifier, err)
+		}
+		t.VerifyProvider, t.BackTranslation = verifier, back
+		t.Fidelity = wordOverlap(args.Text, back)
+		t.InputTokens += result.InputTokens
+		t.OutputTokens += result.OutputTokens
+	}
+	return t, nil
+}
+
+// verifyProvider validates the requested verification provider, or picks the
+// first other available provider
+func verifyProvider(requested, translator string) (string, error) {
+	if requested = strings.ToLower(strings.TrimSpace(requested)); requested != "" {
+		if _, ok := providers[requested]; !ok {
+			return "", fmt.Errorf("unknown verify_provider %q", requested)
+		}
+		if requested == translator {
+			return "", fmt.Errorf("verify_provider must differ from the translating provider %s, or the check only measures self-consistency", translator)
+		}
+		return requested, nil
+	}
+	for _, name := range providerNames() {
+		if name != translator && providerAvailable(name) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("verification needs a second provider with an API key; set verify_provider")
+}
+
+// wordOverlap scores how many words two texts share, as the F1 of their word
+// multisets; code and URLs are left out so they don't inflate the score
+func wordOverlap(original, back string) float64 {
+	count := func(text string) map[string]int {
+		for _, re := range []*regexp.Regexp{fencedCodePattern, inlineCodePattern, urlPattern} {
+			text = re.ReplaceAllString(text, " ")
+		}
+		words := map[string]int{}
+		for _, w := range wordPattern.FindAllString(strings.ToLower(text), -1) {
+			words[w]++
+		}
+		return words
+	}
+	a, b := count(original), count(back)
+
+	total := func(m map[string]int) (n int) {
+		for _, c := range m {
+			n += c
+		}
+		return n
+	}
+	common := 0
+	for w, c := range a {
+		if b[w] < c {
+			c = b[w]
+		}
+		common += c
+	}
+	if common == 0 {
+		return 0
+	}
+	precision := float64(common) / float64(total(b))
+	recall := float64(common) / float64(total(a))
+	return 2 * precision * recall / (precision + recall)
+}
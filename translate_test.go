@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Test languages, short Portuguese sentences included, are told apart and unclear text is left undetected
+func TestDetectLanguage(t *testing.T) {
+	cases := map[string]string{
+		"O CEP não foi encontrado na base de dados dos Correios.": "pt",
+		"The zip code was not found in the database.":             "en",
+		"El código postal no está en la base de datos.":           "es",
+		"Olá, tudo bem? Preciso de ajuda":                         "pt",
+		"Eu preciso de um carro e uma casa":                       "pt",
+		"O gato e o cachorro":                                     "pt",
+		"Você está à frente":                                      "pt",
+		"Je voudrais un café à Paris":                             "fr",
+		"12345-678":                                               "",
+	}
+	for text, expected := range cases {
+		if got := detectLanguage(text); got != expected {
+			t.Errorf("Expected '%s' for %q, got '%s'", expected, text, got)
+		}
+	}
+}
+
+// Test code, URLs and glossary terms survive translation and are mapped as configured
+func TestTranslateProtectsSegments(t *testing.T) {
+	t.Setenv("CLAUDE_API_KEY", "test-claude-key")
+
+	var prompt string
+	useProviderURL(t, &claudeMessagesURL, func(w http.ResponseWriter, r *http.Request) {
+		var req ClaudeRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		prompt = req.Messages[0].Content
+
+		// Echo the placeholders back in a translated sentence
+		placeholders := regexp.MustCompile(`⟦\d+⟧`).FindAllString(prompt[strings.Index(prompt, "Text:"):], -1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"content":     []map[string]string{{"type": "text", "text": "Look up the " + strings.Join(placeholders, " and ") + " here."}},
+			"stop_reason": "end_turn",
+			"usage":       map[string]int{"input_tokens": 10, "output_tokens": 5},
+		})
+	})
+
+	result, err := translate(context.Background(), TranslateArguments{
+		Text:           "Consulte o CEP com `getCep(id)` no ViaCEP em https://viacep.com.br e veja o bairro.",
+		TargetLanguage: "English",
+		Glossary:       map[string]string{"cep": "zip code", "viacep": ""},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if strings.Contains(prompt, "`getCep(id)`") || strings.Contains(prompt, "https://viacep.com.br") || strings.Contains(prompt, "ViaCEP") {
+		t.Errorf("Expected protected segments to be hidden from the model, got '%s'", prompt)
+	}
+	if !strings.Contains(prompt, "from Portuguese to English") {
+		t.Errorf("Expected the detected source language in the prompt, got '%s'", prompt)
+	}
+	for _, want := range []string{"`getCep(id)`", "https://viacep.com.br", "zip code", "ViaCEP"} {
+		if !strings.Contains(result.Text, want) {
+			t.Errorf("Expected '%s' in the translation, got '%s'", want, result.Text)
+		}
+	}
+	if result.SourceLanguage != "pt" || !result.Detected || result.TargetLanguage != "en" {
+		t.Errorf("Unexpected languages %+v", result)
+	}
+}
+
+// Test a translation that drops a protected segment is rejected
+func TestProtectorRestore(t *testing.T) {
+	p := &protector{}
+	protected := p.protect("run `make` then `make test`", inlineCodePattern)
+	if protected != "run ⟦0⟧ then ⟦1⟧" {
+		t.Fatalf("Unexpected protected text '%s'", protected)
+	}
+	if restored, err := p.restore("rode ⟦0⟧ e depois ⟦ 1 ⟧"); err != nil || restored != "rode `make` e depois `make test`" {
+		t.Errorf("Expected both segments restored, got '%s' (%v)", restored, err)
+	}
+	if _, err := p.restore("rode ⟦0⟧"); err == nil {
+		t.Errorf("Expected an error when a segment is lost")
+	}
+}
+
+// Test verification back-translates with the second provider and scores fidelity
+func TestTranslateVerify(t *testing.T) {
+	t.Setenv("CLAUDE_API_KEY", "test-claude-key")
+	t.Setenv("OPENAI_API_KEY", "test-openai-key")
+
+	useProviderURL(t, &claudeMessagesURL, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"content":[{"type":"text","text":"The street is closed today."}],"stop_reason":"end_turn","usage":{"input_tokens":10,"output_tokens":5}}`))
+	})
+	useProviderURL(t, &openAIChatURL, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[{"message":{"content":"A rua está fechada hoje."},"finish_reason":"stop"}],"usage":{"prompt_tokens":8,"completion_tokens":4}}`))
+	})
+
+	result, err := translate(context.Background(), TranslateArguments{Text: "A rua está fechada hoje.", SourceLanguage: "pt-BR", TargetLanguage: "en", Verify: true, VerifyProvider: "openai"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.VerifyProvider != "openai" || result.Fidelity != 1 || result.OutputTokens != 9 {
+		t.Errorf("Unexpected verification result %+v", result)
+	}
+	if !strings.Contains(result.String(), "fidelity: 1.00") {
+		t.Errorf("Expected the fidelity in the output, got '%s'", result.String())
+	}
+
+	same, err := translate(context.Background(), TranslateArguments{Text: "Olá mundo", SourceLanguage: "pt", TargetLanguage: "pt-BR"})
+	if err != nil || same.Text != "Olá mundo" || same.InputTokens != 0 {
+		t.Errorf("Expected text already in the target language to be returned as is, got %+v (%v)", same, err)
+	}
+}
+
+// Test verification with the translating provider itself is rejected
+func TestVerifyProviderMustDiffer(t *testing.T) {
+	if _, err := verifyProvider("Claude", "claude"); err == nil {
+		t.Errorf("Expected an error when verify_provider is the translator")
+	}
+	if name, err := verifyProvider("openai", "claude"); err != nil || name != "openai" {
+		t.Errorf("Expected openai, got %q (%v)", name, err)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// usageRetentionDays is how many days of usage are kept in the state file
+const usageRetentionDays = 31
+
+// UsageTotals counts calls and tokens for one provider on one day
+type UsageTotals struct {
+	Requests     int `json:"requests"`
+	Errors       int `json:"errors"`
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// usageTracker accumulates token usage per day and provider. It is loaded from
+// the state directory at startup and flushed back on shutdown.
+type usageTracker struct {
+	mu   sync.Mutex
+	path string
+	days map[string]map[string]*UsageTotals // date (UTC, YYYY-MM-DD) -> provider -> totals
+}
+
+var usageStats = newUsageTracker(filepath.Join(stateDir(), "usage.json"))
+
+func newUsageTracker(path string) *usageTracker {
+	return &usageTracker{path: path, days: map[string]map[string]*UsageTotals{}}
+}
+
+// stateDir is where the server keeps state between runs; MCP_STATE_DIR overrides it
+func stateDir() string {
+	if dir := os.Getenv("MCP_STATE_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "mcp-server-test")
+}
+
+// record adds one provider call to today's totals
+func (u *usageTracker) record(provider string, result *completion, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	day := time.Now().UTC().Format("2006-01-02")
+	if u.days[day] == nil {
+		u.days[day] = map[string]*UsageTotals{}
+	}
+	totals := u.days[day][provider]
+	if totals == nil {
+		totals = &UsageTotals{}
+		u.days[day][provider] = totals
+	}
+
+	totals.Requests++
+	if err != nil {
+		totals.Errors++
+		return
+	}
+	totals.InputTokens += result.InputTokens
+	totals.OutputTokens += result.OutputTokens
+}
+
+// day returns a copy of the totals per provider for the given date
+func (u *usageTracker) day(date string) map[string]UsageTotals {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	out := map[string]UsageTotals{}
+	for provider, totals := range u.days[date] {
+		out[provider] = *totals
+	}
+	return out
+}
+
+// today returns the totals per provider for the current UTC day
+func (u *usageTracker) today() map[string]UsageTotals {
+	return u.day(time.Now().UTC().Format("2006-01-02"))
+}
+
+// load reads previously flushed usage; a missing file is not an error
+func (u *usageTracker) load() error {
+	data, err := os.ReadFile(u.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return json.Unmarshal(data, &u.days)
+}
+
+// flush writes usage to the state file, dropping days past the retention period.
+// It writes to a temporary file and renames it so a crash can't leave half a file.
+func (u *usageTracker) flush() error {
+	u.mu.Lock()
+	var dates []string
+	for date := range u.days {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	for len(dates) > usageRetentionDays {
+		delete(u.days, dates[0])
+		dates = dates[1:]
+	}
+	data, err := json.MarshalIndent(u.days, "", "  ")
+	u.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(u.path), 0755); err != nil {
+		return err
+	}
+	tmp := u.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, u.path)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// Test usage survives a flush and reload
+func TestUsageFlushAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "usage.json")
+
+	tracker := newUsageTracker(path)
+	tracker.record("claude", &completion{InputTokens: 10, OutputTokens: 20}, nil)
+	tracker.record("claude", nil, errors.New("boom"))
+	if err := tracker.flush(); err != nil {
+		t.Fatalf("Unexpected flush error: %v", err)
+	}
+
+	reloaded := newUsageTracker(path)
+	if err := reloaded.load(); err != nil {
+		t.Fatalf("Unexpected load error: %v", err)
+	}
+
+	got := reloaded.today()["claude"]
+	want := UsageTotals{Requests: 2, Errors: 1, InputTokens: 10, OutputTokens: 20}
+	if got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
+// Test a missing state file is not an error
+func TestUsageLoadMissingFile(t *testing.T) {
+	tracker := newUsageTracker(filepath.Join(t.TempDir(), "usage.json"))
+	if err := tracker.load(); err != nil {
+		t.Errorf("Expected no error for a missing file, got %v", err)
+	}
+}